@@ -20,6 +20,8 @@ type LoadableCache[K comparable, V any] interface {
 	Values(context.Context) []V
 	// Set a value pair to the cache data by key.
 	Set(ctx context.Context, k K, v V) error
+	// Remove a key from the cache data, returns false if the key is not present.
+	Remove(ctx context.Context, k K) bool
 	// Purge clears all cache data.
 	Purge(context.Context)
 	// TryPurgeAndReload try to refresh cache data, if refresh result is nil, return false.
@@ -38,6 +40,7 @@ type loadableCache[K comparable, V any] struct {
 	traced       bool                    // 是否将普通函数包装为 tracer
 	c            gcache.Cache[K, V]      // gcache 对象
 	exp          time.Duration           // key 过期时间
+	ttl          time.Duration           // 写入后的存活时间, 0 为不过期
 	size         int                     // 缓存大小,超出的缓存会被 evict
 	block        bool                    // 是否阻塞当前调用链
 	retryCount   uint                    // 重试次数 (几次后依旧空数据则认为空数据) 默认0
@@ -63,6 +66,13 @@ func WithExpiration[K comparable, V any](exp time.Duration) Option[K, V] {
 	}
 }
 
+// WithTTL the entries expire ttl after they are set, default is never.
+func WithTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return func(cb *loadableCache[K, V]) {
+		cb.ttl = ttl
+	}
+}
+
 // WithSize cache size limit.
 func WithSize[K comparable, V any](size int) Option[K, V] {
 	return func(cb *loadableCache[K, V]) {
@@ -111,8 +121,11 @@ func New[K comparable, V any](opts ...Option[K, V]) LoadableCache[K, V] {
 	}
 
 	// 创建 gcache 对象
-	cache.c = gcache.New[K, V](cache.size).
-		Build()
+	builder := gcache.New[K, V](cache.size)
+	if cache.ttl > 0 {
+		builder = builder.Expiration(cache.ttl)
+	}
+	cache.c = builder.Build()
 
 	if cache.refresh != nil {
 		cache.ticker = time.NewTicker(cache.exp)
@@ -202,6 +215,13 @@ func (cb *loadableCache[K, V]) Set(ctx context.Context, k K, v V) error {
 	return cb.c.Set(k, v)
 }
 
+func (cb *loadableCache[K, V]) Remove(ctx context.Context, k K) bool {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	return cb.c.Remove(k)
+}
+
 func (cb *loadableCache[K, V]) Stop(ctx context.Context) {
 	cb.stop <- struct{}{}
 }
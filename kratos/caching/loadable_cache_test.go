@@ -233,3 +233,29 @@ func TestFirstLoadErr(t *testing.T) {
 	kvs = cc.GetALL(ctx)
 	assert.Equal(t, 0, len(kvs))
 }
+
+func TestRemove(t *testing.T) {
+	cc := caching.New[int64, string]()
+	ctx := context.Background()
+
+	assert.NoError(t, cc.Set(ctx, 1, "value"))
+	assert.True(t, cc.Remove(ctx, 1))
+	assert.False(t, cc.Remove(ctx, 1))
+
+	_, err := cc.Get(ctx, 1)
+	assert.Error(t, err)
+}
+
+func TestTTL(t *testing.T) {
+	cc := caching.New(caching.WithTTL[int64, string](50 * time.Millisecond))
+	ctx := context.Background()
+
+	assert.NoError(t, cc.Set(ctx, 1, "value"))
+	v, err := cc.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = cc.Get(ctx, 1)
+	assert.Error(t, err)
+}
@@ -88,6 +88,19 @@ func (r *tracedWrapperLoadableCache[K, V]) Set(parentCtx context.Context, k K, v
 	return err
 }
 
+func (r *tracedWrapperLoadableCache[K, V]) Remove(parentCtx context.Context, k K) bool {
+	ctx, span := r.tracer.Start(parentCtx, "loadableCache")
+	defer span.End()
+
+	ok := r.loadableCache.Remove(ctx, k)
+
+	span.SetAttributes(
+		attribute.String("cache.key", fmt.Sprintf("%v", k)),
+		attribute.Bool("cache.key_removed", ok),
+	)
+	return ok
+}
+
 func (r *tracedWrapperLoadableCache[K, V]) Purge(parentCtx context.Context) {
 	ctx, span := r.tracer.Start(parentCtx, "loadableCache")
 	defer span.End()
@@ -0,0 +1,471 @@
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/kratos/orm/encrypt"
+)
+
+const (
+	callBackBeforeName = "audit:before"
+	callBackAfterName  = "audit:after"
+	beforeSettingKey   = "audit:before_rows"
+)
+
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+type operatorKey struct{}
+type skipKey struct{}
+
+// NewContext returns a new context that carries the operator identity.
+func NewContext(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, operatorKey{}, operator)
+}
+
+// FromContext returns the operator identity in ctx.
+func FromContext(ctx context.Context) (string, bool) {
+	operator, ok := ctx.Value(operatorKey{}).(string)
+	return operator, ok
+}
+
+// Skip returns a new context whose statements are not audited.
+func Skip(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipKey{}, true)
+}
+
+func isSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipKey{}).(bool)
+	return skip
+}
+
+// Record an audit row of a created / updated / deleted record.
+type Record struct {
+	ID         int64     `json:"id" gorm:"column:id;primaryKey;autoIncrement;"`
+	Table      string    `json:"table" gorm:"column:table_name;size:128;index;comment:表名"`
+	PrimaryKey string    `json:"primary_key" gorm:"column:primary_key;size:255;index;comment:主键"`
+	Action     Action    `json:"action" gorm:"column:action;size:16;comment:操作类型"`
+	Operator   string    `json:"operator" gorm:"column:operator;size:128;index;comment:操作人"`
+	TraceID    string    `json:"trace_id" gorm:"column:trace_id;size:64;comment:链路ID"`
+	Diff       string    `json:"diff" gorm:"column:diff;type:text;comment:变更内容"`
+	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at;comment:创建时间"`
+}
+
+func (Record) TableName() string {
+	return "audit_records"
+}
+
+// Change the before / after value of a changed column.
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Plugin fills created_by / updated_by from the operator in ctx,
+// and writes an audit Record for every created, updated and deleted row to the Sink.
+//
+// the diff holds the database values of the columns, e.g. the JSON of a `serializer:json` field.
+// the `serializer:encrypted` columns are left out of it, so they are never written in plaintext.
+//
+// Raw / Exec SQL is not audited.
+type Plugin struct {
+	sink            Sink
+	createdByColumn string
+	updatedByColumn string
+	ignoreColumns   map[string]struct{}
+}
+
+type Option func(*Plugin)
+
+// WithSink set audit sink. default is the audit_records table of the same database and transaction.
+func WithSink(sink Sink) Option {
+	return func(p *Plugin) {
+		p.sink = sink
+	}
+}
+
+// WithOperatorColumns set the created_by / updated_by column names, an empty name is not filled.
+func WithOperatorColumns(createdBy, updatedBy string) Option {
+	return func(p *Plugin) {
+		p.createdByColumn = createdBy
+		p.updatedByColumn = updatedBy
+	}
+}
+
+// WithIgnoreColumns the columns left out of the diff. default is updated_at.
+func WithIgnoreColumns(columns ...string) Option {
+	return func(p *Plugin) {
+		p.ignoreColumns = make(map[string]struct{}, len(columns))
+		for _, column := range columns {
+			p.ignoreColumns[column] = struct{}{}
+		}
+	}
+}
+
+func New(opts ...Option) *Plugin {
+	p := &Plugin{
+		sink:            NewDBSink(nil, ""),
+		createdByColumn: "created_by",
+		updatedByColumn: "updated_by",
+		ignoreColumns:   map[string]struct{}{"updated_at": {}},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Plugin) Name() string {
+	return "AuditPlugin"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		name string
+		err  error
+	}{
+		{"before_create", cb.Create().Before("gorm:create").Register(callBackBeforeName+"_create", p.beforeCreate)},
+		{"before_update", cb.Update().Before("gorm:update").Register(callBackBeforeName+"_update", p.beforeUpdate)},
+		{"before_delete", cb.Delete().Before("gorm:delete").Register(callBackBeforeName+"_delete", p.loadBefore)},
+		{"after_create", cb.Create().After("gorm:create").Register(callBackAfterName+"_create", p.afterCreate)},
+		{"after_update", cb.Update().After("gorm:update").Register(callBackAfterName+"_update", p.afterUpdate)},
+		{"after_delete", cb.Delete().After("gorm:delete").Register(callBackAfterName+"_delete", p.afterDelete)},
+	}
+	for _, h := range hooks {
+		if h.err != nil {
+			return fmt.Errorf("register %s hook: %w", h.name, h.err)
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) skip(db *gorm.DB) bool {
+	return db.Error != nil || db.Statement.Schema == nil || isSkipped(db.Statement.Context)
+}
+
+func (p *Plugin) beforeCreate(db *gorm.DB) {
+	if p.skip(db) {
+		return
+	}
+	operator, ok := FromContext(db.Statement.Context)
+	if !ok {
+		return
+	}
+
+	for _, column := range []string{p.createdByColumn, p.updatedByColumn} {
+		if column == "" || db.Statement.Schema.LookUpField(column) == nil {
+			continue
+		}
+		db.Statement.SetColumn(column, operator, true)
+	}
+}
+
+func (p *Plugin) beforeUpdate(db *gorm.DB) {
+	if p.skip(db) {
+		return
+	}
+	if operator, ok := FromContext(db.Statement.Context); ok && p.updatedByColumn != "" {
+		if db.Statement.Schema.LookUpField(p.updatedByColumn) != nil {
+			db.Statement.SetColumn(p.updatedByColumn, operator, true)
+		}
+	}
+	p.loadBefore(db)
+}
+
+// loadBefore reads the rows matched by the statement before they are changed.
+func (p *Plugin) loadBefore(db *gorm.DB) {
+	if p.skip(db) {
+		return
+	}
+	stmt := db.Statement
+
+	var exprs []clause.Expression
+	if where, ok := stmt.Clauses["WHERE"]; ok {
+		exprs = append(exprs, where.Expression)
+	}
+	// the primary key condition of the model is only added when the SQL is built.
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if v, zero := field.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+				exprs = append(exprs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: v})
+			}
+		}
+	}
+	if len(exprs) == 0 {
+		return
+	}
+
+	rows, err := findRows(stmt.Schema, db.Session(&gorm.Session{NewDB: true}).
+		Model(reflect.New(stmt.Schema.ModelType).Interface()).
+		Clauses(exprs...))
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("audit: load %s: %w", stmt.Schema.Table, err))
+		return
+	}
+	stmt.Settings.Store(beforeSettingKey, rows)
+}
+
+func (p *Plugin) afterCreate(db *gorm.DB) {
+	if p.skip(db) {
+		return
+	}
+	stmt := db.Statement
+
+	var records []*Record
+	appendRecord := func(rv reflect.Value) error {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return nil
+		}
+		after := make(map[string]interface{}, len(stmt.Schema.Fields))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || encrypted(field) {
+				continue
+			}
+			v, _ := field.ValueOf(stmt.Context, rv)
+			// the serializer fields are recorded as stored, like the rows reloaded by update.
+			if field.Serializer != nil {
+				var err error
+				if v, err = v.(driver.Valuer).Value(); err != nil {
+					return err
+				}
+			}
+			after[field.DBName] = v
+		}
+		r, err := p.record(db, ActionCreate, after, nil, after)
+		if err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	}
+
+	var err error
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len() && err == nil; i++ {
+			err = appendRecord(stmt.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		err = appendRecord(stmt.ReflectValue)
+	}
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("audit: %s: %w", stmt.Schema.Table, err))
+		return
+	}
+	p.write(db, records)
+}
+
+func (p *Plugin) afterUpdate(db *gorm.DB) {
+	if p.skip(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	rows, _ := stmt.Settings.Load(beforeSettingKey)
+	before, _ := rows.([]map[string]interface{})
+	if len(before) == 0 {
+		return
+	}
+
+	// reload the changed rows by primary key, the assignments may be expressions.
+	conds := make([]clause.Expression, 0, len(before))
+	for _, row := range before {
+		eqs := make([]clause.Expression, 0, len(stmt.Schema.PrimaryFieldDBNames))
+		for _, name := range stmt.Schema.PrimaryFieldDBNames {
+			eqs = append(eqs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: name}, Value: row[name]})
+		}
+		conds = append(conds, clause.And(eqs...))
+	}
+	after, err := findRows(stmt.Schema, db.Session(&gorm.Session{NewDB: true}).
+		Model(reflect.New(stmt.Schema.ModelType).Interface()).
+		Unscoped().
+		Clauses(clause.Where{Exprs: []clause.Expression{clause.Or(conds...)}}))
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("audit: reload %s: %w", stmt.Schema.Table, err))
+		return
+	}
+
+	changed := make(map[string]map[string]interface{}, len(after))
+	for _, row := range after {
+		changed[primaryKey(stmt.Schema, row)] = row
+	}
+
+	records := make([]*Record, 0, len(before))
+	for _, row := range before {
+		a, ok := changed[primaryKey(stmt.Schema, row)]
+		if !ok {
+			continue
+		}
+		r, err := p.record(db, ActionUpdate, row, row, a)
+		if err != nil {
+			_ = db.AddError(fmt.Errorf("audit: %s: %w", stmt.Schema.Table, err))
+			return
+		}
+		records = append(records, r)
+	}
+	p.write(db, records)
+}
+
+func (p *Plugin) afterDelete(db *gorm.DB) {
+	if p.skip(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+	rows, _ := db.Statement.Settings.Load(beforeSettingKey)
+	before, _ := rows.([]map[string]interface{})
+
+	records := make([]*Record, 0, len(before))
+	for _, row := range before {
+		r, err := p.record(db, ActionDelete, row, row, nil)
+		if err != nil {
+			_ = db.AddError(fmt.Errorf("audit: %s: %w", db.Statement.Schema.Table, err))
+			return
+		}
+		records = append(records, r)
+	}
+	p.write(db, records)
+}
+
+// record builds the audit record of a row, the diff only contains the changed columns.
+func (p *Plugin) record(db *gorm.DB, action Action, row, before, after map[string]interface{}) (*Record, error) {
+	stmt := db.Statement
+
+	diff := make(map[string]Change)
+	for column := range mergeKeys(before, after) {
+		if _, ignored := p.ignoreColumns[column]; ignored {
+			continue
+		}
+		if field := stmt.Schema.LookUpField(column); field != nil && encrypted(field) {
+			continue
+		}
+		b, a := before[column], after[column]
+		if action == ActionUpdate && equal(b, a) {
+			continue
+		}
+		diff[column] = Change{Before: b, After: a}
+	}
+	body, err := json.Marshal(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	operator, _ := FromContext(stmt.Context)
+	var traceID string
+	if sc := trace.SpanContextFromContext(stmt.Context); sc.HasTraceID() {
+		traceID = sc.TraceID().String()
+	}
+
+	return &Record{
+		Table:      stmt.Schema.Table,
+		PrimaryKey: primaryKey(stmt.Schema, row),
+		Action:     action,
+		Operator:   operator,
+		TraceID:    traceID,
+		Diff:       string(body),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func (p *Plugin) write(db *gorm.DB, records []*Record) {
+	if len(records) == 0 {
+		return
+	}
+	if err := p.sink.Write(db.Statement.Context, db, records); err != nil {
+		_ = db.AddError(fmt.Errorf("audit: write %s: %w", db.Statement.Schema.Table, err))
+	}
+}
+
+// findRows reads the rows of tx as maps of the database values.
+// Find scans the columns of a model into the field types, which fails for the serializer fields.
+func findRows(s *schema.Schema, tx *gorm.DB) ([]map[string]interface{}, error) {
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var list []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			if field := s.LookUpField(column); field != nil && field.Serializer == nil {
+				values[i] = reflect.New(reflect.PointerTo(field.FieldType)).Interface()
+			} else {
+				values[i] = new(interface{})
+			}
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			rv := reflect.Indirect(reflect.Indirect(reflect.ValueOf(values[i])))
+			if !rv.IsValid() {
+				row[column] = nil
+				continue
+			}
+			row[column] = rv.Interface()
+			if valuer, ok := row[column].(driver.Valuer); ok {
+				row[column], _ = valuer.Value()
+			} else if b, ok := row[column].([]byte); ok && rv.Kind() == reflect.Interface {
+				// the text of a column scanned as is, e.g. a serializer field.
+				row[column] = string(b)
+			}
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// encrypted reports whether field is encrypted by the encrypt.Serializer.
+func encrypted(field *schema.Field) bool {
+	return field.Serializer != nil && field.TagSettings["SERIALIZER"] == encrypt.SerializerName
+}
+
+func primaryKey(s *schema.Schema, row map[string]interface{}) string {
+	keys := make([]string, 0, len(s.PrimaryFieldDBNames))
+	for _, name := range s.PrimaryFieldDBNames {
+		keys = append(keys, fmt.Sprint(row[name]))
+	}
+	return strings.Join(keys, ",")
+}
+
+func mergeKeys(maps ...map[string]interface{}) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, m := range maps {
+		for k := range m {
+			keys[k] = struct{}{}
+		}
+	}
+	return keys
+}
+
+func equal(a, b interface{}) bool {
+	if t, ok := a.(time.Time); ok {
+		if u, ok := b.(time.Time); ok {
+			return t.Equal(u)
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,182 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/audit"
+	"github.com/omalloc/contrib/kratos/orm/encrypt"
+)
+
+type Domain struct {
+	ID        int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Name      string `gorm:"column:name;"`
+	Origin    string `gorm:"column:origin;"`
+	CreatedBy string `gorm:"column:created_by;"`
+	UpdatedBy string `gorm:"column:updated_by;"`
+
+	orm.DBModel
+}
+
+func newTestDB(t *testing.T, opts ...audit.Option) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared")),
+		orm.WithPlugins(audit.New(opts...)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Domain{}, &audit.Record{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func diff(t *testing.T, r *audit.Record) map[string]audit.Change {
+	t.Helper()
+
+	var changes map[string]audit.Change
+	if err := json.Unmarshal([]byte(r.Diff), &changes); err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestPlugin(t *testing.T) {
+	db := newTestDB(t)
+	ctx := audit.NewContext(context.Background(), "alice")
+
+	domain := &Domain{Name: "a.com", Origin: "1.1.1.1"}
+	assert.NoError(t, db.WithContext(ctx).Create(domain).Error)
+	assert.Equal(t, "alice", domain.CreatedBy)
+	assert.Equal(t, "alice", domain.UpdatedBy)
+
+	ctx = audit.NewContext(context.Background(), "bob")
+	assert.NoError(t, db.WithContext(ctx).Model(domain).Updates(map[string]interface{}{
+		"name":   "a.com",
+		"origin": "2.2.2.2",
+	}).Error)
+	assert.NoError(t, db.WithContext(ctx).Delete(domain).Error)
+
+	var records []*audit.Record
+	assert.NoError(t, db.Order("id").Find(&records).Error)
+	if !assert.Len(t, records, 3) {
+		return
+	}
+
+	created := records[0]
+	assert.Equal(t, audit.ActionCreate, created.Action)
+	assert.Equal(t, "domains", created.Table)
+	assert.Equal(t, "1", created.PrimaryKey)
+	assert.Equal(t, "alice", created.Operator)
+	assert.Equal(t, "a.com", diff(t, created)["name"].After)
+
+	updated := records[1]
+	assert.Equal(t, audit.ActionUpdate, updated.Action)
+	assert.Equal(t, "bob", updated.Operator)
+	changes := diff(t, updated)
+	assert.Equal(t, map[string]audit.Change{
+		"origin":     {Before: "1.1.1.1", After: "2.2.2.2"},
+		"updated_by": {Before: "alice", After: "bob"},
+	}, changes)
+
+	deleted := records[2]
+	assert.Equal(t, audit.ActionDelete, deleted.Action)
+	assert.Equal(t, "1", deleted.PrimaryKey)
+	assert.Equal(t, "2.2.2.2", diff(t, deleted)["origin"].Before)
+}
+
+func TestPluginTransaction(t *testing.T) {
+	db := newTestDB(t)
+	ctx := audit.NewContext(context.Background(), "alice")
+
+	// the audit records are rolled back with the change.
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&Domain{Name: "a.com"}).Error; err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.Error(t, err)
+
+	var n int64
+	assert.NoError(t, db.Model(&audit.Record{}).Count(&n).Error)
+	assert.Equal(t, int64(0), n)
+}
+
+type memorySink struct {
+	records []*audit.Record
+}
+
+func (s *memorySink) Write(_ context.Context, _ *gorm.DB, records []*audit.Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func TestPluginSink(t *testing.T) {
+	sink := &memorySink{}
+	db := newTestDB(t, audit.WithSink(sink))
+
+	assert.NoError(t, db.Create([]*Domain{{Name: "a.com"}, {Name: "b.com"}}).Error)
+	assert.NoError(t, db.Model(&Domain{}).Where("name = ?", "b.com").Update("origin", "3.3.3.3").Error)
+
+	if assert.Len(t, sink.records, 3) {
+		assert.Equal(t, "1", sink.records[0].PrimaryKey)
+		assert.Equal(t, "2", sink.records[1].PrimaryKey)
+		assert.Equal(t, audit.ActionUpdate, sink.records[2].Action)
+		assert.Equal(t, "2", sink.records[2].PrimaryKey)
+		assert.Empty(t, sink.records[2].Operator)
+	}
+
+	var n int64
+	assert.NoError(t, db.Model(&audit.Record{}).Count(&n).Error)
+	assert.Equal(t, int64(0), n)
+}
+
+type Profile struct {
+	ID     int64             `gorm:"column:id;primaryKey;autoIncrement;"`
+	Labels map[string]string `gorm:"column:labels;serializer:json;"`
+	Secret string            `gorm:"column:secret;serializer:encrypted;"`
+}
+
+func TestPluginSerializer(t *testing.T) {
+	keys, err := encrypt.StaticKeys("k1", map[string][]byte{"k1": []byte("0123456789abcdef")})
+	assert.NoError(t, err)
+	encrypt.Register(keys)
+
+	db := newTestDB(t)
+	assert.NoError(t, db.AutoMigrate(&Profile{}))
+
+	profile := &Profile{Labels: map[string]string{"env": "prod"}, Secret: "s3cr3t"}
+	assert.NoError(t, db.Create(profile).Error)
+	assert.NoError(t, db.Model(profile).Updates(&Profile{Labels: map[string]string{"env": "dev"}, Secret: "t0p"}).Error)
+
+	var records []*audit.Record
+	assert.NoError(t, db.Order("id").Find(&records).Error)
+	if !assert.Len(t, records, 2) {
+		return
+	}
+
+	// the database values of the serializer fields, the encrypted ones are left out.
+	created := diff(t, records[0])
+	assert.Equal(t, `{"env":"prod"}`, created["labels"].After)
+	assert.NotContains(t, created, "secret")
+
+	updated := diff(t, records[1])
+	assert.Equal(t, `{"env":"prod"}`, updated["labels"].Before)
+	assert.Equal(t, `{"env":"dev"}`, updated["labels"].After)
+	assert.NotContains(t, updated, "secret")
+	for _, r := range records {
+		assert.NotContains(t, r.Diff, "s3cr3t")
+		assert.NotContains(t, r.Diff, "t0p")
+	}
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"gorm.io/gorm"
+)
+
+// Sink stores the audit records, db is the *gorm.DB of the audited statement.
+type Sink interface {
+	Write(ctx context.Context, db *gorm.DB, records []*Record) error
+}
+
+type dbSink struct {
+	db    *gorm.DB
+	table string
+}
+
+// NewDBSink writes the records to table of db.
+//
+// a nil db writes with the connection of the audited statement, so the records are committed
+// or rolled back together with the change. an empty table is audit_records.
+func NewDBSink(db *gorm.DB, table string) Sink {
+	if table == "" {
+		table = Record{}.TableName()
+	}
+	return &dbSink{db: db, table: table}
+}
+
+func (s *dbSink) Write(ctx context.Context, db *gorm.DB, records []*Record) error {
+	ctx = Skip(ctx)
+	if s.db != nil {
+		return s.db.WithContext(ctx).Table(s.table).Create(records).Error
+	}
+	return db.Session(&gorm.Session{NewDB: true, Context: ctx}).Table(s.table).Create(records).Error
+}
+
+type loggerSink struct {
+	log *log.Helper
+}
+
+// NewLoggerSink writes the records to the logger.
+func NewLoggerSink(logger log.Logger) Sink {
+	return &loggerSink{log: log.NewHelper(logger)}
+}
+
+func (s *loggerSink) Write(ctx context.Context, _ *gorm.DB, records []*Record) error {
+	for _, r := range records {
+		s.log.WithContext(ctx).Infow(
+			"msg", "audit",
+			"table", r.Table,
+			"primary_key", r.PrimaryKey,
+			"action", r.Action,
+			"operator", r.Operator,
+			"trace_id", r.TraceID,
+			"diff", r.Diff,
+		)
+	}
+	return nil
+}
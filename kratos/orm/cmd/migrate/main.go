@@ -0,0 +1,211 @@
+// Command migrate runs the versioned SQL migrations of a kratos service.
+//
+//	migrate -conf ./configs -dir ./migrations up [version]
+//	migrate -conf ./configs -dir ./migrations down [steps]
+//	migrate -conf ./configs -dir ./migrations status
+//	migrate -dir ./migrations create <name>
+//
+// the driver and source are read from the DataConf at -key of the kratos config, e.g.
+//
+//	data:
+//	  database:
+//	    driver: mysql
+//	    source: root:root@tcp(127.0.0.1:3306)/test?parseTime=True&loc=Local
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/go-kratos/kratos/v2/config"
+	"github.com/go-kratos/kratos/v2/config/file"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/migrate"
+)
+
+var errUsage = errors.New("usage")
+
+var (
+	flagConf   string
+	flagKey    string
+	flagDriver string
+	flagSource string
+	flagDir    string
+	flagTable  string
+	flagDryRun bool
+)
+
+func init() {
+	flag.StringVar(&flagConf, "conf", "./configs", "config path, eg: -conf config.yaml")
+	flag.StringVar(&flagKey, "key", "data.database", "config key of the DataConf")
+	flag.StringVar(&flagDriver, "driver", "", "database driver (mysql, sqlite), overrides the config")
+	flag.StringVar(&flagSource, "source", "", "database source, overrides the config")
+	flag.StringVar(&flagDir, "dir", "./migrations", "migrations directory")
+	flag.StringVar(&flagTable, "table", "schema_migrations", "table tracking the applied versions")
+	flag.BoolVar(&flagDryRun, "dry-run", false, "print the SQL instead of executing it")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] up [version] | down [steps] | status | create <name>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// dataConf implements orm.DataConf.
+type dataConf struct {
+	Driver string `json:"driver"`
+	Source string `json:"source"`
+}
+
+func (c *dataConf) GetDriver() string { return c.Driver }
+func (c *dataConf) GetSource() string { return c.Source }
+
+func main() {
+	flag.Parse()
+
+	if err := run(context.Background(), flag.Args()); err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Println("no change")
+			return
+		}
+		if errors.Is(err, errUsage) {
+			if err != errUsage {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+			}
+			flag.Usage()
+			os.Exit(2)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if args[0] == "create" {
+		if len(args) < 2 {
+			return errors.New("create: name is required")
+		}
+		up, down, err := migrate.Create(flagDir, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created %s\ncreated %s\n", up, down)
+		return nil
+	}
+
+	conf, err := loadDataConf()
+	if err != nil {
+		return err
+	}
+	db, err := open(conf)
+	if err != nil {
+		return err
+	}
+
+	opts := []migrate.Option{migrate.WithTable(flagTable)}
+	if flagDryRun {
+		opts = append(opts, migrate.WithDryRun(os.Stdout))
+	}
+	m := migrate.New(db, opts...)
+
+	migrations, err := migrate.LoadFS(os.DirFS(flagDir), ".")
+	if err != nil {
+		return err
+	}
+	if err = m.Register(migrations...); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		version, err := intArg(args, 0)
+		if err != nil {
+			return err
+		}
+		return m.UpTo(ctx, version)
+	case "down":
+		steps, err := intArg(args, 1)
+		if err != nil {
+			return err
+		}
+		return m.Down(ctx, int(steps))
+	case "status":
+		return status(ctx, m)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+}
+
+func status(ctx context.Context, m *migrate.Migrator) error {
+	list, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range list {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
+
+func loadDataConf() (orm.DataConf, error) {
+	conf := &dataConf{}
+	if flagDriver == "" || flagSource == "" {
+		c := config.New(config.WithSource(file.NewSource(flagConf)))
+		defer c.Close()
+
+		if err := c.Load(); err != nil {
+			return nil, err
+		}
+		if err := c.Value(flagKey).Scan(conf); err != nil {
+			return nil, fmt.Errorf("read %s from %s: %w", flagKey, flagConf, err)
+		}
+	}
+
+	if flagDriver != "" {
+		conf.Driver = flagDriver
+	}
+	if flagSource != "" {
+		conf.Source = flagSource
+	}
+	return conf, nil
+}
+
+func open(conf orm.DataConf) (*gorm.DB, error) {
+	var driver gorm.Dialector
+	switch conf.GetDriver() {
+	case "mysql":
+		driver = mysql.Open(conf.GetSource())
+	case "sqlite", "sqlite3":
+		driver = sqlite.Open(conf.GetSource())
+	default:
+		return nil, fmt.Errorf("%w: %s", orm.ErrDriverNotFound, conf.GetDriver())
+	}
+
+	return orm.New(orm.WithDriver(driver))
+}
+
+func intArg(args []string, def int64) (int64, error) {
+	if len(args) < 2 {
+		return def, nil
+	}
+	return strconv.ParseInt(args[1], 10, 64)
+}
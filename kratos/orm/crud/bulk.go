@@ -0,0 +1,169 @@
+package crud
+
+import (
+	"context"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+type upsertOptions struct {
+	columns   []string
+	updates   []string
+	doNothing bool
+}
+
+type UpsertOption func(*upsertOptions)
+
+// OnConflict set the conflict columns of the unique index, default is the primary key.
+//
+// MySQL ignores them and updates on any unique key conflict.
+func OnConflict(columns ...string) UpsertOption {
+	return func(o *upsertOptions) {
+		o.columns = columns
+	}
+}
+
+// DoUpdate set the columns updated on conflict, default is every column except the primary key.
+func DoUpdate(columns ...string) UpsertOption {
+	return func(o *upsertOptions) {
+		o.updates = columns
+	}
+}
+
+// DoNothing keeps the conflicting rows.
+func DoNothing() UpsertOption {
+	return func(o *upsertOptions) {
+		o.doNothing = true
+	}
+}
+
+func (r *crud[T, ID]) CreateBatch(ctx context.Context, list []*T) (int64, error) {
+	if len(list) == 0 {
+		return 0, nil
+	}
+
+	result := r.db.WithContext(ctx).
+		Model(new(T)).
+		Create(list)
+	return result.RowsAffected, result.Error
+}
+
+// Upsert the affected count is the one reported by the driver, e.g. MySQL counts an updated row as 2.
+// the version of a Versioned model is bumped on conflict instead of being written.
+func (r *crud[T, ID]) Upsert(ctx context.Context, list []*T, opts ...UpsertOption) (int64, error) {
+	if len(list) == 0 {
+		return 0, nil
+	}
+
+	var o upsertOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	columns, err := r.columns(ctx, o.columns)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		columns = r.schema.PrimaryFieldDBNames
+	}
+
+	var conflict clause.OnConflict
+	for _, c := range columns {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: c})
+	}
+	_, versioned := any(new(T)).(orm.Versioned)
+	switch {
+	case o.doNothing:
+		conflict.DoNothing = true
+	case len(o.updates) > 0:
+		updates, err := r.columns(ctx, o.updates)
+		if err != nil {
+			return 0, err
+		}
+		if versioned {
+			updates = slices.DeleteFunc(updates, func(c string) bool { return c == "version" })
+		}
+		conflict.DoUpdates = clause.AssignmentColumns(updates)
+	case versioned:
+		conflict.DoUpdates = clause.AssignmentColumns(r.upsertColumns())
+	default:
+		conflict.UpdateAll = true
+	}
+	// the version of list is not checked, but bumped so the rows read before are stale.
+	if versioned && !o.doNothing {
+		conflict.DoUpdates = append(conflict.DoUpdates, clause.Assignment{
+			Column: clause.Column{Name: "version"},
+			Value:  gorm.Expr("? + 1", clause.Column{Table: clause.CurrentTable, Name: "version"}),
+		})
+	}
+
+	result := r.db.WithContext(ctx).
+		Model(new(T)).
+		Clauses(conflict).
+		Create(list)
+	return result.RowsAffected, result.Error
+}
+
+// upsertColumns the updatable columns of UpdateAll but the version, written from the conflicting values.
+func (r *crud[T, ID]) upsertColumns() []string {
+	var columns []string
+	for _, f := range r.schema.Fields {
+		if f.DBName != "" && f.Creatable && f.Updatable && !f.PrimaryKey && f.AutoCreateTime == 0 && f.DBName != "version" {
+			columns = append(columns, f.DBName)
+		}
+	}
+	return columns
+}
+
+func (r *crud[T, ID]) DeleteByIDs(ctx context.Context, ids []ID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	where, err := r.where(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	result := r.db.WithContext(ctx).
+		Where(where).
+		Delete(new(T))
+	return result.RowsAffected, result.Error
+}
+
+func (r *crud[T, ID]) UpdateByIDs(ctx context.Context, ids []ID, columns map[string]any) (int64, error) {
+	if len(ids) == 0 || len(columns) == 0 {
+		return 0, nil
+	}
+
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	dbNames, err := r.columns(ctx, names)
+	if err != nil {
+		return 0, err
+	}
+	where, err := r.where(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+	values := make(map[string]any, len(columns)+1)
+	for i, name := range names {
+		values[dbNames[i]] = columns[name]
+	}
+	// the version is bumped, so the rows read before fail their Update with orm.ErrStaleObject.
+	if _, versioned := any(new(T)).(orm.Versioned); versioned {
+		values["version"] = gorm.Expr("version + 1")
+	}
+
+	result := r.db.WithContext(ctx).
+		Model(new(T)).
+		Where(where).
+		Updates(values)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,214 @@
+package crud_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+type Sku struct {
+	ID    int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Code  string `gorm:"column:code;uniqueIndex;"`
+	Name  string `gorm:"column:name;"`
+	Stock int64  `gorm:"column:stock;"`
+}
+
+func newSkuDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Sku{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCRUD_CreateBatch(t *testing.T) {
+	db := newSkuDB(t)
+	repo := crud.New[Sku](db.Session(&gorm.Session{CreateBatchSize: 10}))
+	ctx := context.Background()
+
+	var list []*Sku
+	for i := 0; i < 25; i++ {
+		list = append(list, &Sku{Code: fmt.Sprintf("sku-%02d", i), Stock: 1})
+	}
+	n, err := repo.CreateBatch(ctx, list)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), n)
+	assert.NotZero(t, list[24].ID)
+
+	n, err = repo.CreateBatch(ctx, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestCRUD_Upsert(t *testing.T) {
+	db := newSkuDB(t)
+	repo := crud.New[Sku](db)
+	ctx := context.Background()
+
+	_, err := repo.CreateBatch(ctx, []*Sku{{Code: "a", Name: "a", Stock: 1}, {Code: "b", Name: "b", Stock: 1}})
+	assert.NoError(t, err)
+
+	// only stock is updated on the code conflict.
+	n, err := repo.Upsert(ctx, []*Sku{{Code: "a", Name: "x", Stock: 10}, {Code: "c", Name: "c", Stock: 3}},
+		crud.OnConflict("code"), crud.DoUpdate("stock"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	var skus []*Sku
+	assert.NoError(t, db.Order("code").Find(&skus).Error)
+	if assert.Len(t, skus, 3) {
+		assert.Equal(t, "a", skus[0].Name)
+		assert.Equal(t, int64(10), skus[0].Stock)
+		assert.Equal(t, int64(3), skus[2].Stock)
+	}
+
+	// every column is updated on the primary key conflict.
+	_, err = repo.Upsert(ctx, []*Sku{{ID: skus[1].ID, Code: "b", Name: "y", Stock: 7}})
+	assert.NoError(t, err)
+	got, err := repo.SelectOne(ctx, skus[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "y", got.Name)
+	assert.Equal(t, int64(7), got.Stock)
+
+	n, err = repo.Upsert(ctx, []*Sku{{Code: "b", Name: "z"}}, crud.OnConflict("code"), crud.DoNothing())
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	_, err = repo.Upsert(ctx, []*Sku{{Code: "b"}}, crud.OnConflict("code; DROP TABLE skus"))
+	assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+}
+
+func TestCRUD_UpsertMySQL(t *testing.T) {
+	mockDB, mock, db := setupTestDB(t)
+	defer mockDB.Close()
+
+	repo := crud.New[Sku](db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `skus` .* ON DUPLICATE KEY UPDATE `stock`=VALUES\\(`stock`\\)").
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit()
+
+	n, err := repo.Upsert(context.Background(), []*Sku{{Code: "a", Stock: 1}}, crud.OnConflict("code"), crud.DoUpdate("stock"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCRUD_ByIDs(t *testing.T) {
+	db := newSkuDB(t)
+	repo := crud.New[Sku](db)
+	ctx := context.Background()
+
+	list := []*Sku{{Code: "a"}, {Code: "b"}, {Code: "c"}}
+	_, err := repo.CreateBatch(ctx, list)
+	assert.NoError(t, err)
+
+	n, err := repo.UpdateByIDs(ctx, []int64{list[0].ID, list[1].ID}, map[string]any{"Stock": 5, "name": "updated"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	got, err := repo.SelectOne(ctx, list[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), got.Stock)
+	assert.Equal(t, "updated", got.Name)
+
+	_, err = repo.UpdateByIDs(ctx, []int64{list[0].ID}, map[string]any{"stock = 0 --": 1})
+	assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+
+	n, err = repo.DeleteByIDs(ctx, []int64{list[0].ID, list[2].ID, 100})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	n, err = repo.DeleteByIDs(ctx, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	var total int64
+	assert.NoError(t, db.Model(&Sku{}).Count(&total).Error)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestCRUD_UpdateByIDsVersioned(t *testing.T) {
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Profile{}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := crud.New[Profile](db)
+	ctx := context.Background()
+
+	profile := &Profile{Nickname: "alice"}
+	assert.NoError(t, repo.Create(ctx, profile))
+
+	n, err := repo.UpdateByIDs(ctx, []int64{profile.ID}, map[string]any{"nickname": "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	got, err := repo.SelectOne(ctx, profile.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", got.Nickname)
+	assert.Equal(t, int64(2), got.Version)
+
+	// the row read before the bulk update is stale.
+	profile.Nickname = "carol"
+	assert.ErrorIs(t, repo.Update(ctx, profile.ID, profile), orm.ErrStaleObject)
+}
+
+func TestCRUD_UpsertVersioned(t *testing.T) {
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Profile{}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := crud.New[Profile](db)
+	ctx := context.Background()
+
+	profile := &Profile{Nickname: "alice"}
+	assert.NoError(t, repo.Create(ctx, profile))
+
+	// the stale version of the upserted row is not written.
+	stale := &Profile{Nickname: "bob"}
+	stale.ID = profile.ID
+	_, err = repo.Upsert(ctx, []*Profile{stale})
+	assert.NoError(t, err)
+	got, err := repo.SelectOne(ctx, profile.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", got.Nickname)
+	assert.Equal(t, int64(2), got.Version)
+
+	_, err = repo.Upsert(ctx, []*Profile{stale}, crud.DoUpdate("nickname", "version"))
+	assert.NoError(t, err)
+	got, err = repo.SelectOne(ctx, profile.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), got.Version)
+
+	// the row read before the upsert is stale.
+	profile.Nickname = "carol"
+	assert.ErrorIs(t, repo.Update(ctx, profile.ID, profile), orm.ErrStaleObject)
+}
@@ -0,0 +1,223 @@
+package crud
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omalloc/contrib/kratos/caching"
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/x/singleflight"
+)
+
+// DefaultCacheTTL bounds how long the default cache of NewCached serves a row changed by another replica.
+const DefaultCacheTTL = time.Minute
+
+// CacheStats are the counters of a Cached repository.
+type CacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+}
+
+// Cached is a cache-aside Repository, SelectOne is served from the cache and the
+// concurrent loads of a key on miss are merged into one query.
+//
+// the entries are invalidated by Update / UpdateFields / Delete / Restore / ForceDelete and the ByIDs methods,
+// Upsert purges the cache.
+// inside an orm.Transaction the cache is bypassed and the invalidation is deferred until commit.
+// a load racing with an invalidation of its key is returned but not cached.
+//
+// only the writes through this Cached invalidate it, the rows changed by the other replicas or by raw SQL
+// stay stale until their entry expires, so the cache must have a TTL unless this process is the single writer.
+type Cached[T any, ID comparable] struct {
+	Repository[T, ID]
+
+	cache caching.LoadableCache[ID, *T]
+	group singleflight.Group[ID, *T]
+
+	// mu guards loading, the invalidation generation of the keys being loaded.
+	mu      sync.Mutex
+	loading map[ID]*generation
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// NewCached wraps repo with cache, a nil cache is a LoadableCache of 1000 entries expiring after DefaultCacheTTL.
+func NewCached[T any, ID comparable](repo Repository[T, ID], cache caching.LoadableCache[ID, *T]) *Cached[T, ID] {
+	if cache == nil {
+		cache = caching.New(caching.WithSize[ID, *T](1000), caching.WithTTL[ID, *T](DefaultCacheTTL))
+	}
+	return &Cached[T, ID]{
+		Repository: repo,
+		cache:      cache,
+		loading:    make(map[ID]*generation),
+	}
+}
+
+// generation counts the invalidations of a key while it is being loaded.
+type generation struct {
+	// gen 加载期间的失效次数
+	gen uint64
+	// loads 正在加载的次数, 为 0 时移除
+	loads int
+}
+
+// Stats returns the hit, miss and invalidation counters.
+func (c *Cached[T, ID]) Stats() CacheStats {
+	return CacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
+
+func inTransaction(ctx context.Context) bool {
+	_, ok := orm.FromContext(ctx)
+	return ok
+}
+
+// SelectOne returns a shallow copy of the cached entry, the fields of T are copied but the slices, maps and
+// pointers are shared with the cache and must not be modified.
+func (c *Cached[T, ID]) SelectOne(ctx context.Context, id ID) (*T, error) {
+	// the transaction may read its own uncommitted changes.
+	if inTransaction(ctx) {
+		return c.Repository.SelectOne(ctx, id)
+	}
+
+	if v, err := c.cache.Get(ctx, id); err == nil && v != nil {
+		c.hits.Add(1)
+		t := *v
+		return &t, nil
+	}
+	c.misses.Add(1)
+
+	v, err, _ := c.group.Do(id, func() (*T, error) {
+		return c.load(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := *v
+	return &t, nil
+}
+
+// load reads id from the repository and caches it, unless id is invalidated during the load.
+func (c *Cached[T, ID]) load(ctx context.Context, id ID) (*T, error) {
+	c.mu.Lock()
+	g, ok := c.loading[id]
+	if !ok {
+		g = &generation{}
+		c.loading[id] = g
+	}
+	g.loads++
+	start := g.gen
+	c.mu.Unlock()
+
+	v, err := c.Repository.SelectOne(ctx, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && g.gen == start {
+		_ = c.cache.Set(ctx, id, v)
+	}
+	if g.loads--; g.loads == 0 {
+		delete(c.loading, id)
+	}
+	return v, err
+}
+
+// invalidate removes ids once the transaction in ctx is committed, or right away without a transaction.
+func (c *Cached[T, ID]) invalidate(ctx context.Context, ids ...ID) {
+	orm.AfterCommit(ctx, func(ctx context.Context) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, id := range ids {
+			if g, ok := c.loading[id]; ok {
+				g.gen++
+			}
+			c.group.Forget(id)
+			c.cache.Remove(ctx, id)
+			c.invalidations.Add(1)
+		}
+	})
+}
+
+func (c *Cached[T, ID]) Update(ctx context.Context, id ID, t *T) error {
+	if err := c.Repository.Update(ctx, id, t); err != nil {
+		return err
+	}
+	c.invalidate(ctx, id)
+	return nil
+}
+
+func (c *Cached[T, ID]) UpdateFields(ctx context.Context, id ID, t *T, mask FieldMask) error {
+	if err := c.Repository.UpdateFields(ctx, id, t, mask); err != nil {
+		return err
+	}
+	c.invalidate(ctx, id)
+	return nil
+}
+
+func (c *Cached[T, ID]) Delete(ctx context.Context, id ID) error {
+	if err := c.Repository.Delete(ctx, id); err != nil {
+		return err
+	}
+	c.invalidate(ctx, id)
+	return nil
+}
+
+func (c *Cached[T, ID]) DeleteByIDs(ctx context.Context, ids []ID) (int64, error) {
+	n, err := c.Repository.DeleteByIDs(ctx, ids)
+	if err != nil {
+		return n, err
+	}
+	c.invalidate(ctx, ids...)
+	return n, nil
+}
+
+func (c *Cached[T, ID]) UpdateByIDs(ctx context.Context, ids []ID, columns map[string]any) (int64, error) {
+	n, err := c.Repository.UpdateByIDs(ctx, ids, columns)
+	if err != nil {
+		return n, err
+	}
+	c.invalidate(ctx, ids...)
+	return n, nil
+}
+
+func (c *Cached[T, ID]) Restore(ctx context.Context, id ID) error {
+	if err := c.Repository.Restore(ctx, id); err != nil {
+		return err
+	}
+	c.invalidate(ctx, id)
+	return nil
+}
+
+func (c *Cached[T, ID]) ForceDelete(ctx context.Context, id ID) error {
+	if err := c.Repository.ForceDelete(ctx, id); err != nil {
+		return err
+	}
+	c.invalidate(ctx, id)
+	return nil
+}
+
+// Upsert purges the cache, the updated rows are not known by id.
+func (c *Cached[T, ID]) Upsert(ctx context.Context, list []*T, opts ...UpsertOption) (int64, error) {
+	n, err := c.Repository.Upsert(ctx, list, opts...)
+	if err != nil {
+		return n, err
+	}
+	orm.AfterCommit(ctx, func(ctx context.Context) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, g := range c.loading {
+			g.gen++
+		}
+		c.cache.Purge(ctx)
+		c.invalidations.Add(1)
+	})
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package crud_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+// countingRepo counts the SelectOne queries.
+type countingRepo struct {
+	crud.CRUD[Sku]
+	selects atomic.Int64
+}
+
+func (r *countingRepo) SelectOne(ctx context.Context, id int64) (*Sku, error) {
+	r.selects.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return r.CRUD.SelectOne(ctx, id)
+}
+
+func TestCached(t *testing.T) {
+	db := newSkuDB(t)
+	repo := &countingRepo{CRUD: crud.New[Sku](db)}
+	cached := crud.NewCached[Sku, int64](repo, nil)
+	ctx := context.Background()
+
+	sku := &Sku{Code: "a", Name: "a"}
+	assert.NoError(t, cached.Create(ctx, sku))
+
+	// concurrent misses are loaded once.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := cached.SelectOne(ctx, sku.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "a", got.Name)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), repo.selects.Load())
+
+	got, err := cached.SelectOne(ctx, sku.ID)
+	assert.NoError(t, err)
+	// a copy of the cached entry.
+	got.Name = "modified"
+	got, _ = cached.SelectOne(ctx, sku.ID)
+	assert.Equal(t, "a", got.Name)
+	assert.Equal(t, int64(1), repo.selects.Load())
+
+	stats := cached.Stats()
+	assert.Equal(t, uint64(10), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Hits)
+
+	sku.Name = "b"
+	assert.NoError(t, cached.Update(ctx, sku.ID, sku))
+	got, err = cached.SelectOne(ctx, sku.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got.Name)
+	assert.Equal(t, int64(2), repo.selects.Load())
+
+	assert.NoError(t, cached.Delete(ctx, sku.ID))
+	_, err = cached.SelectOne(ctx, sku.ID)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(2), cached.Stats().Invalidations)
+}
+
+func TestCachedTransaction(t *testing.T) {
+	db := newSkuDB(t)
+	txm := orm.NewTransactionManager(&txData{db: db})
+	cached := crud.NewCached(crud.NewWithTx[Sku](txm), nil)
+	ctx := context.Background()
+
+	sku := &Sku{Code: "a", Name: "a"}
+	assert.NoError(t, cached.Create(ctx, sku))
+	_, err := cached.SelectOne(ctx, sku.ID)
+	assert.NoError(t, err)
+
+	// rolled back, the entry stays.
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := cached.Update(ctx, sku.ID, &Sku{ID: sku.ID, Code: "a", Name: "rollback"}); err != nil {
+			return err
+		}
+		// the transaction reads its own change.
+		got, err := cached.SelectOne(ctx, sku.ID)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, "rollback", got.Name)
+		return errors.New("rollback")
+	})
+	assert.Error(t, err)
+	assert.Zero(t, cached.Stats().Invalidations)
+
+	// invalidated on commit only.
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := cached.Update(ctx, sku.ID, &Sku{ID: sku.ID, Code: "a", Name: "commit"}); err != nil {
+			return err
+		}
+		assert.Zero(t, cached.Stats().Invalidations)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), cached.Stats().Invalidations)
+
+	got, err := cached.SelectOne(ctx, sku.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "commit", got.Name)
+}
+
+// stallingRepo holds the row read by SelectOne until release is closed.
+type stallingRepo struct {
+	crud.CRUD[Sku]
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (r *stallingRepo) SelectOne(ctx context.Context, id int64) (*Sku, error) {
+	v, err := r.CRUD.SelectOne(ctx, id)
+	if r.read != nil {
+		close(r.read)
+		<-r.release
+		r.read = nil
+	}
+	return v, err
+}
+
+func TestCachedConcurrentUpdate(t *testing.T) {
+	db := newSkuDB(t)
+	repo := &stallingRepo{CRUD: crud.New[Sku](db), read: make(chan struct{}), release: make(chan struct{})}
+	cached := crud.NewCached[Sku, int64](repo, nil)
+	ctx := context.Background()
+
+	sku := &Sku{Code: "a", Name: "old"}
+	assert.NoError(t, repo.Create(ctx, sku))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got, err := cached.SelectOne(ctx, sku.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "old", got.Name)
+	}()
+
+	// updated after the load has read the old row.
+	<-repo.read
+	assert.NoError(t, cached.Update(ctx, sku.ID, &Sku{ID: sku.ID, Code: "a", Name: "new"}))
+	close(repo.release)
+	<-done
+
+	got, err := cached.SelectOne(ctx, sku.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", got.Name)
+}
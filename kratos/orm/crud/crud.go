@@ -2,44 +2,229 @@ package crud
 
 import (
 	"context"
+	"iter"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 
+	"github.com/omalloc/contrib/kratos/orm"
 	"github.com/omalloc/contrib/protobuf"
 )
 
-type CRUD[T any] interface {
+// CRUD is the Repository of the models with an int64 primary key.
+type CRUD[T any] = Repository[T, int64]
+
+// Repository the ID is the primary key type of T, a struct holding the primary key fields for a composite key.
+type Repository[T any, ID any] interface {
 	Create(ctx context.Context, t *T) error
-	Update(ctx context.Context, id int64, t *T) error
-	Delete(ctx context.Context, id int64) error
+	Update(ctx context.Context, id ID, t *T) error
+	Delete(ctx context.Context, id ID) error
 	SelectList(ctx context.Context, pagination *protobuf.Pagination) ([]*T, error)
-	SelectOne(ctx context.Context, id int64) (*T, error)
+	// SelectListBy filters, sorts and projects with query, a nil pagination returns all matched rows.
+	SelectListBy(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error)
+	// SelectListByCursor paginates by keyset on the query orders and sets the NextPageToken of pagination.
+	SelectListByCursor(ctx context.Context, pagination *protobuf.CursorPagination, query *Query) ([]*T, error)
+	SelectOne(ctx context.Context, id ID) (*T, error)
+
+	// CreateBatch inserts list in batches of the gorm CreateBatchSize.
+	CreateBatch(ctx context.Context, list []*T) (int64, error)
+	// Upsert inserts list, or updates the conflicting rows, default is to update every column on a primary key conflict.
+	// the version of the orm.Versioned models is incremented instead of written, it is not checked.
+	Upsert(ctx context.Context, list []*T, opts ...UpsertOption) (int64, error)
+	DeleteByIDs(ctx context.Context, ids []ID) (int64, error)
+	// UpdateByIDs updates the columns of the rows, the keys are go field names, column names or json names.
+	// the version of the orm.Versioned models is incremented.
+	UpdateByIDs(ctx context.Context, ids []ID, columns map[string]any) (int64, error)
+
+	// UpdateFields updates only the fields in mask, a *fieldmaskpb.FieldMask or Paths,
+	// unknown and immutable fields are rejected with ErrInvalidFieldMask.
+	UpdateFields(ctx context.Context, id ID, t *T, mask FieldMask) error
+
+	// SelectListWithDeleted is SelectListBy including the soft deleted rows.
+	SelectListWithDeleted(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error)
+	// SelectDeleted is SelectListBy of the soft deleted rows only.
+	SelectDeleted(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error)
+	// Restore undeletes a soft deleted row, gorm.ErrRecordNotFound if there is no such row.
+	Restore(ctx context.Context, id ID) error
+	// ForceDelete removes the row permanently, whether it has been soft deleted or not.
+	ForceDelete(ctx context.Context, id ID) error
+	// PurgeDeleted removes the rows soft deleted before `before` permanently in batches of batchSize,
+	// returns the number of removed rows.
+	PurgeDeleted(ctx context.Context, before time.Time, batchSize int) (int64, error)
+
+	// Each iterates the rows of query in keyset batches of batchSize, stops at the first error.
+	Each(ctx context.Context, query *Query, batchSize int) iter.Seq2[*T, error]
+}
+
+// ContextDB provides the *gorm.DB bound to ctx, implemented by *gorm.DB and orm.Transaction.
+type ContextDB interface {
+	WithContext(ctx context.Context) *gorm.DB
+}
+
+type Option func(*options)
+
+type options struct {
+	queryFields     []string
+	cursorSecret    []byte
+	immutableFields []string
+	translate       bool
+}
+
+// WithQueryFields narrows the fields accepted by Query down to fields,
+// default is every column of the model but the fields tagged `json:"-"`.
+func WithQueryFields(fields ...string) Option {
+	return func(o *options) {
+		o.queryFields = fields
+	}
+}
+
+// WithCursorSecret set the HMAC secret of the page tokens, it must be shared by every instance of the service.
+// default is a random secret of the process, the page tokens then fail on the other replicas and after a restart,
+// a warning is logged when it is used.
+func WithCursorSecret(secret []byte) Option {
+	return func(o *options) {
+		o.cursorSecret = secret
+	}
+}
+
+type crud[T any, ID any] struct {
+	db   ContextDB
+	opts options
+
+	once   sync.Once
+	schema *schema.Schema
+	fields Fields
+	key    *key
+	keyErr error
+	err    error
 }
 
-type crud[T any] struct {
-	db *gorm.DB
+func newCRUD[T any, ID any](db ContextDB, opts ...Option) *crud[T, ID] {
+	r := &crud[T, ID]{db: db}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	return r
 }
 
-func (r *crud[T]) Create(ctx context.Context, t *T) error {
+// parse parses the model schema once.
+func (r *crud[T, ID]) parse(ctx context.Context) error {
+	r.once.Do(func() {
+		stmt := &gorm.Statement{DB: r.db.WithContext(ctx)}
+		if r.err = stmt.Parse(new(T)); r.err == nil {
+			r.schema = stmt.Schema
+			r.fields = NewQueryFields(stmt.Schema, r.opts.queryFields...)
+			r.key, r.keyErr = newKey(stmt.Schema, reflect.TypeOf(new(ID)).Elem())
+		}
+	})
+	return r.err
+}
+
+// where is the primary key condition of ids.
+func (r *crud[T, ID]) where(ctx context.Context, ids ...ID) (clause.Expression, error) {
+	if err := r.parse(ctx); err != nil {
+		return nil, err
+	}
+	if r.keyErr != nil {
+		return nil, r.keyErr
+	}
+	if len(ids) == 1 {
+		return r.key.eq(ids[0]), nil
+	}
+	values := make([]any, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id)
+	}
+	return r.key.in(values), nil
+}
+
+func (r *crud[T, ID]) queryFields(ctx context.Context) (Fields, error) {
+	if err := r.parse(ctx); err != nil {
+		return nil, err
+	}
+	return r.fields, nil
+}
+
+// columns resolves names to the column names of the model, unknown names are rejected with ErrInvalidQuery.
+func (r *crud[T, ID]) columns(ctx context.Context, names []string) ([]string, error) {
+	if err := r.parse(ctx); err != nil {
+		return nil, err
+	}
+	fields := NewFields(r.schema)
+	columns := make([]string, 0, len(names))
+	for _, name := range names {
+		f, err := fields.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, f.DBName)
+	}
+	return columns, nil
+}
+
+func (r *crud[T, ID]) Create(ctx context.Context, t *T) error {
 	return r.db.WithContext(ctx).
 		Model(new(T)).
 		Create(t).Error
 }
 
-func (r *crud[T]) Update(ctx context.Context, id int64, t *T) error {
+func (r *crud[T, ID]) Update(ctx context.Context, id ID, t *T) error {
+	if v, ok := any(t).(orm.Versioned); ok {
+		return r.updateVersioned(ctx, id, t, v)
+	}
+
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return err
+	}
 	return r.db.WithContext(ctx).
 		Model(new(T)).
-		Where("id = ?", id).
+		Where(where).
 		Save(t).Error
 }
 
-func (r *crud[T]) Delete(ctx context.Context, id int64) error {
+// updateVersioned writes all columns like Save, but only against the version that was read.
+// the version is incremented on success, orm.ErrStaleObject is returned if no row matched.
+func (r *crud[T, ID]) updateVersioned(ctx context.Context, id ID, t *T, v orm.Versioned) error {
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	version := v.GetVersion()
+	v.SetVersion(version + 1)
+
+	result := r.db.WithContext(ctx).
+		Model(t).
+		Where(where).
+		Where("version = ?", version).
+		Select("*").
+		Updates(t)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = orm.ErrStaleObject
+	}
+	if result.Error != nil {
+		v.SetVersion(version)
+	}
+	return result.Error
+}
+
+func (r *crud[T, ID]) Delete(ctx context.Context, id ID) error {
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return err
+	}
 	return r.db.WithContext(ctx).
-		Where("id = ?", id).
+		Where(where).
 		Delete(new(T)).Error
 }
 
-func (r *crud[T]) SelectList(ctx context.Context, pagination *protobuf.Pagination) ([]*T, error) {
+func (r *crud[T, ID]) SelectList(ctx context.Context, pagination *protobuf.Pagination) ([]*T, error) {
 	var (
 		list []*T
 		err  error
@@ -53,19 +238,139 @@ func (r *crud[T]) SelectList(ctx context.Context, pagination *protobuf.Paginatio
 	return list, err
 }
 
-func (r *crud[T]) SelectOne(ctx context.Context, id int64) (*T, error) {
+func (r *crud[T, ID]) SelectListBy(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
+	return r.selectListBy(ctx, r.db.WithContext(ctx), pagination, query)
+}
+
+// selectListBy runs query on tx, e.g. an Unscoped one including the soft deleted rows.
+func (r *crud[T, ID]) selectListBy(ctx context.Context, tx *gorm.DB, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
 	var (
-		t   T
-		err error
+		list    []*T
+		exprs   []clause.Expression
+		orders  []clause.OrderByColumn
+		columns []string
 	)
+	if query != nil {
+		fields, err := r.queryFields(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if exprs, orders, columns, err = query.build(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	tx = tx.Model(new(T))
+	if len(exprs) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: exprs})
+	}
+	// count before the projection and ordering.
+	if pagination != nil {
+		tx = tx.Count(pagination.Count()).
+			Offset(pagination.Offset()).
+			Limit(pagination.Limit())
+	}
+	if len(columns) > 0 {
+		tx = tx.Select(columns)
+	}
+	for _, order := range orders {
+		tx = tx.Order(order)
+	}
+	err := tx.Find(&list).Error
+
+	return list, err
+}
+
+func (r *crud[T, ID]) SelectListByCursor(ctx context.Context, pagination *protobuf.CursorPagination, query *Query) ([]*T, error) {
+	tx, keyset, err := r.keyset(ctx, pagination, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*T
+	if err := tx.Scopes(keyset.Scope).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return keyset.Page(list)
+}
+
+// keyset builds the conditions and projection of query, the returned tx is reusable for every page.
+func (r *crud[T, ID]) keyset(ctx context.Context, pagination *protobuf.CursorPagination, query *Query) (*gorm.DB, *Keyset[T], error) {
+	fields, err := r.queryFields(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var q Query
+	if query != nil {
+		q = *query
+	}
+	// the orders are validated by the keyset.
+	exprs, _, columns, err := (&Query{Conditions: q.Conditions, Fields: q.Fields}).build(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyset := NewKeyset[T](pagination, r.opts.cursorSecret, q.Orders...)
+	keyset.fields = fields
+
+	tx := r.db.WithContext(ctx).Model(new(T))
+	if err := keyset.resolve(tx); err != nil {
+		return nil, nil, err
+	}
+	if len(exprs) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: exprs})
+	}
+	// the sort columns are selected for the next page token.
+	if len(columns) > 0 {
+		for _, c := range keyset.columns() {
+			if !slices.Contains(columns, c) {
+				columns = append(columns, c)
+			}
+		}
+		tx = tx.Select(columns)
+	}
+	return tx.Session(&gorm.Session{}), keyset, nil
+}
+
+func (r *crud[T, ID]) SelectOne(ctx context.Context, id ID) (*T, error) {
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var t T
 	err = r.db.WithContext(ctx).
 		Model(&t).
-		Where("id = ?", id).
+		Where(where).
 		Take(&t).Error
 
 	return &t, err
 }
 
-func New[T any](db *gorm.DB) CRUD[T] {
-	return &crud[T]{db: db}
+func New[T any](db *gorm.DB, opts ...Option) CRUD[T] {
+	return newRepository[T, int64](db, opts...)
+}
+
+// NewWithTx every method runs with the transaction in ctx when present, e.g. an orm.Transaction.
+func NewWithTx[T any](tx ContextDB, opts ...Option) CRUD[T] {
+	return newRepository[T, int64](tx, opts...)
+}
+
+// NewRepository the primary key is read from the gorm schema of T, e.g. a string column or a composite key.
+//
+//	type MemberKey struct {
+//	    GroupID int64
+//	    UserID  int64
+//	}
+//	repo := crud.NewRepository[Member, MemberKey](db)
+func NewRepository[T any, ID any](db ContextDB, opts ...Option) Repository[T, ID] {
+	return newRepository[T, ID](db, opts...)
+}
+
+func newRepository[T any, ID any](db ContextDB, opts ...Option) Repository[T, ID] {
+	r := newCRUD[T, ID](db, opts...)
+	if r.opts.translate {
+		return &translated[T, ID]{r: r}
+	}
+	return r
 }
@@ -3,14 +3,18 @@ package crud_test
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/glebarez/sqlite"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 
+	"github.com/omalloc/contrib/kratos/orm"
 	"github.com/omalloc/contrib/kratos/orm/crud"
 	"github.com/omalloc/contrib/protobuf"
 )
@@ -141,3 +145,115 @@ func TestCRUD_SelectOne(t *testing.T) {
 	assert.Equal(t, "test", model.Name)
 	assert.Equal(t, expectedTime, model.CreatedAt)
 }
+
+type VersionedModel struct {
+	ID   int64  `gorm:"primarykey"`
+	Name string `gorm:"column:name"`
+
+	orm.VersionedDBModel
+}
+
+func TestCRUD_UpdateVersioned(t *testing.T) {
+	mockDB, mock, db := setupTestDB(t)
+	defer mockDB.Close()
+
+	crud := crud.New[VersionedModel](db)
+	ctx := context.Background()
+
+	model := &VersionedModel{ID: 1, Name: "updated"}
+	model.Version = 3
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `versioned_models` SET .*`version`=\\? .*WHERE `versioned_models`.`id` = \\? AND version = \\?").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, crud.Update(ctx, model.ID, model))
+	assert.Equal(t, int64(4), model.Version)
+
+	// modified by another transaction.
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `versioned_models`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := crud.Update(ctx, model.ID, model)
+	assert.ErrorIs(t, err, orm.ErrStaleObject)
+	assert.Equal(t, 409, kerrors.Code(err))
+	assert.Equal(t, int64(4), model.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type txData struct {
+	db *gorm.DB
+}
+
+func (d *txData) GetDataSource() *gorm.DB {
+	return d.db
+}
+
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&TestModel{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCRUD_NewWithTx(t *testing.T) {
+	db := newSQLiteDB(t)
+	txm := orm.NewTransactionManager(&txData{db: db})
+	repo := crud.NewWithTx[TestModel](txm)
+	ctx := context.Background()
+
+	// rolled back with the transaction.
+	err := txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &TestModel{Name: "rollback"}); err != nil {
+			return err
+		}
+		list, err := repo.SelectList(ctx, protobuf.PageWrap(nil))
+		if err != nil {
+			return err
+		}
+		assert.Len(t, list, 1)
+		return errors.New("rollback")
+	})
+	assert.Error(t, err)
+
+	list, err := repo.SelectList(ctx, protobuf.PageWrap(nil))
+	assert.NoError(t, err)
+	assert.Len(t, list, 0)
+
+	// committed with the transaction.
+	model := &TestModel{Name: "commit"}
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, model); err != nil {
+			return err
+		}
+		model.Name = "updated"
+		return repo.Update(ctx, model.ID, model)
+	})
+	assert.NoError(t, err)
+
+	got, err := repo.SelectOne(ctx, model.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", got.Name)
+
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := repo.Delete(ctx, model.ID); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.Error(t, err)
+
+	_, err = repo.SelectOne(ctx, model.ID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,357 @@
+package crudtest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/kratos/orm/crud/crudtest"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+type Note struct {
+	ID       int64   `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Title    string  `gorm:"column:title;" json:"title"`
+	Priority int64   `gorm:"column:priority;" json:"priority"`
+	Remark   *string `gorm:"column:remark;" json:"remark"`
+	orm.DBModel
+}
+
+type dataSource struct {
+	db *gorm.DB
+}
+
+func (d *dataSource) GetDataSource() *gorm.DB {
+	return d.db
+}
+
+// the conformance suite runs against the fake and the SQLite-backed crud.
+var backends = map[string]func(t *testing.T) (crud.CRUD[Note], orm.Transaction){
+	"memory": func(t *testing.T) (crud.CRUD[Note], orm.Transaction) {
+		repo := crudtest.New[Note]()
+		return repo, crudtest.NewTransaction(repo)
+	},
+	"sqlite": func(t *testing.T) (crud.CRUD[Note], orm.Transaction) {
+		name := strings.ReplaceAll(t.Name(), "/", "_")
+		db, err := orm.New(
+			orm.WithDriver(sqlite.Open("file:" + name + "?mode=memory&cache=shared")),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the shared memory database is dropped with its last connection.
+		t.Cleanup(func() {
+			if sqlDB, err := db.DB(); err == nil {
+				_ = sqlDB.Close()
+			}
+		})
+		if err := db.AutoMigrate(&Note{}); err != nil {
+			t.Fatal(err)
+		}
+		txm := orm.NewTransactionManager(&dataSource{db: db})
+		return crud.NewWithTx[Note](txm), txm
+	},
+}
+
+func conformance(t *testing.T, name string, fn func(t *testing.T, repo crud.CRUD[Note], txm orm.Transaction)) {
+	for backend, newRepo := range backends {
+		t.Run(backend+"/"+name, func(t *testing.T) {
+			repo, txm := newRepo(t)
+			fn(t, repo, txm)
+		})
+	}
+}
+
+func seed(t *testing.T, repo crud.CRUD[Note], n int) {
+	t.Helper()
+
+	for i := 1; i <= n; i++ {
+		if err := repo.Create(context.Background(), &Note{Title: fmt.Sprintf("n%02d", i), Priority: int64(i % 4)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ids(list []*Note) []int64 {
+	ids := make([]int64, 0, len(list))
+	for _, n := range list {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestConformance(t *testing.T) {
+	ctx := context.Background()
+
+	conformance(t, "create", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		n := &Note{Title: "a"}
+		assert.NoError(t, repo.Create(ctx, n))
+		assert.Equal(t, int64(1), n.ID)
+		assert.False(t, n.CreatedAt.IsZero())
+
+		got, err := repo.SelectOne(ctx, n.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "a", got.Title)
+
+		_, err = repo.SelectOne(ctx, 100)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	conformance(t, "update", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 2)
+		n, _ := repo.SelectOne(ctx, 1)
+		n.Title = "updated"
+		assert.NoError(t, repo.Update(ctx, n.ID, n))
+
+		got, err := repo.SelectOne(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "updated", got.Title)
+		got, _ = repo.SelectOne(ctx, 2)
+		assert.Equal(t, "n02", got.Title)
+	})
+
+	conformance(t, "pagination", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 25)
+		pagination := protobuf.PageWrap(&protobuf.Pagination{Current: 3, PageSize: 10})
+		list, err := repo.SelectList(ctx, pagination)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{21, 22, 23, 24, 25}, ids(list))
+		assert.Equal(t, int32(25), pagination.Resp().Total)
+	})
+
+	conformance(t, "soft delete", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 5)
+		assert.NoError(t, repo.Delete(ctx, 1))
+		_, err := repo.SelectOne(ctx, 1)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		// deleting again is not an error.
+		assert.NoError(t, repo.Delete(ctx, 1))
+
+		n, err := repo.DeleteByIDs(ctx, []int64{1, 2, 3, 100})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+
+		pagination := protobuf.PageWrap(nil)
+		list, err := repo.SelectList(ctx, pagination)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{4, 5}, ids(list))
+		assert.Equal(t, int32(2), pagination.Resp().Total)
+
+		// the id of a soft deleted row is not reused.
+		note := &Note{Title: "new"}
+		assert.NoError(t, repo.Create(ctx, note))
+		assert.Equal(t, int64(6), note.ID)
+	})
+
+	conformance(t, "soft delete management", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 5)
+		_, err := repo.DeleteByIDs(ctx, []int64{1, 2, 3})
+		assert.NoError(t, err)
+
+		list, err := repo.SelectDeleted(ctx, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 2, 3}, ids(list))
+		list, err = repo.SelectListWithDeleted(ctx, nil, &crud.Query{Conditions: []crud.Condition{crud.Ne("id", 4)}})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 2, 3, 5}, ids(list))
+
+		assert.NoError(t, repo.Restore(ctx, 1))
+		assert.ErrorIs(t, repo.Restore(ctx, 1), gorm.ErrRecordNotFound)
+		_, err = repo.SelectOne(ctx, 1)
+		assert.NoError(t, err)
+
+		assert.NoError(t, repo.ForceDelete(ctx, 4))
+		n, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Hour), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		n, err = repo.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 1)
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+
+		list, err = repo.SelectListWithDeleted(ctx, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 5}, ids(list))
+	})
+
+	conformance(t, "query", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 10)
+		remark := "r"
+		_, err := repo.UpdateByIDs(ctx, []int64{2}, map[string]any{"remark": &remark})
+		assert.NoError(t, err)
+
+		list, err := repo.SelectListBy(ctx, nil, &crud.Query{
+			Conditions: []crud.Condition{
+				crud.Range("priority", "1", nil),
+				crud.Like("title", "N%"),
+				crud.Ne("id", 3),
+			},
+			Orders: []crud.Order{crud.Desc("priority"), crud.Asc("id")},
+			Fields: []string{"id", "title"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{7, 2, 6, 10, 1, 5, 9}, ids(list))
+		assert.Zero(t, list[0].Priority)
+
+		list, err = repo.SelectListBy(ctx, nil, &crud.Query{
+			Conditions: []crud.Condition{crud.In("priority", 0, 2), crud.IsNull("remark")},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{4, 6, 8, 10}, ids(list))
+
+		list, err = repo.SelectListBy(ctx, nil, &crud.Query{Conditions: []crud.Condition{crud.NotNull("remark")}})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{2}, ids(list))
+
+		pagination := protobuf.PageWrap(&protobuf.Pagination{PageSize: 2})
+		list, err = repo.SelectListBy(ctx, pagination, &crud.Query{Conditions: []crud.Condition{crud.Eq("priority", "1")}})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1, 5}, ids(list))
+		assert.Equal(t, int32(3), pagination.Resp().Total)
+
+		_, err = repo.SelectListBy(ctx, nil, &crud.Query{Conditions: []crud.Condition{crud.Eq("unknown", 1)}})
+		assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+		_, err = repo.SelectListBy(ctx, nil, &crud.Query{Conditions: []crud.Condition{crud.Eq("priority", "a")}})
+		assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+	})
+
+	conformance(t, "cursor", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 25)
+		query := &crud.Query{Orders: []crud.Order{crud.Desc("priority")}}
+
+		var (
+			got   []int64
+			token string
+		)
+		for {
+			pagination := protobuf.CursorWrap(&protobuf.CursorPagination{PageSize: 4, PageToken: token})
+			list, err := repo.SelectListByCursor(ctx, pagination, query)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got = append(got, ids(list)...)
+			if token = pagination.NextPageToken; token == "" {
+				break
+			}
+		}
+
+		// priority desc, then id desc as the tie-breaker.
+		var want []int64
+		for p := int64(3); p >= 0; p-- {
+			for id := int64(25); id >= 1; id-- {
+				if id%4 == p {
+					want = append(want, id)
+				}
+			}
+		}
+		assert.Equal(t, want, got)
+
+		_, err := repo.SelectListByCursor(ctx, &protobuf.CursorPagination{PageSize: 4, PageToken: "invalid"}, query)
+		assert.ErrorIs(t, err, crud.ErrInvalidPageToken)
+
+		// an unset page size is crud.DefaultPageSize.
+		pagination := &protobuf.CursorPagination{}
+		list, err := repo.SelectListByCursor(ctx, pagination, query)
+		assert.NoError(t, err)
+		assert.Len(t, list, crud.DefaultPageSize)
+		assert.NotEmpty(t, pagination.NextPageToken)
+		list, err = repo.SelectListByCursor(ctx, nil, query)
+		assert.NoError(t, err)
+		assert.Len(t, list, crud.DefaultPageSize)
+	})
+
+	conformance(t, "bulk", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		n, err := repo.CreateBatch(ctx, []*Note{{Title: "a"}, {Title: "b"}, {Title: "c"}})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+
+		n, err = repo.UpdateByIDs(ctx, []int64{1, 2, 100}, map[string]any{"Priority": 9})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		got, _ := repo.SelectOne(ctx, 2)
+		assert.Equal(t, int64(9), got.Priority)
+
+		_, err = repo.UpdateByIDs(ctx, []int64{1}, map[string]any{"unknown": 1})
+		assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+
+		n, err = repo.Upsert(ctx, []*Note{{ID: 3, Title: "c2"}, {Title: "d"}})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		got, _ = repo.SelectOne(ctx, 3)
+		assert.Equal(t, "c2", got.Title)
+		got, _ = repo.SelectOne(ctx, 4)
+		assert.Equal(t, "d", got.Title)
+	})
+
+	conformance(t, "update fields", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 2)
+		assert.NoError(t, repo.UpdateFields(ctx, 1, &Note{Title: "ignored", Priority: 0}, crud.Paths{"priority"}))
+
+		got, _ := repo.SelectOne(ctx, 1)
+		assert.Equal(t, "n01", got.Title)
+		assert.Zero(t, got.Priority)
+
+		for _, paths := range []crud.Paths{nil, {"unknown"}, {"id"}, {"createdAt"}} {
+			err := repo.UpdateFields(ctx, 1, &Note{}, paths)
+			assert.ErrorIs(t, err, crud.ErrInvalidFieldMask, paths)
+		}
+	})
+
+	conformance(t, "each", func(t *testing.T, repo crud.CRUD[Note], _ orm.Transaction) {
+		seed(t, repo, 25)
+		var got []int64
+		for n, err := range repo.Each(ctx, &crud.Query{Conditions: []crud.Condition{crud.Eq("priority", 1)}}, 3) {
+			if !assert.NoError(t, err) {
+				return
+			}
+			got = append(got, n.ID)
+		}
+		assert.Equal(t, []int64{1, 5, 9, 13, 17, 21, 25}, got)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		for _, err := range repo.Each(canceled, nil, 3) {
+			assert.ErrorIs(t, err, context.Canceled)
+		}
+	})
+
+	conformance(t, "transaction", func(t *testing.T, repo crud.CRUD[Note], txm orm.Transaction) {
+		seed(t, repo, 1)
+
+		err := txm.Transaction(ctx, func(ctx context.Context) error {
+			if err := repo.Create(ctx, &Note{Title: "rollback"}); err != nil {
+				return err
+			}
+			if _, err := repo.UpdateByIDs(ctx, []int64{1}, map[string]any{"title": "rollback"}); err != nil {
+				return err
+			}
+			if err := repo.Delete(ctx, 1); err != nil {
+				return err
+			}
+			return errors.New("rollback")
+		})
+		assert.EqualError(t, err, "rollback")
+
+		got, err := repo.SelectOne(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "n01", got.Title)
+		list, _ := repo.SelectListBy(ctx, nil, nil)
+		assert.Len(t, list, 1)
+
+		err = txm.Transaction(ctx, func(ctx context.Context) error {
+			return repo.Create(ctx, &Note{Title: "commit"})
+		})
+		assert.NoError(t, err)
+		list, _ = repo.SelectListBy(ctx, nil, nil)
+		assert.True(t, slices.ContainsFunc(list, func(n *Note) bool { return n.Title == "commit" }))
+	})
+}
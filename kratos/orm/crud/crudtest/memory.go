@@ -0,0 +1,567 @@
+// Package crudtest provides in-memory fakes of crud.CRUD and orm.Transaction for the unit tests of
+// business services, no database or sqlmock expectations are needed.
+//
+//	users := crudtest.New[User]()
+//	txm := crudtest.NewTransaction(users)
+//	svc := NewUserService(users, txm)
+package crudtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+var (
+	ErrUpsertOptions = errors.New("crudtest: upsert options are not supported")
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// Memory is a thread-safe in-memory crud.CRUD, the model is read from its gorm schema as the real one.
+//
+// the primary key is assigned by auto-increment when it is zero, a model with a gorm.DeletedAt field
+// (e.g. embedding orm.DBModel) is soft deleted, and the orm.Versioned models are checked on update.
+// the stored rows are copies, changes to the returned rows are not visible until written.
+type Memory[T any] struct {
+	mu     sync.RWMutex
+	rows   map[int64]*T
+	nextID int64
+
+	once        sync.Once
+	schema      *schema.Schema
+	fields      crud.Fields
+	queryFields crud.Fields
+	deletedAt   *schema.Field
+	err         error
+}
+
+var _ crud.CRUD[struct{}] = (*Memory[struct{}])(nil)
+
+func New[T any]() *Memory[T] {
+	return &Memory[T]{
+		rows: make(map[int64]*T),
+	}
+}
+
+// parse parses the model schema once.
+func (m *Memory[T]) parse() error {
+	m.once.Do(func() {
+		s, err := schema.Parse(new(T), &sync.Map{}, schema.NamingStrategy{})
+		if err == nil && s.PrioritizedPrimaryField == nil {
+			err = fmt.Errorf("crudtest: %s has no primary key", s.Name)
+		}
+		if err != nil {
+			m.err = err
+			return
+		}
+
+		m.schema = s
+		m.fields = crud.NewFields(s)
+		m.queryFields = crud.NewQueryFields(s)
+		for _, f := range s.Fields {
+			if f.FieldType == deletedAtType {
+				m.deletedAt = f
+			}
+		}
+	})
+	return m.err
+}
+
+// Snapshot copies the rows, restore brings them back. it implements Snapshotter for Transaction.
+func (m *Memory[T]) Snapshot() (restore func()) {
+	m.mu.RLock()
+	rows := make(map[int64]*T, len(m.rows))
+	for id, t := range m.rows {
+		rows[id] = clone(t)
+	}
+	nextID := m.nextID
+	m.mu.RUnlock()
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.rows = rows
+		m.nextID = nextID
+	}
+}
+
+func clone[T any](t *T) *T {
+	v := *t
+	return &v
+}
+
+func (m *Memory[T]) id(ctx context.Context, t *T) int64 {
+	v, _ := m.schema.PrioritizedPrimaryField.ValueOf(ctx, reflect.ValueOf(t).Elem())
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.CanInt() {
+		return rv.Int()
+	}
+	if rv.CanUint() {
+		return int64(rv.Uint())
+	}
+	return 0
+}
+
+func (m *Memory[T]) deleted(ctx context.Context, t *T) bool {
+	if m.deletedAt == nil {
+		return false
+	}
+	v, _ := m.deletedAt.ValueOf(ctx, reflect.ValueOf(t).Elem())
+	d, _ := v.(gorm.DeletedAt)
+	return d.Valid
+}
+
+// get returns the row of id, nil if it does not exist or has been soft deleted.
+func (m *Memory[T]) get(ctx context.Context, id int64) *T {
+	t, ok := m.rows[id]
+	if !ok || m.deleted(ctx, t) {
+		return nil
+	}
+	return t
+}
+
+// touch sets the auto update time fields, and the auto create time fields when create is set.
+func (m *Memory[T]) touch(ctx context.Context, t *T, create bool) {
+	now := time.Now()
+	rv := reflect.ValueOf(t).Elem()
+	for _, f := range m.schema.Fields {
+		if f.AutoUpdateTime > 0 || create && f.AutoCreateTime > 0 {
+			if _, zero := f.ValueOf(ctx, rv); zero || f.AutoUpdateTime > 0 {
+				_ = f.Set(ctx, rv, now)
+			}
+		}
+	}
+}
+
+// create inserts t, the caller holds the write lock.
+func (m *Memory[T]) create(ctx context.Context, t *T) error {
+	rv := reflect.ValueOf(t).Elem()
+	id := m.id(ctx, t)
+	if id == 0 {
+		m.nextID++
+		id = m.nextID
+		if err := m.schema.PrioritizedPrimaryField.Set(ctx, rv, id); err != nil {
+			return err
+		}
+	} else if _, ok := m.rows[id]; ok {
+		return gorm.ErrDuplicatedKey
+	}
+	m.nextID = max(m.nextID, id)
+
+	for _, f := range m.schema.Fields {
+		if f.DefaultValueInterface != nil {
+			if _, zero := f.ValueOf(ctx, rv); zero {
+				_ = f.Set(ctx, rv, f.DefaultValueInterface)
+			}
+		}
+	}
+	m.touch(ctx, t, true)
+	m.rows[id] = clone(t)
+	return nil
+}
+
+func (m *Memory[T]) Create(ctx context.Context, t *T) error {
+	if err := m.parse(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.create(ctx, t)
+}
+
+// Update writes every field like gorm Save, the row is inserted if it does not exist.
+func (m *Memory[T]) Update(ctx context.Context, id int64, t *T) error {
+	if err := m.parse(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := any(t).(orm.Versioned); ok {
+		row := m.get(ctx, id)
+		if row == nil || any(row).(orm.Versioned).GetVersion() != v.GetVersion() {
+			return orm.ErrStaleObject
+		}
+		v.SetVersion(v.GetVersion() + 1)
+	}
+
+	if m.id(ctx, t) == 0 {
+		if err := m.schema.PrioritizedPrimaryField.Set(ctx, reflect.ValueOf(t).Elem(), id); err != nil {
+			return err
+		}
+	}
+	if _, ok := m.rows[id]; !ok {
+		return m.create(ctx, t)
+	}
+	m.touch(ctx, t, false)
+	m.rows[id] = clone(t)
+	return nil
+}
+
+// remove soft deletes or removes the row of id, the caller holds the write lock.
+func (m *Memory[T]) remove(ctx context.Context, id int64) bool {
+	t := m.get(ctx, id)
+	if t == nil {
+		return false
+	}
+	if m.deletedAt == nil {
+		delete(m.rows, id)
+		return true
+	}
+	_ = m.deletedAt.Set(ctx, reflect.ValueOf(t).Elem(), gorm.DeletedAt{Time: time.Now(), Valid: true})
+	return true
+}
+
+func (m *Memory[T]) Delete(ctx context.Context, id int64) error {
+	if err := m.parse(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(ctx, id)
+	return nil
+}
+
+func (m *Memory[T]) SelectOne(ctx context.Context, id int64) (*T, error) {
+	if err := m.parse(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t := m.get(ctx, id)
+	if t == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return clone(t), nil
+}
+
+func (m *Memory[T]) SelectList(ctx context.Context, pagination *protobuf.Pagination) ([]*T, error) {
+	return m.SelectListBy(ctx, pagination, nil)
+}
+
+func (m *Memory[T]) SelectListBy(ctx context.Context, pagination *protobuf.Pagination, query *crud.Query) ([]*T, error) {
+	return m.selectListBy(ctx, scopeActive, pagination, query)
+}
+
+func (m *Memory[T]) selectListBy(ctx context.Context, scope scope, pagination *protobuf.Pagination, query *crud.Query) ([]*T, error) {
+	if err := m.parse(); err != nil {
+		return nil, err
+	}
+	q, err := m.compile(query)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	list := m.list(ctx, scope, q, q.orders)
+	m.mu.RUnlock()
+
+	if pagination != nil {
+		*pagination.Count() = int64(len(list))
+		list = list[min(pagination.Offset(), len(list)):]
+		list = list[:min(pagination.Limit(), len(list))]
+	}
+	return project(ctx, q, list), nil
+}
+
+// SelectListByCursor the page token is signed with the default secret of crud.NewKeyset.
+func (m *Memory[T]) SelectListByCursor(ctx context.Context, pagination *protobuf.CursorPagination, query *crud.Query) ([]*T, error) {
+	if err := m.parse(); err != nil {
+		return nil, err
+	}
+	q, err := m.compile(query)
+	if err != nil {
+		return nil, err
+	}
+	if pagination == nil {
+		pagination = &protobuf.CursorPagination{}
+	}
+	orders := crud.KeysetSort(m.schema, q.orders)
+
+	var after []any
+	if token := pagination.GetPageToken(); token != "" {
+		if after, err = crud.DecodeCursor(nil, token, orders); err != nil {
+			return nil, err
+		}
+		for i, v := range after {
+			after[i] = normalize(v)
+		}
+	}
+
+	m.mu.RLock()
+	list := m.list(ctx, scopeActive, q, orders)
+	m.mu.RUnlock()
+
+	if after != nil {
+		i := slices.IndexFunc(list, func(t *T) bool {
+			return compareRow(ctx, reflect.ValueOf(t).Elem(), after, orders) > 0
+		})
+		if i < 0 {
+			i = len(list)
+		}
+		list = list[i:]
+	}
+
+	limit := crud.CursorLimit(pagination)
+	pagination.NextPageToken = ""
+	if len(list) > limit {
+		list = list[:limit]
+		row := reflect.ValueOf(list[limit-1]).Elem()
+		values := make([]any, 0, len(orders))
+		for _, o := range orders {
+			values = append(values, value(ctx, o.Field, row))
+		}
+		if pagination.NextPageToken, err = crud.EncodeCursor(nil, orders, values); err != nil {
+			return nil, err
+		}
+	}
+	return project(ctx, q, list), nil
+}
+
+func (m *Memory[T]) CreateBatch(ctx context.Context, list []*T) (int64, error) {
+	if err := m.parse(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, t := range list {
+		if err := m.create(ctx, t); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Upsert conflicts on the primary key and updates every updatable field but the create time and the version,
+// which is bumped. opts are rejected with ErrUpsertOptions.
+func (m *Memory[T]) Upsert(ctx context.Context, list []*T, opts ...crud.UpsertOption) (int64, error) {
+	if len(opts) > 0 {
+		return 0, ErrUpsertOptions
+	}
+	if err := m.parse(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, t := range list {
+		id := m.id(ctx, t)
+		row, ok := m.rows[id]
+		if !ok {
+			if err := m.create(ctx, t); err != nil {
+				return n, err
+			}
+			n++
+			continue
+		}
+
+		rv, old := reflect.ValueOf(t).Elem(), reflect.ValueOf(row).Elem()
+		for _, f := range m.schema.Fields {
+			if f.AutoCreateTime > 0 || !f.Updatable {
+				v, _ := f.ValueOf(ctx, old)
+				_ = f.Set(ctx, rv, v)
+			}
+		}
+		m.touch(ctx, t, false)
+		stored := clone(t)
+		// the version is bumped, not written.
+		if v, ok := any(stored).(orm.Versioned); ok {
+			v.SetVersion(any(row).(orm.Versioned).GetVersion() + 1)
+		}
+		m.rows[id] = stored
+		n++
+	}
+	return n, nil
+}
+
+func (m *Memory[T]) DeleteByIDs(ctx context.Context, ids []int64) (int64, error) {
+	if err := m.parse(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, id := range ids {
+		if m.remove(ctx, id) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory[T]) UpdateByIDs(ctx context.Context, ids []int64, columns map[string]any) (int64, error) {
+	if err := m.parse(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 || len(columns) == 0 {
+		return 0, nil
+	}
+	fields := make(map[*schema.Field]any, len(columns))
+	for name, v := range columns {
+		f, err := m.fields.Lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		fields[f] = v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+		row := m.get(ctx, id)
+		if row == nil {
+			continue
+		}
+		t := clone(row)
+		rv := reflect.ValueOf(t).Elem()
+		for f, v := range fields {
+			if err := f.Set(ctx, rv, v); err != nil {
+				return n, err
+			}
+		}
+		if v, ok := any(t).(orm.Versioned); ok {
+			v.SetVersion(v.GetVersion() + 1)
+		}
+		m.touch(ctx, t, false)
+		m.rows[id] = t
+		n++
+	}
+	return n, nil
+}
+
+// UpdateFields rejects the unknown, primary key and create-only fields as the real one,
+// crud.WithImmutableFields is not supported.
+func (m *Memory[T]) UpdateFields(ctx context.Context, id int64, t *T, mask crud.FieldMask) error {
+	if err := m.parse(); err != nil {
+		return err
+	}
+	fields, err := m.fields.Mask(mask)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, versioned := any(t).(orm.Versioned)
+	row := m.get(ctx, id)
+	if row == nil {
+		if versioned {
+			return orm.ErrStaleObject
+		}
+		return nil
+	}
+	if versioned {
+		if any(row).(orm.Versioned).GetVersion() != v.GetVersion() {
+			return orm.ErrStaleObject
+		}
+		v.SetVersion(v.GetVersion() + 1)
+	}
+
+	updated := clone(row)
+	src, dst := reflect.ValueOf(t).Elem(), reflect.ValueOf(updated).Elem()
+	for _, f := range fields {
+		value, _ := f.ValueOf(ctx, src)
+		if err := f.Set(ctx, dst, value); err != nil {
+			return err
+		}
+	}
+	if versioned {
+		any(updated).(orm.Versioned).SetVersion(v.GetVersion())
+	}
+	m.touch(ctx, updated, false)
+	m.rows[id] = updated
+	return nil
+}
+
+// Each reads the matched rows at once, ctx is checked between the batches of batchSize.
+func (m *Memory[T]) Each(ctx context.Context, query *crud.Query, batchSize int) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		list, err := m.SelectListBy(ctx, nil, query)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if batchSize <= 0 {
+			batchSize = len(list) + 1
+		}
+		for i, t := range list {
+			if i%batchSize == 0 {
+				if err := ctx.Err(); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+			if !yield(t, nil) {
+				return
+			}
+		}
+	}
+}
+
+// scope selects the rows by their soft delete state.
+type scope int
+
+const (
+	scopeActive scope = iota
+	scopeWithDeleted
+	scopeDeleted
+)
+
+func (s scope) match(deleted bool) bool {
+	switch s {
+	case scopeWithDeleted:
+		return true
+	case scopeDeleted:
+		return deleted
+	default:
+		return !deleted
+	}
+}
+
+// list returns copies of the rows matched by q in the order, the caller holds the read lock.
+func (m *Memory[T]) list(ctx context.Context, scope scope, q *compiled, orders []crud.SortField) []*T {
+	list := make([]*T, 0, len(m.rows))
+	for _, t := range m.rows {
+		if !scope.match(m.deleted(ctx, t)) || !q.match(ctx, reflect.ValueOf(t).Elem()) {
+			continue
+		}
+		list = append(list, clone(t))
+	}
+
+	pk := crud.SortField{Field: m.schema.PrioritizedPrimaryField}
+	slices.SortFunc(list, func(a, b *T) int {
+		va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+		for _, o := range append(orders, pk) {
+			if c := compareSort(ctx, o, va, vb); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return list
+}
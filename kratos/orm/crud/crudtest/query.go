@@ -0,0 +1,292 @@
+package crudtest
+
+import (
+	"cmp"
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compareSort compares a and b on the sort field, NULL first as SQLite and MySQL do.
+func compareSort(ctx context.Context, o crud.SortField, a, b reflect.Value) int {
+	c := compareNull(value(ctx, o.Field, a), value(ctx, o.Field, b))
+	if o.Desc {
+		return -c
+	}
+	return c
+}
+
+type predicate func(v any) bool
+
+// compiled is a crud.Query resolved against the model.
+type compiled struct {
+	predicates map[*schema.Field][]predicate
+	orders     []crud.SortField
+	fields     []*schema.Field
+}
+
+func (m *Memory[T]) compile(query *crud.Query) (*compiled, error) {
+	q := &compiled{predicates: make(map[*schema.Field][]predicate)}
+	if query == nil {
+		return q, nil
+	}
+
+	for _, c := range query.Conditions {
+		f, err := m.queryFields.Lookup(c.Field)
+		if err != nil {
+			return nil, err
+		}
+		p, err := condition(f, c)
+		if err != nil {
+			return nil, err
+		}
+		q.predicates[f] = append(q.predicates[f], p)
+	}
+	for _, o := range query.Orders {
+		f, err := m.queryFields.Lookup(o.Field)
+		if err != nil {
+			return nil, err
+		}
+		q.orders = append(q.orders, crud.SortField{Field: f, Desc: o.Desc})
+	}
+	for _, name := range query.Fields {
+		f, err := m.queryFields.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		q.fields = append(q.fields, f)
+	}
+	return q, nil
+}
+
+func (q *compiled) match(ctx context.Context, row reflect.Value) bool {
+	for f, predicates := range q.predicates {
+		v := value(ctx, f, row)
+		for _, p := range predicates {
+			if !p(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// project keeps only the selected fields, as the columns of SELECT.
+func project[T any](ctx context.Context, q *compiled, list []*T) []*T {
+	if len(q.fields) == 0 {
+		return list
+	}
+	projected := make([]*T, 0, len(list))
+	for _, t := range list {
+		p := new(T)
+		src, dst := reflect.ValueOf(t).Elem(), reflect.ValueOf(p).Elem()
+		for _, f := range q.fields {
+			v, _ := f.ValueOf(ctx, src)
+			_ = f.Set(ctx, dst, v)
+		}
+		projected = append(projected, p)
+	}
+	return projected
+}
+
+func condition(f *schema.Field, c crud.Condition) (predicate, error) {
+	vs, err := c.Resolve(f)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vs {
+		vs[i] = normalize(v)
+	}
+
+	switch c.Op {
+	case crud.OpEq, crud.OpNe:
+		return func(v any) bool {
+			n, ok := compare(v, vs[0])
+			return ok && (n == 0) == (c.Op == crud.OpEq)
+		}, nil
+	case crud.OpIn:
+		return func(v any) bool {
+			for _, x := range vs {
+				if n, ok := compare(v, x); ok && n == 0 {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case crud.OpLike:
+		re := like(vs[0].(string))
+		return func(v any) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		}, nil
+	case crud.OpRange:
+		return func(v any) bool {
+			if vs[0] != nil {
+				if n, ok := compare(v, vs[0]); !ok || n < 0 {
+					return false
+				}
+			}
+			if vs[1] != nil {
+				if n, ok := compare(v, vs[1]); !ok || n > 0 {
+					return false
+				}
+			}
+			return v != nil
+		}, nil
+	case crud.OpIsNull:
+		return func(v any) bool { return v == nil }, nil
+	default:
+		return func(v any) bool { return v != nil }, nil
+	}
+}
+
+// like matches case-insensitively as the default collations of SQLite and MySQL.
+func like(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// value returns the normalized value of f in row, nil for NULL.
+func value(ctx context.Context, f *schema.Field, row reflect.Value) any {
+	v, _ := f.ValueOf(ctx, row)
+	return normalize(v)
+}
+
+// normalize resolves pointers and driver.Valuer, the numbers are int64, uint64 or float64.
+func normalize(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	if valuer, ok := rv.Interface().(driver.Valuer); ok {
+		dv, err := valuer.Value()
+		if err != nil || dv == nil {
+			return nil
+		}
+		if _, ok := dv.(driver.Valuer); !ok {
+			return normalize(dv)
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	}
+	if rv.Type().ConvertibleTo(timeType) && rv.Kind() == reflect.Struct {
+		return rv.Convert(timeType).Interface()
+	}
+	return rv.Interface()
+}
+
+// compare returns false if a and b are not comparable, e.g. NULL.
+func compare(a, b any) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		return x.Compare(y), ok
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		return cmp.Compare(boolInt(x), boolInt(y)), ok
+	case int64:
+		if y, ok := b.(int64); ok {
+			return cmp.Compare(x, y), true
+		}
+	}
+	x, ok1 := float(a)
+	y, ok2 := float(b)
+	if ok1 && ok2 {
+		return cmp.Compare(x, y), true
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return 0, false
+}
+
+// compareNull is compare with NULL before any value.
+func compareNull(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	n, _ := compare(a, b)
+	return n
+}
+
+// compareRow compares the sort values of row with values in the orders.
+func compareRow(ctx context.Context, row reflect.Value, values []any, orders []crud.SortField) int {
+	for i, o := range orders {
+		c := compareNull(value(ctx, o.Field, row), values[i])
+		if o.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func float(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package crudtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+// softDelete fails for the models without a gorm.DeletedAt field, as the real one.
+func (m *Memory[T]) softDelete() error {
+	if err := m.parse(); err != nil {
+		return err
+	}
+	if m.deletedAt == nil {
+		return fmt.Errorf("crudtest: %s has no gorm.DeletedAt field for soft delete", m.schema.Name)
+	}
+	return nil
+}
+
+func (m *Memory[T]) SelectListWithDeleted(ctx context.Context, pagination *protobuf.Pagination, query *crud.Query) ([]*T, error) {
+	return m.selectListBy(ctx, scopeWithDeleted, pagination, query)
+}
+
+func (m *Memory[T]) SelectDeleted(ctx context.Context, pagination *protobuf.Pagination, query *crud.Query) ([]*T, error) {
+	if err := m.softDelete(); err != nil {
+		return nil, err
+	}
+	return m.selectListBy(ctx, scopeDeleted, pagination, query)
+}
+
+func (m *Memory[T]) Restore(ctx context.Context, id int64) error {
+	if err := m.softDelete(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.rows[id]
+	if !ok || !m.deleted(ctx, t) {
+		return gorm.ErrRecordNotFound
+	}
+	t = clone(t)
+	if err := m.deletedAt.Set(ctx, reflect.ValueOf(t).Elem(), gorm.DeletedAt{}); err != nil {
+		return err
+	}
+	m.touch(ctx, t, false)
+	m.rows[id] = t
+	return nil
+}
+
+func (m *Memory[T]) ForceDelete(ctx context.Context, id int64) error {
+	if err := m.parse(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.rows, id)
+	return nil
+}
+
+// PurgeDeleted removes the rows at once, batchSize is ignored.
+func (m *Memory[T]) PurgeDeleted(ctx context.Context, before time.Time, _ int) (int64, error) {
+	if err := m.softDelete(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, t := range m.rows {
+		v, _ := m.deletedAt.ValueOf(ctx, reflect.ValueOf(t).Elem())
+		if d, _ := v.(gorm.DeletedAt); d.Valid && d.Time.Before(before) {
+			delete(m.rows, id)
+			n++
+		}
+	}
+	return n, nil
+}
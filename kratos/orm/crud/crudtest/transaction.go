@@ -0,0 +1,90 @@
+package crudtest
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+// Snapshotter is a fake store of a Transaction, restore brings back the state at Snapshot.
+type Snapshotter interface {
+	Snapshot() (restore func())
+}
+
+type txContextKey struct{}
+
+// Transaction is a fake orm.Transaction, the stores are restored when fn returns an error or panics.
+//
+// the transactions are serialized, a nested call joins the current transaction as orm.PropagationRequired
+// does, its writes are kept unless the outer fn fails. the TxOption are ignored, and the writes made outside
+// of a transaction by other goroutines are lost on restore.
+//
+// ctx is bound by orm.NewTxContext, so the hooks of orm.AfterCommit and orm.AfterRollback run once the
+// transaction ends, and orm.FromContext reports the transaction with a nil *gorm.DB.
+//
+// there is no database, WithContext returns nil, so it only pairs with the Memory repositories.
+type Transaction struct {
+	mu     sync.Mutex
+	stores []Snapshotter
+}
+
+var _ orm.Transaction = (*Transaction)(nil)
+
+func NewTransaction(stores ...Snapshotter) *Transaction {
+	return &Transaction{stores: stores}
+}
+
+func (tx *Transaction) Transaction(ctx context.Context, fn func(ctx context.Context) error, _ ...orm.TxOption) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(txContextKey{}) == tx {
+		return fn(ctx)
+	}
+
+	txCtx, finish := orm.NewTxContext(ctx, nil)
+	txCtx = context.WithValue(txCtx, txContextKey{}, tx)
+
+	// the hooks run after the unlock, they may start another transaction.
+	committed := false
+	defer func() {
+		finish(committed)
+	}()
+
+	err = tx.run(txCtx, fn)
+	committed = err == nil
+	return err
+}
+
+func (tx *Transaction) run(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	restores := make([]func(), 0, len(tx.stores))
+	for _, s := range tx.stores {
+		restores = append(restores, s.Snapshot())
+	}
+	restore := func() {
+		for _, r := range restores {
+			r()
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			restore()
+			panic(r)
+		}
+		if err != nil {
+			restore()
+		}
+	}()
+	return fn(ctx)
+}
+
+func (tx *Transaction) WithContext(context.Context) *gorm.DB {
+	return nil
+}
@@ -0,0 +1,175 @@
+package crudtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/kratos/orm/crud/crudtest"
+)
+
+type Tag struct {
+	ID   int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Name string `gorm:"column:name;"`
+}
+
+func TestTransaction(t *testing.T) {
+	tags := crudtest.New[Tag]()
+	notes := crudtest.New[Note]()
+	txm := crudtest.NewTransaction(tags, notes)
+	ctx := context.Background()
+
+	// a nested call joins the transaction, its writes are kept when the outer one commits.
+	var hooks []string
+	err := txm.Transaction(ctx, func(ctx context.Context) error {
+		orm.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "commit") })
+		orm.AfterRollback(ctx, func(context.Context) { hooks = append(hooks, "rollback") })
+
+		tx, ok := orm.FromContext(ctx)
+		assert.True(t, ok)
+		assert.Nil(t, tx)
+
+		if err := tags.Create(ctx, &Tag{Name: "outer"}); err != nil {
+			return err
+		}
+		err := txm.Transaction(ctx, func(ctx context.Context) error {
+			_ = notes.Create(ctx, &Note{Title: "inner"})
+			return errors.New("inner")
+		})
+		assert.EqualError(t, err, "inner")
+		assert.Empty(t, hooks)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"commit"}, hooks)
+	_, err = tags.SelectOne(ctx, 1)
+	assert.NoError(t, err)
+	_, err = notes.SelectOne(ctx, 1)
+	assert.NoError(t, err)
+
+	// the outer failure restores every write, the hooks may start another transaction.
+	hooks = nil
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		orm.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "commit") })
+		orm.AfterRollback(ctx, func(ctx context.Context) {
+			hooks = append(hooks, "rollback")
+			_ = txm.Transaction(ctx, func(ctx context.Context) error { return nil })
+		})
+		_ = txm.Transaction(ctx, func(ctx context.Context) error {
+			return notes.Create(ctx, &Note{Title: "inner"})
+		})
+		return errors.New("outer")
+	})
+	assert.EqualError(t, err, "outer")
+	assert.Equal(t, []string{"rollback"}, hooks)
+	_, err = notes.SelectOne(ctx, 2)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	hooks = nil
+	assert.Panics(t, func() {
+		_ = txm.Transaction(ctx, func(ctx context.Context) error {
+			orm.AfterRollback(ctx, func(context.Context) { hooks = append(hooks, "rollback") })
+			_ = tags.Create(ctx, &Tag{Name: "panic"})
+			panic("panic")
+		})
+	})
+	assert.Equal(t, []string{"rollback"}, hooks)
+	_, err = tags.SelectOne(ctx, 2)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestTransactionCached(t *testing.T) {
+	tags := crudtest.New[Tag]()
+	txm := crudtest.NewTransaction(tags)
+	cached := crud.NewCached[Tag, int64](tags, nil)
+	ctx := context.Background()
+
+	tag := &Tag{Name: "a"}
+	assert.NoError(t, cached.Create(ctx, tag))
+	_, err := cached.SelectOne(ctx, tag.ID)
+	assert.NoError(t, err)
+
+	// the transaction reads its own writes past the cache, they are not cached when it rolls back.
+	update := func(ctx context.Context) error {
+		if err := cached.Update(ctx, tag.ID, &Tag{ID: tag.ID, Name: "b"}); err != nil {
+			return err
+		}
+		got, err := cached.SelectOne(ctx, tag.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "b", got.Name)
+		return nil
+	}
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := update(ctx); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.EqualError(t, err, "rollback")
+	got, err := cached.SelectOne(ctx, tag.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", got.Name)
+
+	// the cache is invalidated once it commits.
+	assert.NoError(t, txm.Transaction(ctx, update))
+	got, err = cached.SelectOne(ctx, tag.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got.Name)
+}
+
+func TestMemory(t *testing.T) {
+	tags := crudtest.New[Tag]()
+	ctx := context.Background()
+
+	tag := &Tag{Name: "a"}
+	assert.NoError(t, tags.Create(ctx, tag))
+	assert.ErrorIs(t, tags.Create(ctx, &Tag{ID: tag.ID}), gorm.ErrDuplicatedKey)
+
+	// the returned rows are copies.
+	got, _ := tags.SelectOne(ctx, tag.ID)
+	got.Name = "modified"
+	got, _ = tags.SelectOne(ctx, tag.ID)
+	assert.Equal(t, "a", got.Name)
+
+	// without gorm.DeletedAt the row is removed.
+	assert.NoError(t, tags.Delete(ctx, tag.ID))
+	assert.NoError(t, tags.Create(ctx, &Tag{ID: tag.ID, Name: "b"}))
+
+	_, err := tags.Upsert(ctx, []*Tag{{Name: "c"}}, crud.DoNothing())
+	assert.ErrorIs(t, err, crudtest.ErrUpsertOptions)
+}
+
+type Doc struct {
+	ID    int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Title string `gorm:"column:title;"`
+	orm.VersionedDBModel
+}
+
+func TestMemoryBulkVersioned(t *testing.T) {
+	docs := crudtest.New[Doc]()
+	ctx := context.Background()
+
+	doc := &Doc{Title: "a"}
+	doc.Version = 1
+	assert.NoError(t, docs.Create(ctx, doc))
+
+	_, err := docs.UpdateByIDs(ctx, []int64{doc.ID}, map[string]any{"title": "b"})
+	assert.NoError(t, err)
+	got, _ := docs.SelectOne(ctx, doc.ID)
+	assert.Equal(t, int64(2), got.Version)
+
+	doc.Title = "c"
+	assert.ErrorIs(t, docs.Update(ctx, doc.ID, doc), orm.ErrStaleObject)
+
+	// so does Upsert, the stale version is not written.
+	_, err = docs.Upsert(ctx, []*Doc{doc})
+	assert.NoError(t, err)
+	got, _ = docs.SelectOne(ctx, doc.ID)
+	assert.Equal(t, "c", got.Title)
+	assert.Equal(t, int64(3), got.Version)
+}
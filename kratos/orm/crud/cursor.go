@@ -0,0 +1,318 @@
+package crud
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/protobuf"
+)
+
+var (
+	ErrInvalidPageToken = errors.BadRequest("INVALID_PAGE_TOKEN", "invalid page token")
+)
+
+const (
+	// DefaultPageSize is the page size of a nil pagination or a page size <= 0, the same as protobuf.CursorWrap.
+	DefaultPageSize = 20
+	// MaxPageSize caps the page size of a cursor pagination.
+	MaxPageSize = 1000
+)
+
+// CursorLimit returns the page size of pagination, DefaultPageSize if it is not set, at most MaxPageSize.
+func CursorLimit(pagination *protobuf.CursorPagination) int {
+	limit := int(pagination.GetPageSize())
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(limit, MaxPageSize)
+}
+
+// defaultCursorSecret signs the page tokens without WithCursorSecret, the tokens are only valid in this process.
+var defaultCursorSecret = func() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}()
+
+var warnCursorSecret sync.Once
+
+// cursorSecret returns secret, or the default secret with a warning logged once.
+func cursorSecret(secret []byte) []byte {
+	if len(secret) > 0 {
+		return secret
+	}
+	warnCursorSecret.Do(func() {
+		log.Warn("crud: the page tokens are signed with a random secret of this process, they fail on the other " +
+			"replicas and after a restart, set one shared secret with WithCursorSecret or NewKeyset")
+	})
+	return defaultCursorSecret
+}
+
+// SortField is an Order resolved against the model.
+type SortField struct {
+	Field *schema.Field
+	Desc  bool
+}
+
+// KeysetSort appends the primary key fields missing in sorts as the tie-breaker,
+// in the direction of the last sort field.
+func KeysetSort(s *schema.Schema, sorts []SortField) []SortField {
+	sorts = slices.Clone(sorts)
+	var desc bool
+	if len(sorts) > 0 {
+		desc = sorts[len(sorts)-1].Desc
+	}
+	for _, pk := range s.PrimaryFields {
+		if !slices.ContainsFunc(sorts, func(o SortField) bool { return o.Field == pk }) {
+			sorts = append(sorts, SortField{Field: pk, Desc: desc})
+		}
+	}
+	return sorts
+}
+
+// cursorToken is the signed payload of a page token.
+type cursorToken struct {
+	// Order 生成 token 时的排序, 排序变化后 token 失效
+	Order string `json:"o"`
+	// Values 上一页最后一行的排序字段值
+	Values []any `json:"v"`
+}
+
+// Keyset paginates by the sort order instead of COUNT and OFFSET, rows inserted between pages are
+// neither skipped nor duplicated.
+//
+// the primary key fields are appended to orders as the tie-breaker, the sort fields should be NOT NULL.
+// use Scope as a gorm scope, then Page on the result to fill the next page token.
+//
+//	keyset := crud.NewKeyset[MyModel](pagination, secret, crud.Desc("created_at"))
+//	err := db.Scopes(keyset.Scope).Find(&list).Error
+//	list, err = keyset.Page(list)
+type Keyset[T any] struct {
+	pagination *protobuf.CursorPagination
+	orders     []Order
+	secret     []byte
+	fields     Fields
+
+	resolved []SortField
+	// last 上一批最后一行的排序字段值, Each 由此继续而不经过 page token
+	last []any
+}
+
+// NewKeyset the pagination is usually from protobuf.CursorWrap, the secret must be shared by every instance
+// of the service. a nil secret uses a random secret of this process and logs a warning, for the tests only.
+// the page size is CursorLimit of pagination, a nil pagination is the first page of DefaultPageSize.
+func NewKeyset[T any](pagination *protobuf.CursorPagination, secret []byte, orders ...Order) *Keyset[T] {
+	if pagination == nil {
+		pagination = &protobuf.CursorPagination{}
+	}
+	return &Keyset[T]{
+		pagination: pagination,
+		orders:     orders,
+		secret:     cursorSecret(secret),
+	}
+}
+
+// Scope adds the keyset condition of the page token, the ordering and the limit.
+func (k *Keyset[T]) Scope(db *gorm.DB) *gorm.DB {
+	if err := k.resolve(db); err != nil {
+		_ = db.AddError(err)
+		return db
+	}
+
+	if k.last != nil {
+		db = db.Where(k.after(k.last))
+	} else if token := k.pagination.GetPageToken(); token != "" {
+		values, err := DecodeCursor(k.secret, token, k.resolved)
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		db = db.Where(k.after(values))
+	}
+	for _, o := range k.resolved {
+		db = db.Order(clause.OrderByColumn{Column: column(o.Field), Desc: o.Desc})
+	}
+	// one more row tells whether there is a next page.
+	return db.Limit(CursorLimit(k.pagination) + 1)
+}
+
+// Page trims the extra row fetched by Scope and sets the NextPageToken of the pagination.
+func (k *Keyset[T]) Page(list []*T) ([]*T, error) {
+	k.pagination.NextPageToken = ""
+	list, values := k.trim(list)
+	if values == nil {
+		return list, nil
+	}
+
+	token, err := EncodeCursor(k.secret, k.resolved, values)
+	if err != nil {
+		return nil, err
+	}
+	k.pagination.NextPageToken = token
+	return list, nil
+}
+
+// trim drops the extra row fetched by Scope and returns the sort values of the last row, nil on the last page.
+func (k *Keyset[T]) trim(list []*T) ([]*T, []any) {
+	limit := CursorLimit(k.pagination)
+	if len(list) <= limit {
+		return list, nil
+	}
+
+	list = list[:limit]
+	last := reflect.ValueOf(list[limit-1]).Elem()
+	values := make([]any, 0, len(k.resolved))
+	for _, o := range k.resolved {
+		v, _ := o.Field.ValueOf(context.Background(), last)
+		values = append(values, v)
+	}
+	return list, values
+}
+
+func (k *Keyset[T]) resolve(db *gorm.DB) error {
+	if k.resolved != nil {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return err
+	}
+	fields := k.fields
+	if fields == nil {
+		fields = NewQueryFields(stmt.Schema)
+	}
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		return invalidQuery("%s has no primary key for keyset pagination", stmt.Schema.Name)
+	}
+
+	sorts := make([]SortField, 0, len(k.orders))
+	for _, o := range k.orders {
+		f, err := fields.Lookup(o.Field)
+		if err != nil {
+			return err
+		}
+		sorts = append(sorts, SortField{Field: f, Desc: o.Desc})
+	}
+	resolved := KeysetSort(stmt.Schema, sorts)
+	k.resolved = resolved
+	return nil
+}
+
+// columns are the columns the next page token is made of.
+func (k *Keyset[T]) columns() []string {
+	columns := make([]string, 0, len(k.resolved))
+	for _, o := range k.resolved {
+		columns = append(columns, o.Field.DBName)
+	}
+	return columns
+}
+
+// after is (c1 > v1) OR (c1 = v1 AND c2 > v2) OR ..., with < for the descending columns.
+func (k *Keyset[T]) after(values []any) clause.Expression {
+	or := make([]clause.Expression, 0, len(k.resolved))
+	for i, o := range k.resolved {
+		and := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: column(k.resolved[j].Field), Value: values[j]})
+		}
+		if o.Desc {
+			and = append(and, clause.Lt{Column: column(o.Field), Value: values[i]})
+		} else {
+			and = append(and, clause.Gt{Column: column(o.Field), Value: values[i]})
+		}
+		or = append(or, clause.And(and...))
+	}
+	return clause.Or(or...)
+}
+
+// cursorOrder is the sort order a page token is bound to.
+func cursorOrder(sorts []SortField) string {
+	var b strings.Builder
+	for i, o := range sorts {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(o.Field.DBName)
+		if o.Desc {
+			b.WriteString(" desc")
+		}
+	}
+	return b.String()
+}
+
+func sign(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// EncodeCursor returns the page token of values, the sort values of the last row of a page,
+// bound to sorts and signed with secret, a nil secret is the random secret of this process, see NewKeyset.
+//
+// the token is base64(payload).base64(hmac-sha256(payload)).
+func EncodeCursor(secret []byte, sorts []SortField, values []any) (string, error) {
+	secret = cursorSecret(secret)
+	payload, err := json.Marshal(cursorToken{Order: cursorOrder(sorts), Values: values})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(secret, payload)), nil
+}
+
+// DecodeCursor returns the sort values of token converted to the type of the sort fields,
+// ErrInvalidPageToken if it is malformed, signed with another secret or bound to another sort order.
+func DecodeCursor(secret []byte, token string, sorts []SortField) ([]any, error) {
+	secret = cursorSecret(secret)
+	data, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidPageToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, sign(secret, payload)) {
+		return nil, ErrInvalidPageToken
+	}
+
+	var t cursorToken
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&t); err != nil || t.Order != cursorOrder(sorts) || len(t.Values) != len(sorts) {
+		return nil, ErrInvalidPageToken
+	}
+
+	values := make([]any, 0, len(t.Values))
+	for i, v := range t.Values {
+		if n, ok := v.(json.Number); ok {
+			v = n.String()
+		}
+		if v == nil {
+			return nil, ErrInvalidPageToken
+		}
+		cv, err := convert(sorts[i].Field, v)
+		if err != nil {
+			return nil, ErrInvalidPageToken
+		}
+		values = append(values, cv)
+	}
+	return values, nil
+}
@@ -0,0 +1,156 @@
+package crud_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+func newCursorDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := newProductDB(t)
+	if err := db.Where("1 = 1").Delete(&Product{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// duplicated prices to check the id tie-breaker.
+	for i := 1; i <= 25; i++ {
+		if err := db.Create(&Product{Name: fmt.Sprintf("p%02d", i), Price: int64(i % 4)}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestCRUD_SelectListByCursor(t *testing.T) {
+	db := newCursorDB(t)
+	repo := crud.New[Product](db, crud.WithCursorSecret([]byte("secret")))
+	ctx := context.Background()
+	query := &crud.Query{
+		Conditions: []crud.Condition{crud.Ne("name", "p01")},
+		Orders:     []crud.Order{crud.Desc("price")},
+		Fields:     []string{"name"},
+	}
+
+	var (
+		seen  = make(map[int64]bool)
+		last  *Product
+		pages int
+		token string
+	)
+	for {
+		pagination := protobuf.CursorWrap(&protobuf.CursorPagination{PageSize: 10, PageToken: token})
+		list, err := repo.SelectListByCursor(ctx, pagination, query)
+		if !assert.NoError(t, err) {
+			return
+		}
+		pages++
+
+		for _, p := range list {
+			assert.False(t, seen[p.ID], "duplicated %d", p.ID)
+			seen[p.ID] = true
+			if last != nil {
+				assert.True(t, p.Price < last.Price || p.Price == last.Price && p.ID < last.ID)
+			}
+			last = p
+		}
+
+		// a row inserted ahead of the cursor is not returned twice.
+		if pages == 1 {
+			assert.NoError(t, db.Create(&Product{Name: "new", Price: 3}).Error)
+		}
+
+		token = pagination.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+	assert.Equal(t, 3, pages)
+	assert.Len(t, seen, 24)
+}
+
+func TestCRUD_SelectListByCursorToken(t *testing.T) {
+	repo := crud.New[Product](newCursorDB(t), crud.WithCursorSecret([]byte("secret")))
+	ctx := context.Background()
+
+	pagination := protobuf.CursorWrap(&protobuf.CursorPagination{PageSize: 5})
+	_, err := repo.SelectListByCursor(ctx, pagination, nil)
+	assert.NoError(t, err)
+	token := pagination.NextPageToken
+	assert.NotEmpty(t, token)
+
+	tokens := []string{
+		"garbage",
+		token[:len(token)-2] + "xx",
+		"e30." + token[len(token)-43:],
+	}
+	for _, token := range tokens {
+		_, err = repo.SelectListByCursor(ctx, &protobuf.CursorPagination{PageSize: 5, PageToken: token}, nil)
+		assert.ErrorIs(t, err, crud.ErrInvalidPageToken)
+	}
+
+	// signed by another secret.
+	other := crud.New[Product](newCursorDB(t), crud.WithCursorSecret([]byte("other")))
+	_, err = other.SelectListByCursor(ctx, &protobuf.CursorPagination{PageSize: 5, PageToken: token}, nil)
+	assert.ErrorIs(t, err, crud.ErrInvalidPageToken)
+
+	// bound to the sort order.
+	_, err = repo.SelectListByCursor(ctx, &protobuf.CursorPagination{PageSize: 5, PageToken: token}, &crud.Query{
+		Orders: []crud.Order{crud.Asc("price")},
+	})
+	assert.ErrorIs(t, err, crud.ErrInvalidPageToken)
+}
+
+func TestCRUD_SelectListByCursorPageSize(t *testing.T) {
+	repo := crud.New[Product](newCursorDB(t), crud.WithCursorSecret([]byte("secret")))
+	ctx := context.Background()
+
+	// a zero page size is the default page size.
+	pagination := &protobuf.CursorPagination{}
+	list, err := repo.SelectListByCursor(ctx, pagination, nil)
+	assert.NoError(t, err)
+	assert.Len(t, list, crud.DefaultPageSize)
+	assert.NotEmpty(t, pagination.NextPageToken)
+
+	list, err = repo.SelectListByCursor(ctx, &protobuf.CursorPagination{PageToken: pagination.NextPageToken}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, list, 25-crud.DefaultPageSize)
+
+	// a nil pagination is the first page.
+	list, err = repo.SelectListByCursor(ctx, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, list, crud.DefaultPageSize)
+
+	assert.Equal(t, crud.MaxPageSize, crud.CursorLimit(&protobuf.CursorPagination{PageSize: 1 << 30}))
+}
+
+func TestKeyset_Scope(t *testing.T) {
+	db := newCursorDB(t)
+
+	var names []string
+	pagination := protobuf.CursorWrap(&protobuf.CursorPagination{PageSize: 4})
+	for {
+		keyset := crud.NewKeyset[Product](pagination, nil, crud.Asc("name"))
+		var list []*Product
+		assert.NoError(t, db.Where("price = ?", 1).Scopes(keyset.Scope).Find(&list).Error)
+		list, err := keyset.Page(list)
+		assert.NoError(t, err)
+		for _, p := range list {
+			names = append(names, p.Name)
+		}
+		if pagination.NextPageToken == "" {
+			break
+		}
+		pagination = &protobuf.CursorPagination{PageSize: 4, PageToken: pagination.NextPageToken}
+	}
+	assert.Equal(t, []string{"p01", "p05", "p09", "p13", "p17", "p21", "p25"}, names)
+
+	keyset := crud.NewKeyset[Product](pagination, nil, crud.Asc("unknown"))
+	assert.ErrorIs(t, db.Scopes(keyset.Scope).Find(&[]*Product{}).Error, crud.ErrInvalidQuery)
+}
@@ -0,0 +1,56 @@
+package crud
+
+import (
+	"context"
+	"iter"
+
+	"github.com/omalloc/contrib/protobuf"
+)
+
+// defaultBatchSize is the batch size of Each when batchSize <= 0, the batch size is at most MaxPageSize.
+const defaultBatchSize = 500
+
+// Each the batches are fetched by keyset on the query orders and the primary key, so the memory
+// holds one batch at most and the rows changed during the iteration are neither skipped nor duplicated.
+//
+// inside an orm.Transaction every batch is read by the transaction.
+//
+//	for t, err := range repo.Each(ctx, query, 1000) {
+//		if err != nil {
+//			return err
+//		}
+//	}
+func (r *crud[T, ID]) Each(ctx context.Context, query *Query, batchSize int) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		if batchSize <= 0 {
+			batchSize = defaultBatchSize
+		}
+		tx, keyset, err := r.keyset(ctx, &protobuf.CursorPagination{PageSize: int32(batchSize)}, query)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			var list []*T
+			if err := tx.Scopes(keyset.Scope).Find(&list).Error; err != nil {
+				yield(nil, err)
+				return
+			}
+			list, keyset.last = keyset.trim(list)
+			for _, t := range list {
+				if !yield(t, nil) {
+					return
+				}
+			}
+			if keyset.last == nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package crud_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+func TestCRUD_Each(t *testing.T) {
+	db := newCursorDB(t)
+	repo := crud.New[Product](db)
+	ctx := context.Background()
+	query := &crud.Query{
+		Conditions: []crud.Condition{crud.Ne("name", "p01")},
+		Orders:     []crud.Order{crud.Desc("price")},
+	}
+
+	var (
+		seen = make(map[int64]bool)
+		last *Product
+	)
+	for p, err := range repo.Each(ctx, query, 7) {
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, seen[p.ID], "duplicated %d", p.ID)
+		seen[p.ID] = true
+		if last != nil {
+			assert.True(t, p.Price < last.Price || p.Price == last.Price && p.ID < last.ID)
+		}
+		last = p
+	}
+	assert.Len(t, seen, 24)
+
+	// break stops the iteration.
+	n := 0
+	for range repo.Each(ctx, nil, 0) {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, n)
+
+	for _, err := range repo.Each(ctx, &crud.Query{Orders: []crud.Order{crud.Asc("unknown")}}, 10) {
+		assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+	}
+}
+
+func TestCRUD_EachCanceled(t *testing.T) {
+	db := newCursorDB(t)
+	repo := crud.New[Product](db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		n   int
+		err error
+	)
+	for p, e := range repo.Each(ctx, nil, 10) {
+		if e != nil {
+			err = e
+			break
+		}
+		assert.NotNil(t, p)
+		// canceled in the first batch, the next batch is not fetched.
+		if n++; n == 5 {
+			cancel()
+		}
+	}
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 10, n)
+}
+
+func TestCRUD_EachTransaction(t *testing.T) {
+	db := newCursorDB(t)
+	txm := orm.NewTransactionManager(&txData{db: db})
+	repo := crud.NewWithTx[Product](txm)
+	ctx := context.Background()
+
+	err := txm.Transaction(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Product{Name: "uncommitted"}); err != nil {
+			return err
+		}
+		n := 0
+		for _, err := range repo.Each(ctx, nil, 10) {
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		// the uncommitted row is read by the transaction.
+		assert.Equal(t, 26, n)
+		return errors.New("rollback")
+	})
+	assert.EqualError(t, err, "rollback")
+}
@@ -0,0 +1,154 @@
+package crud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+var (
+	ErrInvalidFieldMask = errors.BadRequest("INVALID_FIELD_MASK", "invalid field mask")
+)
+
+// FieldMask is implemented by *fieldmaskpb.FieldMask and Paths.
+type FieldMask interface {
+	GetPaths() []string
+}
+
+// Paths is a FieldMask of field names.
+type Paths []string
+
+func (p Paths) GetPaths() []string {
+	return p
+}
+
+// WithImmutableFields rejects fields in UpdateFields, besides the primary key and the create-only fields.
+func WithImmutableFields(fields ...string) Option {
+	return func(o *options) {
+		o.immutableFields = fields
+	}
+}
+
+func invalidFieldMask(format string, args ...any) error {
+	return errors.New(int(ErrInvalidFieldMask.Code), ErrInvalidFieldMask.Reason, fmt.Sprintf(format, args...))
+}
+
+// snake converts a lowerCamel json name of the proto field, e.g. pageSize to page_size.
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Mask resolves the paths of mask to the updatable fields, the paths are field names or the lowerCamel
+// json names of the proto fields. the unknown, primary key, create-only and immutable fields are rejected
+// with ErrInvalidFieldMask.
+func (fs Fields) Mask(mask FieldMask, immutable ...string) ([]*schema.Field, error) {
+	var paths []string
+	if mask != nil {
+		paths = mask.GetPaths()
+	}
+	if len(paths) == 0 {
+		return nil, invalidFieldMask("empty field mask")
+	}
+
+	immutables := make(map[*schema.Field]bool)
+	for _, name := range immutable {
+		if f, ok := fs[name]; ok {
+			immutables[f] = true
+		}
+	}
+
+	fields := make([]*schema.Field, 0, len(paths))
+	for _, path := range paths {
+		f, ok := fs[path]
+		if !ok {
+			f, ok = fs[snake(path)]
+		}
+		if !ok {
+			return nil, invalidFieldMask("unknown field %q", path)
+		}
+		if f.PrimaryKey || !f.Updatable || f.AutoCreateTime > 0 || immutables[f] {
+			return nil, invalidFieldMask("immutable field %q", path)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// maskColumns resolves the paths to the updatable columns of the model.
+func (r *crud[T, ID]) maskColumns(ctx context.Context, mask FieldMask) ([]string, error) {
+	if err := r.parse(ctx); err != nil {
+		return nil, err
+	}
+
+	fields, err := NewFields(r.schema).Mask(mask, r.opts.immutableFields...)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, 0, len(fields))
+	for _, f := range fields {
+		columns = append(columns, f.DBName)
+	}
+
+	// the update time is maintained as Save does.
+	for _, f := range r.schema.Fields {
+		if f.AutoUpdateTime > 0 && f.DBName != "" {
+			columns = append(columns, f.DBName)
+		}
+	}
+	return columns, nil
+}
+
+// UpdateFields only writes the columns of mask, including their zero values.
+func (r *crud[T, ID]) UpdateFields(ctx context.Context, id ID, t *T, mask FieldMask) error {
+	columns, err := r.maskColumns(ctx, mask)
+	if err != nil {
+		return err
+	}
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	v, versioned := any(t).(orm.Versioned)
+	if !versioned {
+		return r.db.WithContext(ctx).
+			Model(new(T)).
+			Where(where).
+			Select(columns).
+			Updates(t).Error
+	}
+
+	// checked against the version that was read, as Update does.
+	version := v.GetVersion()
+	v.SetVersion(version + 1)
+
+	result := r.db.WithContext(ctx).
+		Model(new(T)).
+		Where(where).
+		Where("version = ?", version).
+		Select(append(columns, "version")).
+		Updates(t)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = orm.ErrStaleObject
+	}
+	if result.Error != nil {
+		v.SetVersion(version)
+	}
+	return result.Error
+}
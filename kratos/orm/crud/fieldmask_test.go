@@ -0,0 +1,73 @@
+package crud_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+type Profile struct {
+	ID       int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Nickname string `gorm:"column:nickname;" json:"nickname"`
+	PageSize int32  `gorm:"column:page_size;" json:"page_size"`
+	Email    string `gorm:"column:email;" json:"email"`
+	Owner    string `gorm:"column:owner;<-:create;" json:"owner"`
+
+	orm.VersionedDBModel
+}
+
+func TestCRUD_UpdateFields(t *testing.T) {
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Profile{}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := crud.New[Profile](db, crud.WithImmutableFields("email"))
+	ctx := context.Background()
+
+	profile := &Profile{Nickname: "alice", PageSize: 20, Email: "alice@example.com", Owner: "alice"}
+	assert.NoError(t, repo.Create(ctx, profile))
+	createdAt := profile.CreatedAt
+	time.Sleep(10 * time.Millisecond)
+
+	// a PATCH of nickname and page size, the zero page size is written.
+	patch := &Profile{Nickname: "bob", Email: "ignored"}
+	patch.Version = profile.Version
+	err = repo.UpdateFields(ctx, profile.ID, patch, &fieldmaskpb.FieldMask{Paths: []string{"nickname", "pageSize"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), patch.Version)
+
+	got, err := repo.SelectOne(ctx, profile.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", got.Nickname)
+	assert.Equal(t, int32(0), got.PageSize)
+	assert.Equal(t, "alice@example.com", got.Email)
+	assert.Equal(t, "alice", got.Owner)
+	assert.Equal(t, int64(2), got.Version)
+	assert.True(t, got.CreatedAt.Equal(createdAt))
+	assert.True(t, got.UpdatedAt.After(createdAt))
+
+	// stale version.
+	patch = &Profile{Nickname: "carol"}
+	patch.Version = 1
+	err = repo.UpdateFields(ctx, profile.ID, patch, crud.Paths{"Nickname"})
+	assert.ErrorIs(t, err, orm.ErrStaleObject)
+	assert.Equal(t, int64(1), patch.Version)
+
+	for _, paths := range []crud.Paths{nil, {"unknown"}, {"id"}, {"email"}, {"owner"}, {"created_at"}, {"nickname = 1"}} {
+		err = repo.UpdateFields(ctx, profile.ID, &Profile{}, paths)
+		assert.ErrorIs(t, err, crud.ErrInvalidFieldMask, paths)
+	}
+}
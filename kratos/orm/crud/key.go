@@ -0,0 +1,70 @@
+package crud
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// key maps the ID of a Repository to the primary key fields of the model.
+type key struct {
+	fields []*schema.Field
+	// index 复合主键时每个主键字段在 ID 结构体中的位置
+	index [][]int
+}
+
+// newKey a single primary key takes the ID as is, a composite primary key takes the ID struct fields
+// named as the primary key fields of the model.
+func newKey(s *schema.Schema, id reflect.Type) (*key, error) {
+	if len(s.PrimaryFields) == 0 {
+		return nil, fmt.Errorf("crud: %s has no primary key", s.Name)
+	}
+	k := &key{fields: s.PrimaryFields}
+	if len(k.fields) == 1 {
+		return k, nil
+	}
+
+	for id.Kind() == reflect.Ptr {
+		id = id.Elem()
+	}
+	if id.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("crud: %s has a composite primary key, ID %s must be a struct", s.Name, id)
+	}
+	for _, f := range k.fields {
+		sf, ok := id.FieldByName(f.Name)
+		if !ok {
+			return nil, fmt.Errorf("crud: ID %s has no field %s of the %s primary key", id, f.Name, s.Name)
+		}
+		k.index = append(k.index, sf.Index)
+	}
+	return k, nil
+}
+
+// eq is the condition of the row of id.
+func (k *key) eq(id any) clause.Expression {
+	if k.index == nil {
+		return clause.Eq{Column: column(k.fields[0]), Value: id}
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(id))
+	exprs := make([]clause.Expression, 0, len(k.fields))
+	for i, f := range k.fields {
+		exprs = append(exprs, clause.Eq{Column: column(f), Value: v.FieldByIndex(k.index[i]).Interface()})
+	}
+	return clause.And(exprs...)
+}
+
+// in is the condition of the rows of ids.
+func (k *key) in(ids []any) clause.Expression {
+	if k.index == nil {
+		return clause.IN{Column: column(k.fields[0]), Values: ids}
+	}
+
+	exprs := make([]clause.Expression, 0, len(ids))
+	for _, id := range ids {
+		exprs = append(exprs, k.eq(id))
+	}
+	return clause.Or(exprs...)
+}
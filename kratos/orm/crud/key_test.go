@@ -0,0 +1,116 @@
+package crud_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+type Tenant struct {
+	Code string `gorm:"column:tenant_code;primaryKey;"`
+	Name string `gorm:"column:name;"`
+}
+
+type Member struct {
+	GroupID int64  `gorm:"column:group_id;primaryKey;autoIncrement:false;"`
+	UserID  int64  `gorm:"column:user_id;primaryKey;autoIncrement:false;"`
+	Role    string `gorm:"column:role;"`
+}
+
+type MemberKey struct {
+	GroupID int64
+	UserID  int64
+}
+
+func newKeyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Tenant{}, &Member{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRepository_StringKey(t *testing.T) {
+	repo := crud.NewRepository[Tenant, string](newKeyDB(t))
+	ctx := context.Background()
+
+	_, err := repo.CreateBatch(ctx, []*Tenant{{Code: "a", Name: "a"}, {Code: "b", Name: "b"}, {Code: "c", Name: "c"}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.Update(ctx, "a", &Tenant{Code: "a", Name: "x"}))
+	got, err := repo.SelectOne(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "x", got.Name)
+
+	n, err := repo.UpdateByIDs(ctx, []string{"b", "c"}, map[string]any{"name": "y"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	assert.NoError(t, repo.Delete(ctx, "a"))
+	_, err = repo.SelectOne(ctx, "a")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	n, err = repo.DeleteByIDs(ctx, []string{"b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
+
+func TestRepository_CompositeKey(t *testing.T) {
+	repo := crud.NewRepository[Member, MemberKey](newKeyDB(t))
+	ctx := context.Background()
+
+	_, err := repo.CreateBatch(ctx, []*Member{
+		{GroupID: 1, UserID: 1, Role: "owner"},
+		{GroupID: 1, UserID: 2, Role: "member"},
+		{GroupID: 2, UserID: 1, Role: "member"},
+	})
+	assert.NoError(t, err)
+
+	got, err := repo.SelectOne(ctx, MemberKey{GroupID: 2, UserID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "member", got.Role)
+
+	assert.NoError(t, repo.Update(ctx, MemberKey{GroupID: 2, UserID: 1}, &Member{GroupID: 2, UserID: 1, Role: "owner"}))
+	got, err = repo.SelectOne(ctx, MemberKey{GroupID: 2, UserID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "owner", got.Role)
+
+	n, err := repo.UpdateByIDs(ctx, []MemberKey{{GroupID: 1, UserID: 2}, {GroupID: 2, UserID: 1}}, map[string]any{"role": "admin"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// the keyset tie-breaker is the composite key.
+	pagination := protobuf.CursorWrap(&protobuf.CursorPagination{PageSize: 2})
+	list, err := repo.SelectListByCursor(ctx, pagination, &crud.Query{Orders: []crud.Order{crud.Asc("role")}})
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	pagination = &protobuf.CursorPagination{PageSize: 2, PageToken: pagination.NextPageToken}
+	list, err = repo.SelectListByCursor(ctx, pagination, &crud.Query{Orders: []crud.Order{crud.Asc("role")}})
+	assert.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "owner", list[0].Role)
+	}
+
+	assert.NoError(t, repo.Delete(ctx, MemberKey{GroupID: 1, UserID: 1}))
+	n, err = repo.DeleteByIDs(ctx, []MemberKey{{GroupID: 1, UserID: 2}, {GroupID: 2, UserID: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// a scalar ID does not fit the composite key.
+	_, err = crud.NewRepository[Member, int64](newKeyDB(t)).SelectOne(ctx, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package crud
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+
+	"github.com/omalloc/contrib/kratos/orm/internal/loop"
+)
+
+var (
+	ErrPurgerStarted = loop.ErrStarted
+)
+
+// Purgeable is implemented by every Repository.
+type Purgeable interface {
+	PurgeDeleted(ctx context.Context, before time.Time, batchSize int) (int64, error)
+}
+
+// Purger removes the rows soft deleted longer than the retention ago permanently every interval.
+//
+//	purger := crud.NewPurger(repo, 30*24*time.Hour)
+//	app := kratos.New(kratos.Server(httpSrv, purger))
+type Purger struct {
+	repo Purgeable
+	log  *log.Helper
+
+	retention time.Duration // 软删除行的保留时间
+	interval  time.Duration // 清理间隔
+	batchSize int           // 每批物理删除的行数
+
+	loop loop.Loop
+}
+
+type PurgerOption func(*Purger)
+
+// WithPurgeInterval set the purge interval, default is an hour, a non-positive interval keeps the default.
+func WithPurgeInterval(interval time.Duration) PurgerOption {
+	return func(p *Purger) {
+		p.interval = interval
+	}
+}
+
+// WithPurgeBatchSize set the number of rows deleted by one statement, a non-positive size keeps the default.
+func WithPurgeBatchSize(size int) PurgerOption {
+	return func(p *Purger) {
+		p.batchSize = size
+	}
+}
+
+// WithPurgeLogger set purger logger.
+func WithPurgeLogger(logger log.Logger) PurgerOption {
+	return func(p *Purger) {
+		p.log = log.NewHelper(logger)
+	}
+}
+
+func NewPurger(repo Purgeable, retention time.Duration, opts ...PurgerOption) *Purger {
+	p := &Purger{
+		repo:      repo,
+		log:       log.NewHelper(log.GetLogger()),
+		retention: retention,
+		interval:  time.Hour,
+		batchSize: defaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.interval <= 0 {
+		p.interval = time.Hour
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = defaultBatchSize
+	}
+	return p
+}
+
+// Start implements transport.Server, purges until Stop is called.
+//
+// a Purger runs once, Start returns ErrPurgerStarted while it is running and returns at once after Stop.
+func (p *Purger) Start(ctx context.Context) error {
+	return p.loop.Start(ctx, loop.Task{Interval: p.interval, Run: p.purge})
+}
+
+// Stop implements transport.Server, waits for the running purge to finish.
+// a Purger stopped before it is started never purges.
+func (p *Purger) Stop(ctx context.Context) error {
+	return p.loop.Stop(ctx)
+}
+
+func (p *Purger) purge(ctx context.Context) {
+	if _, err := p.Purge(ctx); err != nil && ctx.Err() == nil {
+		p.log.WithContext(ctx).Errorf("crud: purge soft deleted rows failed: %v", err)
+	}
+}
+
+// Purge removes the rows soft deleted before the retention, returns the number of removed rows.
+func (p *Purger) Purge(ctx context.Context) (int64, error) {
+	return p.repo.PurgeDeleted(ctx, time.Now().Add(-p.retention), p.batchSize)
+}
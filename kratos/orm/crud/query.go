@@ -0,0 +1,368 @@
+package crud
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/protobuf"
+)
+
+var (
+	ErrInvalidQuery = errors.BadRequest("INVALID_QUERY", "invalid query")
+)
+
+// Operator of a Condition.
+type Operator string
+
+const (
+	OpEq      Operator = "eq"
+	OpNe      Operator = "ne"
+	OpIn      Operator = "in"
+	OpLike    Operator = "like"
+	OpRange   Operator = "range"
+	OpIsNull  Operator = "is_null"
+	OpNotNull Operator = "not_null"
+)
+
+// Condition filters on a field, the field is the go field name, column name or json name of the model.
+type Condition struct {
+	Field  string
+	Op     Operator
+	Values []any
+}
+
+// Order sorts on a field.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the filtering, sorting and projection of SelectListBy.
+//
+// only the columns of the model are accepted, but the fields tagged `json:"-"` which are never exposed,
+// see WithQueryFields to narrow it down.
+type Query struct {
+	// Conditions 之间为 AND
+	Conditions []Condition
+	// Orders 按顺序生效
+	Orders []Order
+	// Fields 返回的字段, 为空返回全部字段
+	Fields []string
+}
+
+func Eq(field string, value any) Condition {
+	return Condition{Field: field, Op: OpEq, Values: []any{value}}
+}
+
+func Ne(field string, value any) Condition {
+	return Condition{Field: field, Op: OpNe, Values: []any{value}}
+}
+
+func In(field string, values ...any) Condition {
+	return Condition{Field: field, Op: OpIn, Values: values}
+}
+
+// Like the pattern is passed as is, e.g. "%.com".
+func Like(field string, pattern string) Condition {
+	return Condition{Field: field, Op: OpLike, Values: []any{pattern}}
+}
+
+// Range is from <= field <= to, a nil bound is open.
+func Range(field string, from, to any) Condition {
+	return Condition{Field: field, Op: OpRange, Values: []any{from, to}}
+}
+
+func IsNull(field string) Condition {
+	return Condition{Field: field, Op: OpIsNull}
+}
+
+func NotNull(field string) Condition {
+	return Condition{Field: field, Op: OpNotNull}
+}
+
+func Asc(field string) Order {
+	return Order{Field: field}
+}
+
+func Desc(field string) Order {
+	return Order{Field: field, Desc: true}
+}
+
+var operators = map[protobuf.Filter_Operator]Operator{
+	protobuf.Filter_EQ:       OpEq,
+	protobuf.Filter_NE:       OpNe,
+	protobuf.Filter_IN:       OpIn,
+	protobuf.Filter_LIKE:     OpLike,
+	protobuf.Filter_RANGE:    OpRange,
+	protobuf.Filter_IS_NULL:  OpIsNull,
+	protobuf.Filter_NOT_NULL: OpNotNull,
+}
+
+// FromListQuery converts the protobuf.ListQuery bound by the handler,
+// the string values are converted to the field type when the query runs.
+// the pagination is passed to SelectListBy on its own, e.g. protobuf.PageWrap(req.GetPagination()).
+func FromListQuery(q *protobuf.ListQuery) *Query {
+	query := &Query{
+		Fields: q.GetFields(),
+	}
+	for _, f := range q.GetFilters() {
+		op, ok := operators[f.GetOp()]
+		if !ok {
+			op = Operator(f.GetOp().String())
+		}
+		values := make([]any, 0, len(f.GetValues()))
+		for _, v := range f.GetValues() {
+			if op == OpRange && v == "" {
+				values = append(values, nil)
+				continue
+			}
+			values = append(values, v)
+		}
+		query.Conditions = append(query.Conditions, Condition{Field: f.GetField(), Op: op, Values: values})
+	}
+	for _, o := range q.GetOrderBy() {
+		query.Orders = append(query.Orders, Order{Field: o.GetField(), Desc: o.GetDesc()})
+	}
+	return query
+}
+
+func invalidQuery(format string, args ...any) error {
+	return errors.New(int(ErrInvalidQuery.Code), ErrInvalidQuery.Reason, fmt.Sprintf(format, args...))
+}
+
+// Fields indexes the columns of a model by go field name, column name and json name.
+type Fields map[string]*schema.Field
+
+// NewFields indexes every readable column of s, for the writes of the service.
+func NewFields(s *schema.Schema) Fields {
+	fields := make(Fields)
+	for _, f := range s.Fields {
+		if f.DBName == "" || !f.Readable {
+			continue
+		}
+		fields[f.Name] = f
+		fields[f.DBName] = f
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+			fields[name] = f
+		}
+	}
+	return fields
+}
+
+// NewQueryFields the whitelist of Query, the fields tagged `json:"-"` are only accepted when they are in allowed.
+func NewQueryFields(s *schema.Schema, allowed ...string) Fields {
+	fields := NewFields(s)
+	narrowed := make(Fields)
+	if len(allowed) == 0 {
+		for key, f := range fields {
+			if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "-" {
+				narrowed[key] = f
+			}
+		}
+		return narrowed
+	}
+
+	for _, name := range allowed {
+		f, ok := fields[name]
+		if !ok {
+			continue
+		}
+		for key, v := range fields {
+			if v == f {
+				narrowed[key] = f
+			}
+		}
+	}
+	return narrowed
+}
+
+// Lookup returns the field of name, ErrInvalidQuery if it is unknown.
+func (fs Fields) Lookup(name string) (*schema.Field, error) {
+	f, ok := fs[name]
+	if !ok {
+		return nil, invalidQuery("unknown field %q", name)
+	}
+	return f, nil
+}
+
+func column(f *schema.Field) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: f.DBName}
+}
+
+// build validates q against the whitelist and returns the where, order by and select parts.
+func (q *Query) build(fs Fields) ([]clause.Expression, []clause.OrderByColumn, []string, error) {
+	var (
+		exprs   []clause.Expression
+		orders  []clause.OrderByColumn
+		columns []string
+	)
+
+	for _, c := range q.Conditions {
+		f, err := fs.Lookup(c.Field)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		expr, err := c.expr(f)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		exprs = append(exprs, expr...)
+	}
+
+	for _, o := range q.Orders {
+		f, err := fs.Lookup(o.Field)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		orders = append(orders, clause.OrderByColumn{Column: column(f), Desc: o.Desc})
+	}
+
+	for _, name := range q.Fields {
+		f, err := fs.Lookup(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		columns = append(columns, f.DBName)
+	}
+
+	return exprs, orders, columns, nil
+}
+
+// Resolve validates the operator and values of c, and converts the values to the type of f.
+// the values of OpRange may be nil for an open bound, OpLike has the pattern as is.
+func (c Condition) Resolve(f *schema.Field) ([]any, error) {
+	arity := func(n int) error {
+		if len(c.Values) != n {
+			return invalidQuery("%s on %q expects %d values, got %d", c.Op, c.Field, n, len(c.Values))
+		}
+		return nil
+	}
+
+	switch c.Op {
+	case OpEq, OpNe:
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+	case OpIn:
+		if len(c.Values) == 0 {
+			return nil, invalidQuery("in on %q expects at least 1 value", c.Field)
+		}
+	case OpLike:
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		if _, ok := c.Values[0].(string); !ok {
+			return nil, invalidQuery("like on %q expects a string pattern", c.Field)
+		}
+		return c.Values, nil
+	case OpRange:
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		if c.Values[0] == nil && c.Values[1] == nil {
+			return nil, invalidQuery("range on %q has no bound", c.Field)
+		}
+	case OpIsNull, OpNotNull:
+		if err := arity(0); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	default:
+		return nil, invalidQuery("unknown operator %q on %q", c.Op, c.Field)
+	}
+
+	values := make([]any, 0, len(c.Values))
+	for _, value := range c.Values {
+		if value == nil {
+			values = append(values, nil)
+			continue
+		}
+		v, err := convert(f, value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (c Condition) expr(f *schema.Field) ([]clause.Expression, error) {
+	values, err := c.Resolve(f)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Op {
+	case OpEq:
+		return []clause.Expression{clause.Eq{Column: column(f), Value: values[0]}}, nil
+	case OpNe:
+		return []clause.Expression{clause.Neq{Column: column(f), Value: values[0]}}, nil
+	case OpIn:
+		return []clause.Expression{clause.IN{Column: column(f), Values: values}}, nil
+	case OpLike:
+		return []clause.Expression{clause.Like{Column: column(f), Value: values[0]}}, nil
+	case OpRange:
+		var exprs []clause.Expression
+		if values[0] != nil {
+			exprs = append(exprs, clause.Gte{Column: column(f), Value: values[0]})
+		}
+		if values[1] != nil {
+			exprs = append(exprs, clause.Lte{Column: column(f), Value: values[1]})
+		}
+		return exprs, nil
+	default:
+		sql := "? IS NULL"
+		if c.Op == OpNotNull {
+			sql = "? IS NOT NULL"
+		}
+		return []clause.Expression{clause.Expr{SQL: sql, Vars: []any{column(f)}}}, nil
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// convert parses the string values, e.g. from protobuf.ListQuery, into the field type.
+func convert(f *schema.Field, value any) (any, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+
+	typ := f.FieldType
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	var (
+		v   any
+		err error
+	)
+	switch {
+	case typ.ConvertibleTo(timeType) && typ.Kind() == reflect.Struct:
+		v, err = time.Parse(time.RFC3339, s)
+	default:
+		switch typ.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v, err = strconv.ParseInt(s, 10, 64)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v, err = strconv.ParseUint(s, 10, 64)
+		case reflect.Float32, reflect.Float64:
+			v, err = strconv.ParseFloat(s, 64)
+		case reflect.Bool:
+			v, err = strconv.ParseBool(s)
+		default:
+			v = s
+		}
+	}
+	if err != nil {
+		return nil, invalidQuery("invalid value %q for %q", s, f.Name)
+	}
+	return v, nil
+}
@@ -0,0 +1,176 @@
+package crud_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+type Product struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Name      string    `gorm:"column:name;" json:"name"`
+	Price     int64     `gorm:"column:price;" json:"price"`
+	Remark    *string   `gorm:"column:remark;" json:"remark"`
+	CreatedAt time.Time `gorm:"column:created_at;" json:"created_at"`
+	Secret    string    `gorm:"column:secret;" json:"-"`
+}
+
+func newProductDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Product{}); err != nil {
+		t.Fatal(err)
+	}
+
+	remark := "hot"
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	products := []*Product{
+		{Name: "apple", Price: 10, Remark: &remark, CreatedAt: base},
+		{Name: "banana", Price: 20, CreatedAt: base.Add(24 * time.Hour)},
+		{Name: "cherry", Price: 30, CreatedAt: base.Add(48 * time.Hour)},
+		{Name: "pineapple", Price: 40, CreatedAt: base.Add(72 * time.Hour)},
+	}
+	if err := db.Create(products).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func names(list []*Product) []string {
+	var s []string
+	for _, p := range list {
+		s = append(s, p.Name)
+	}
+	return s
+}
+
+func TestCRUD_SelectListBy(t *testing.T) {
+	repo := crud.New[Product](newProductDB(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query *crud.Query
+		want  []string
+	}{
+		{"nil", nil, []string{"apple", "banana", "cherry", "pineapple"}},
+		{"eq", &crud.Query{Conditions: []crud.Condition{crud.Eq("name", "banana")}}, []string{"banana"}},
+		{"ne", &crud.Query{Conditions: []crud.Condition{crud.Ne("Name", "banana")}}, []string{"apple", "cherry", "pineapple"}},
+		{"in", &crud.Query{Conditions: []crud.Condition{crud.In("price", 10, 30)}}, []string{"apple", "cherry"}},
+		{"like", &crud.Query{Conditions: []crud.Condition{crud.Like("name", "%apple")}}, []string{"apple", "pineapple"}},
+		{"range", &crud.Query{Conditions: []crud.Condition{crud.Range("price", 20, 30)}}, []string{"banana", "cherry"}},
+		{"open range", &crud.Query{Conditions: []crud.Condition{crud.Range("price", nil, 20)}}, []string{"apple", "banana"}},
+		{"is null", &crud.Query{Conditions: []crud.Condition{crud.NotNull("remark")}}, []string{"apple"}},
+		{"and", &crud.Query{Conditions: []crud.Condition{crud.Like("name", "%apple"), crud.IsNull("remark")}}, []string{"pineapple"}},
+		{"order", &crud.Query{Orders: []crud.Order{crud.Desc("price")}}, []string{"pineapple", "cherry", "banana", "apple"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := repo.SelectListBy(ctx, nil, tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, names(list))
+		})
+	}
+}
+
+func TestCRUD_SelectListByPagination(t *testing.T) {
+	repo := crud.New[Product](newProductDB(t))
+
+	pagination := protobuf.PageWrap(&protobuf.Pagination{PageSize: 2, Current: 2})
+	list, err := repo.SelectListBy(context.Background(), pagination, &crud.Query{
+		Conditions: []crud.Condition{crud.Range("price", 20, nil)},
+		Orders:     []crud.Order{crud.Asc("price")},
+		Fields:     []string{"id", "name"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), pagination.Resp().Total)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "pineapple", list[0].Name)
+		// not selected.
+		assert.Equal(t, int64(0), list[0].Price)
+	}
+}
+
+func TestCRUD_SelectListByListQuery(t *testing.T) {
+	repo := crud.New[Product](newProductDB(t))
+
+	// as bound by a handler, every value is a string.
+	req := &protobuf.ListQuery{
+		Filters: []*protobuf.Filter{
+			{Field: "price", Op: protobuf.Filter_RANGE, Values: []string{"", "30"}},
+			{Field: "created_at", Op: protobuf.Filter_RANGE, Values: []string{"2024-01-02T00:00:00Z", ""}},
+			{Field: "id", Op: protobuf.Filter_IN, Values: []string{"2", "3", "4"}},
+		},
+		OrderBy: []*protobuf.QueryOrder{{Field: "name", Desc: true}},
+	}
+	list, err := repo.SelectListBy(context.Background(), nil, crud.FromListQuery(req))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cherry", "banana"}, names(list))
+}
+
+func TestCRUD_SelectListByHiddenField(t *testing.T) {
+	db := newProductDB(t)
+	ctx := context.Background()
+	query := &crud.Query{Conditions: []crud.Condition{crud.Eq("secret", "x")}}
+
+	// the fields tagged json:"-" are not accepted by default.
+	_, err := crud.New[Product](db).SelectListBy(ctx, nil, query)
+	assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+	_, err = crud.New[Product](db).SelectListBy(ctx, nil, &crud.Query{Fields: []string{"Secret"}})
+	assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+
+	// unless they are whitelisted.
+	_, err = crud.New[Product](db, crud.WithQueryFields("name", "secret")).SelectListBy(ctx, nil, query)
+	assert.NoError(t, err)
+
+	// the writes of the service still reach them.
+	repo := crud.New[Product](db)
+	n, err := repo.UpdateByIDs(ctx, []int64{1}, map[string]any{"secret": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, repo.UpdateFields(ctx, 1, &Product{Secret: "y"}, crud.Paths{"Secret"}))
+	got, err := repo.SelectOne(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "y", got.Secret)
+}
+
+func TestCRUD_SelectListByInvalid(t *testing.T) {
+	repo := crud.New[Product](newProductDB(t), crud.WithQueryFields("name", "price"))
+	ctx := context.Background()
+
+	queries := []*crud.Query{
+		{Conditions: []crud.Condition{crud.Eq("name = 1 OR 1", 1)}},
+		{Conditions: []crud.Condition{crud.Eq("remark", "hot")}},
+		{Conditions: []crud.Condition{crud.Eq("price", "ten")}},
+		{Conditions: []crud.Condition{crud.Range("price", nil, nil)}},
+		{Conditions: []crud.Condition{crud.In("price")}},
+		{Conditions: []crud.Condition{{Field: "price", Op: "between"}}},
+		{Orders: []crud.Order{crud.Asc("id")}},
+		{Fields: []string{"*"}},
+	}
+	for _, q := range queries {
+		_, err := repo.SelectListBy(ctx, nil, q)
+		assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+		assert.Equal(t, 400, kerrors.Code(err))
+	}
+
+	// the whitelist accepts the go field name and json name as well.
+	list, err := repo.SelectListBy(ctx, nil, &crud.Query{Conditions: []crud.Condition{crud.Eq("Price", 10)}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apple"}, names(list))
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-kratos/kratos/v2/errors"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+
+	kgin "github.com/omalloc/contrib/kratos/gin"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+// RegisterCRUD registers the endpoints of repo under path of the gin router, the errors are rendered by kgin.Error.
+func RegisterCRUD[T any](router gin.IRouter, path string, repo crud.CRUD[T], opts ...Option[T]) {
+	h := newHandler(path, repo, opts...)
+	g := router.Group(path)
+
+	if h.enabled(OperationList) {
+		g.GET("", func(c *gin.Context) {
+			h.serveGin(c, func(ctx context.Context) (any, error) {
+				return h.list(ctx, c.Request.URL.Query())
+			})
+		})
+	}
+	if h.enabled(OperationGet) {
+		g.GET("/:id", func(c *gin.Context) {
+			h.serveGin(c, func(ctx context.Context) (any, error) {
+				return h.get(ctx, c.Param("id"))
+			})
+		})
+	}
+	if h.enabled(OperationCreate) {
+		g.POST("", func(c *gin.Context) {
+			h.serveGin(c, func(ctx context.Context) (any, error) {
+				var t T
+				if err := decode(c.Request, &t); err != nil {
+					return nil, err
+				}
+				return h.create(ctx, &t)
+			})
+		})
+	}
+	if h.enabled(OperationUpdate) {
+		g.PUT("/:id", func(c *gin.Context) {
+			h.serveGin(c, func(ctx context.Context) (any, error) {
+				var t T
+				if err := decode(c.Request, &t); err != nil {
+					return nil, err
+				}
+				return h.update(ctx, c.Param("id"), &t)
+			})
+		})
+	}
+	if h.enabled(OperationDelete) {
+		g.DELETE("/:id", func(c *gin.Context) {
+			h.serveGin(c, func(ctx context.Context) (any, error) {
+				return h.delete(ctx, c.Param("id"))
+			})
+		})
+	}
+}
+
+func (h *handler[T]) serveGin(c *gin.Context, fn func(ctx context.Context) (any, error)) {
+	out, err := fn(c.Request.Context())
+	if err != nil {
+		kgin.Error(c, err)
+		return
+	}
+
+	codec, _ := khttp.CodecForRequest(c.Request, "Accept")
+	body, err := codec.Marshal(out)
+	if err != nil {
+		kgin.Error(c, err)
+		return
+	}
+	c.Data(http.StatusOK, kgin.ContentType(codec.Name()), body)
+}
+
+// decode the body with the codec of Content-Type, as kratos http does.
+func decode(r *http.Request, v any) error {
+	codec, _ := khttp.CodecForRequest(r, "Content-Type")
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errors.BadRequest("CODEC", err.Error())
+	}
+	if len(data) == 0 {
+		return errors.BadRequest("CODEC", "empty body")
+	}
+	if err := codec.Unmarshal(data, v); err != nil {
+		return errors.BadRequest("CODEC", err.Error())
+	}
+	return nil
+}
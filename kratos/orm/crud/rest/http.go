@@ -0,0 +1,73 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+
+	"github.com/omalloc/contrib/kratos/orm/crud"
+)
+
+// RegisterHTTPCRUD registers the endpoints of repo under path of the kratos http server,
+// the server middlewares run with the operation "{path}/{list|get|create|update|delete}".
+func RegisterHTTPCRUD[T any](srv *khttp.Server, path string, repo crud.CRUD[T], opts ...Option[T]) {
+	h := newHandler(path, repo, opts...)
+	r := srv.Route(path)
+
+	if h.enabled(OperationList) {
+		r.GET("/", func(ctx khttp.Context) error {
+			return h.serveHTTP(ctx, OperationList, func(c context.Context) (any, error) {
+				return h.list(c, ctx.Request().URL.Query())
+			})
+		})
+	}
+	if h.enabled(OperationGet) {
+		r.GET("/{id}", func(ctx khttp.Context) error {
+			return h.serveHTTP(ctx, OperationGet, func(c context.Context) (any, error) {
+				return h.get(c, ctx.Vars().Get("id"))
+			})
+		})
+	}
+	if h.enabled(OperationCreate) {
+		r.POST("/", func(ctx khttp.Context) error {
+			var t T
+			if err := ctx.Bind(&t); err != nil {
+				return err
+			}
+			return h.serveHTTP(ctx, OperationCreate, func(c context.Context) (any, error) {
+				return h.create(c, &t)
+			})
+		})
+	}
+	if h.enabled(OperationUpdate) {
+		r.PUT("/{id}", func(ctx khttp.Context) error {
+			var t T
+			if err := ctx.Bind(&t); err != nil {
+				return err
+			}
+			return h.serveHTTP(ctx, OperationUpdate, func(c context.Context) (any, error) {
+				return h.update(c, ctx.Vars().Get("id"), &t)
+			})
+		})
+	}
+	if h.enabled(OperationDelete) {
+		r.DELETE("/{id}", func(ctx khttp.Context) error {
+			return h.serveHTTP(ctx, OperationDelete, func(c context.Context) (any, error) {
+				return h.delete(c, ctx.Vars().Get("id"))
+			})
+		})
+	}
+}
+
+func (h *handler[T]) serveHTTP(ctx khttp.Context, op Operation, fn func(ctx context.Context) (any, error)) error {
+	khttp.SetOperation(ctx, h.operation(op))
+	m := ctx.Middleware(func(c context.Context, _ any) (any, error) {
+		return fn(c)
+	})
+	out, err := m(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return ctx.Result(http.StatusOK, out)
+}
@@ -0,0 +1,283 @@
+// Package rest exposes a crud.CRUD as list / get / create / update / delete endpoints on gin and kratos http.
+//
+//	GET    {path}?current=1&page_size=20
+//	GET    {path}/{id}
+//	POST   {path}
+//	PUT    {path}/{id}
+//	DELETE {path}/{id}
+//
+// the request and response bodies are negotiated with the kratos codecs by Content-Type and Accept.
+//
+// the primary key, the create / update / delete times and the version are maintained by the server:
+// create clears them from the body, update only writes the other columns, the version of the body
+// is only checked against the stored one.
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	ormerrors "github.com/omalloc/contrib/kratos/orm/errors"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+// Operation of an endpoint.
+type Operation string
+
+const (
+	OperationList   Operation = "list"
+	OperationGet    Operation = "get"
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+var (
+	ErrInvalidID = errors.BadRequest("INVALID_ID", "invalid id")
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+type options[T any] struct {
+	operations map[Operation]bool
+	authorize  func(ctx context.Context, op Operation) error
+	validate   func(ctx context.Context, op Operation, t *T) error
+	filter     func(ctx context.Context, t *T) any
+}
+
+type Option[T any] func(*options[T])
+
+// WithOperations only registers ops, default is all of them.
+func WithOperations[T any](ops ...Operation) Option[T] {
+	return func(o *options[T]) {
+		o.operations = make(map[Operation]bool, len(ops))
+		for _, op := range ops {
+			o.operations[op] = true
+		}
+	}
+}
+
+// WithAuthorize is called before every operation, e.g. returns errors.Forbidden.
+func WithAuthorize[T any](fn func(ctx context.Context, op Operation) error) Option[T] {
+	return func(o *options[T]) {
+		o.authorize = fn
+	}
+}
+
+// WithValidate is called with the decoded body of create and update, e.g. returns errors.BadRequest.
+func WithValidate[T any](fn func(ctx context.Context, op Operation, t *T) error) Option[T] {
+	return func(o *options[T]) {
+		o.validate = fn
+	}
+}
+
+// WithFilter converts the entities before they are encoded, e.g. to hide fields.
+func WithFilter[T any](fn func(ctx context.Context, t *T) any) Option[T] {
+	return func(o *options[T]) {
+		o.filter = fn
+	}
+}
+
+// ListReply is the response of list.
+type ListReply struct {
+	Items      []any                `json:"items"`
+	Pagination *protobuf.Pagination `json:"pagination"`
+}
+
+type handler[T any] struct {
+	path string
+	repo crud.CRUD[T]
+	opts options[T]
+
+	// pk is the primary key field of T, set from the path on update.
+	pk    *schema.Field
+	pkErr error
+	// managed the fields maintained by the server, cleared from the body on create.
+	managed []*schema.Field
+	// writable the columns written by update, every updatable column but the managed and `json:"-"` ones.
+	writable crud.Paths
+}
+
+func newHandler[T any](path string, repo crud.CRUD[T], opts ...Option[T]) *handler[T] {
+	h := &handler[T]{path: path, repo: repo}
+	for _, opt := range opts {
+		opt(&h.opts)
+	}
+
+	s, err := schema.Parse(new(T), &sync.Map{}, schema.NamingStrategy{})
+	if err == nil && s.PrioritizedPrimaryField == nil {
+		err = fmt.Errorf("rest: %s has no primary key", s.Name)
+	}
+	if err != nil {
+		h.pkErr = err
+		return h
+	}
+
+	h.pk = s.PrioritizedPrimaryField
+	_, versioned := any(new(T)).(orm.Versioned)
+	for _, f := range s.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		if f.PrimaryKey || f.AutoCreateTime > 0 || f.AutoUpdateTime > 0 || f.FieldType == deletedAtType ||
+			versioned && f.DBName == "version" {
+			h.managed = append(h.managed, f)
+			continue
+		}
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); f.Updatable && name != "-" {
+			h.writable = append(h.writable, f.DBName)
+		}
+	}
+	return h
+}
+
+func (h *handler[T]) enabled(op Operation) bool {
+	return h.opts.operations == nil || h.opts.operations[op]
+}
+
+func (h *handler[T]) operation(op Operation) string {
+	return h.path + "/" + string(op)
+}
+
+func (h *handler[T]) authorize(ctx context.Context, op Operation) error {
+	if h.opts.authorize == nil {
+		return nil
+	}
+	return h.opts.authorize(ctx, op)
+}
+
+func (h *handler[T]) validate(ctx context.Context, op Operation, t *T) error {
+	if h.opts.validate == nil {
+		return nil
+	}
+	return h.opts.validate(ctx, op, t)
+}
+
+func (h *handler[T]) filter(ctx context.Context, t *T) any {
+	if h.opts.filter == nil {
+		return t
+	}
+	return h.opts.filter(ctx, t)
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidID
+	}
+	return id, nil
+}
+
+// pagination reads current and page_size of the query.
+func pagination(query url.Values) *protobuf.Pagination {
+	current, _ := strconv.ParseInt(query.Get("current"), 10, 32)
+	pageSize, _ := strconv.ParseInt(query.Get("page_size"), 10, 32)
+	return protobuf.PageWrap(&protobuf.Pagination{Current: int32(current), PageSize: int32(pageSize)})
+}
+
+func (h *handler[T]) list(ctx context.Context, query url.Values) (any, error) {
+	if err := h.authorize(ctx, OperationList); err != nil {
+		return nil, err
+	}
+	p := pagination(query)
+	list, err := h.repo.SelectList(ctx, p)
+	if err != nil {
+		return nil, ormerrors.Translate(err)
+	}
+	items := make([]any, 0, len(list))
+	for _, t := range list {
+		items = append(items, h.filter(ctx, t))
+	}
+	return &ListReply{Items: items, Pagination: p.Resp()}, nil
+}
+
+func (h *handler[T]) get(ctx context.Context, id string) (any, error) {
+	if err := h.authorize(ctx, OperationGet); err != nil {
+		return nil, err
+	}
+	i, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	t, err := h.repo.SelectOne(ctx, i)
+	if err != nil {
+		return nil, ormerrors.Translate(err)
+	}
+	return h.filter(ctx, t), nil
+}
+
+func (h *handler[T]) create(ctx context.Context, t *T) (any, error) {
+	if err := h.authorize(ctx, OperationCreate); err != nil {
+		return nil, err
+	}
+	if err := h.validate(ctx, OperationCreate, t); err != nil {
+		return nil, err
+	}
+	if h.pkErr != nil {
+		return nil, h.pkErr
+	}
+	rv := reflect.ValueOf(t).Elem()
+	for _, f := range h.managed {
+		if err := f.Set(ctx, rv, reflect.Zero(f.FieldType).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.repo.Create(ctx, t); err != nil {
+		return nil, ormerrors.Translate(err)
+	}
+	return h.filter(ctx, t), nil
+}
+
+func (h *handler[T]) update(ctx context.Context, id string, t *T) (any, error) {
+	if err := h.authorize(ctx, OperationUpdate); err != nil {
+		return nil, err
+	}
+	i, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.validate(ctx, OperationUpdate, t); err != nil {
+		return nil, err
+	}
+	// the id of the path wins over the body.
+	if h.pkErr != nil {
+		return nil, h.pkErr
+	}
+	if err := h.pk.Set(ctx, reflect.ValueOf(t).Elem(), i); err != nil {
+		return nil, err
+	}
+	if err := h.repo.UpdateFields(ctx, i, t, h.writable); err != nil {
+		return nil, ormerrors.Translate(err)
+	}
+	// the managed fields of the body are not written, the stored row is returned.
+	stored, err := h.repo.SelectOne(ctx, i)
+	if err != nil {
+		return nil, ormerrors.Translate(err)
+	}
+	return h.filter(ctx, stored), nil
+}
+
+func (h *handler[T]) delete(ctx context.Context, id string) (any, error) {
+	if err := h.authorize(ctx, OperationDelete); err != nil {
+		return nil, err
+	}
+	i, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.repo.Delete(ctx, i); err != nil {
+		return nil, ormerrors.Translate(err)
+	}
+	return struct{}{}, nil
+}
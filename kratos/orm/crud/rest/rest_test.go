@@ -0,0 +1,203 @@
+package rest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-kratos/kratos/v2/errors"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/kratos/orm/crud/rest"
+)
+
+type Domain struct {
+	ID     int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Name   string `gorm:"column:name;" json:"name"`
+	Secret string `gorm:"column:secret;" json:"secret,omitempty"`
+}
+
+func newRepo(t *testing.T, name string) crud.CRUD[Domain] {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + name + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Domain{}); err != nil {
+		t.Fatal(err)
+	}
+	return crud.New[Domain](db)
+}
+
+func options() []rest.Option[Domain] {
+	return []rest.Option[Domain]{
+		rest.WithAuthorize[Domain](func(ctx context.Context, op rest.Operation) error {
+			if op == rest.OperationDelete {
+				return errors.Forbidden("FORBIDDEN", "delete is not allowed")
+			}
+			return nil
+		}),
+		rest.WithValidate(func(ctx context.Context, op rest.Operation, d *Domain) error {
+			if d.Name == "" {
+				return errors.BadRequest("INVALID_NAME", "name is required")
+			}
+			return nil
+		}),
+		rest.WithFilter(func(ctx context.Context, d *Domain) any {
+			v := *d
+			v.Secret = ""
+			return &v
+		}),
+	}
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) (int, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var v map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &v)
+	return rec.Code, v
+}
+
+func testEndpoints(t *testing.T, h http.Handler) {
+	code, v := do(t, h, http.MethodPost, "/domains", `{"name":"a.com","secret":"s"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "a.com", v["name"])
+	assert.Nil(t, v["secret"])
+
+	code, v = do(t, h, http.MethodPost, "/domains", `{"secret":"s"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "INVALID_NAME", v["reason"])
+
+	code, _ = do(t, h, http.MethodPost, "/domains", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, v = do(t, h, http.MethodGet, "/domains/1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "a.com", v["name"])
+
+	code, v = do(t, h, http.MethodGet, "/domains/100", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "RECORD_NOT_FOUND", v["reason"])
+
+	code, v = do(t, h, http.MethodGet, "/domains/abc", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "INVALID_ID", v["reason"])
+
+	// the id of the path is used.
+	code, v = do(t, h, http.MethodPut, "/domains/1", `{"id":2,"name":"b.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), v["id"])
+
+	code, v = do(t, h, http.MethodPut, "/domains/100", `{"name":"c.com"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "RECORD_NOT_FOUND", v["reason"])
+
+	code, v = do(t, h, http.MethodGet, "/domains?current=1&page_size=10", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, v["items"], 1)
+	assert.Equal(t, float64(1), v["pagination"].(map[string]any)["total"])
+	assert.Equal(t, "b.com", v["items"].([]any)[0].(map[string]any)["name"])
+
+	code, v = do(t, h, http.MethodDelete, "/domains/1", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "FORBIDDEN", v["reason"])
+}
+
+func TestRegisterCRUD(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	rest.RegisterCRUD(r, "/domains", newRepo(t, t.Name()), options()...)
+
+	testEndpoints(t, r)
+}
+
+func TestRegisterHTTPCRUD(t *testing.T) {
+	srv := khttp.NewServer()
+	rest.RegisterHTTPCRUD(srv, "/domains", newRepo(t, t.Name()), options()...)
+
+	testEndpoints(t, srv)
+}
+
+func TestWithOperations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	rest.RegisterCRUD(r, "/domains", newRepo(t, t.Name()),
+		rest.WithOperations[Domain](rest.OperationList, rest.OperationGet))
+
+	code, _ := do(t, r, http.MethodGet, "/domains", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = do(t, r, http.MethodPost, "/domains", `{"name":"a.com"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+type Post struct {
+	ID    int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Title string `gorm:"column:title;" json:"title"`
+	Token string `gorm:"column:token;" json:"-"`
+	orm.VersionedDBModel
+}
+
+func TestManagedFields(t *testing.T) {
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Post{}); err != nil {
+		t.Fatal(err)
+	}
+	repo := crud.New[Post](db)
+	ctx := context.Background()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	rest.RegisterCRUD(r, "/posts", repo)
+
+	// the id, the times and the version of the body are ignored on create.
+	code, v := do(t, r, http.MethodPost, "/posts",
+		`{"id":100,"title":"a","created_at":"2000-01-01T00:00:00Z","updated_at":"2000-01-01T00:00:00Z","version":7}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), v["id"])
+	assert.Equal(t, float64(1), v["version"])
+	assert.NotEqual(t, "2000-01-01T00:00:00Z", v["created_at"])
+
+	// the json:"-" columns are kept by update.
+	assert.NoError(t, db.Model(&Post{}).Where("id = ?", 1).Update("token", "secret").Error)
+	stored, err := repo.SelectOne(ctx, 1)
+	assert.NoError(t, err)
+
+	// the created time of the body is not written, the version is checked.
+	code, v = do(t, r, http.MethodPut, "/posts/1", `{"title":"b","created_at":"2000-01-01T00:00:00Z","version":1}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "b", v["title"])
+	assert.Equal(t, float64(2), v["version"])
+
+	got, err := repo.SelectOne(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got.Title)
+	assert.Equal(t, "secret", got.Token)
+	assert.True(t, got.CreatedAt.Equal(stored.CreatedAt))
+
+	code, v = do(t, r, http.MethodPut, "/posts/1", `{"title":"c","version":1}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "STALE_OBJECT", v["reason"])
+}
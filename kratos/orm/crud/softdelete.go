@@ -0,0 +1,117 @@
+package crud
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/omalloc/contrib/protobuf"
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// deletedAt returns the gorm.DeletedAt field of the model, e.g. the one of orm.DBModel.
+func (r *crud[T, ID]) deletedAt(ctx context.Context) (*schema.Field, error) {
+	if err := r.parse(ctx); err != nil {
+		return nil, err
+	}
+	for _, f := range r.schema.Fields {
+		if f.FieldType == deletedAtType && f.DBName != "" {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("crud: %s has no gorm.DeletedAt field for soft delete", r.schema.Name)
+}
+
+func (r *crud[T, ID]) SelectListWithDeleted(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
+	return r.selectListBy(ctx, r.db.WithContext(ctx).Unscoped(), pagination, query)
+}
+
+func (r *crud[T, ID]) SelectDeleted(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
+	f, err := r.deletedAt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx := r.db.WithContext(ctx).
+		Unscoped().
+		Where(clause.Expr{SQL: "? IS NOT NULL", Vars: []any{column(f)}})
+	return r.selectListBy(ctx, tx, pagination, query)
+}
+
+func (r *crud[T, ID]) Restore(ctx context.Context, id ID) error {
+	f, err := r.deletedAt(ctx)
+	if err != nil {
+		return err
+	}
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result := r.db.WithContext(ctx).
+		Unscoped().
+		Model(new(T)).
+		Where(where).
+		Where(clause.Expr{SQL: "? IS NOT NULL", Vars: []any{column(f)}}).
+		Update(f.DBName, nil)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+func (r *crud[T, ID]) ForceDelete(ctx context.Context, id ID) error {
+	where, err := r.where(ctx, id)
+	if err != nil {
+		return err
+	}
+	return r.db.WithContext(ctx).
+		Unscoped().
+		Where(where).
+		Delete(new(T)).Error
+}
+
+// PurgeDeleted selects the primary keys of a batch and deletes them, DELETE ... LIMIT is not portable.
+// every batch is a statement of its own, unless ctx carries a transaction.
+func (r *crud[T, ID]) PurgeDeleted(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	f, err := r.deletedAt(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	var purged int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+
+		var batch []*T
+		err := r.db.WithContext(ctx).
+			Unscoped().
+			Model(new(T)).
+			Select(r.schema.PrimaryFieldDBNames).
+			Where(clause.Lt{Column: column(f), Value: before}).
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return purged, err
+		}
+
+		// deleted by the primary keys of the batch.
+		result := r.db.WithContext(ctx).
+			Unscoped().
+			Delete(&batch)
+		purged += result.RowsAffected
+		if result.Error != nil || len(batch) < batchSize || result.RowsAffected == 0 {
+			return purged, result.Error
+		}
+	}
+}
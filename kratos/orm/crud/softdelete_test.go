@@ -0,0 +1,141 @@
+package crud_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+type Article struct {
+	ID    int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Title string `gorm:"column:title;"`
+	orm.DBModel
+}
+
+func newArticleDB(t *testing.T, n int) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Article{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		if err := db.Create(&Article{Title: fmt.Sprintf("a%02d", i)}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func articleIDs(list []*Article) []int64 {
+	ids := make([]int64, 0, len(list))
+	for _, a := range list {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestCRUD_SoftDelete(t *testing.T) {
+	db := newArticleDB(t, 5)
+	repo := crud.New[Article](db)
+	ctx := context.Background()
+
+	_, err := repo.DeleteByIDs(ctx, []int64{1, 2})
+	assert.NoError(t, err)
+
+	list, err := repo.SelectDeleted(ctx, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, articleIDs(list))
+
+	pagination := protobuf.PageWrap(&protobuf.Pagination{PageSize: 2})
+	list, err = repo.SelectListWithDeleted(ctx, pagination, &crud.Query{Orders: []crud.Order{crud.Desc("id")}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5, 4}, articleIDs(list))
+	assert.Equal(t, int32(5), pagination.Resp().Total)
+
+	list, err = repo.SelectDeleted(ctx, nil, &crud.Query{Conditions: []crud.Condition{crud.Eq("title", "a02")}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, articleIDs(list))
+
+	assert.NoError(t, repo.Restore(ctx, 1))
+	a, err := repo.SelectOne(ctx, 1)
+	assert.NoError(t, err)
+	assert.False(t, a.DeletedAt.Valid)
+	// not deleted.
+	assert.ErrorIs(t, repo.Restore(ctx, 1), gorm.ErrRecordNotFound)
+	assert.ErrorIs(t, repo.Restore(ctx, 100), gorm.ErrRecordNotFound)
+
+	assert.NoError(t, repo.ForceDelete(ctx, 2))
+	assert.NoError(t, repo.ForceDelete(ctx, 3))
+	list, err = repo.SelectListWithDeleted(ctx, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 4, 5}, articleIDs(list))
+
+	// without gorm.DeletedAt.
+	_, err = crud.New[Sku](newSkuDB(t)).SelectDeleted(ctx, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestCRUD_PurgeDeleted(t *testing.T) {
+	db := newArticleDB(t, 10)
+	repo := crud.New[Article](db)
+	ctx := context.Background()
+
+	_, err := repo.DeleteByIDs(ctx, []int64{1, 2, 3, 4, 5, 6, 7, 8})
+	assert.NoError(t, err)
+	// 1..7 deleted 40 days ago.
+	err = db.Unscoped().Model(&Article{}).Where("id < ?", 8).
+		Update("deleted_at", time.Now().AddDate(0, 0, -40)).Error
+	assert.NoError(t, err)
+
+	n, err := repo.PurgeDeleted(ctx, time.Now().AddDate(0, 0, -30), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+
+	list, err := repo.SelectListWithDeleted(ctx, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{8, 9, 10}, articleIDs(list))
+
+	n, err = repo.PurgeDeleted(ctx, time.Now().AddDate(0, 0, -30), 3)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestPurger(t *testing.T) {
+	db := newArticleDB(t, 3)
+	repo := crud.New[Article](db)
+	ctx := context.Background()
+
+	_, err := repo.DeleteByIDs(ctx, []int64{1, 2})
+	assert.NoError(t, err)
+
+	purger := crud.NewPurger(repo, 0, crud.WithPurgeInterval(10*time.Millisecond))
+	go func() {
+		_ = purger.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		list, err := repo.SelectListWithDeleted(ctx, nil, nil)
+		return err == nil && len(list) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, purger.Start(ctx), crud.ErrPurgerStarted)
+	assert.NoError(t, purger.Stop(ctx))
+
+	// a purger stopped before it is started returns at once, the zero interval keeps the default.
+	purger = crud.NewPurger(repo, 0, crud.WithPurgeInterval(0))
+	assert.NoError(t, purger.Stop(ctx))
+	assert.NoError(t, purger.Start(ctx))
+}
@@ -0,0 +1,123 @@
+package crud
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	ormerrors "github.com/omalloc/contrib/kratos/orm/errors"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+// WithErrorTranslation returns the kratos errors of orm/errors instead of the gorm and driver errors,
+// e.g. NotFound for gorm.ErrRecordNotFound and Conflict for a duplicate key.
+func WithErrorTranslation() Option {
+	return func(o *options) {
+		o.translate = true
+	}
+}
+
+// translated translates the errors of every method of the repository.
+type translated[T any, ID any] struct {
+	r *crud[T, ID]
+}
+
+func (t *translated[T, ID]) error(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var opts []ormerrors.Option
+	if t.r.parse(ctx) == nil {
+		opts = append(opts, ormerrors.WithTable(t.r.schema.Table))
+	}
+	return ormerrors.Translate(err, opts...)
+}
+
+func (t *translated[T, ID]) Create(ctx context.Context, v *T) error {
+	return t.error(ctx, t.r.Create(ctx, v))
+}
+
+func (t *translated[T, ID]) Update(ctx context.Context, id ID, v *T) error {
+	return t.error(ctx, t.r.Update(ctx, id, v))
+}
+
+func (t *translated[T, ID]) Delete(ctx context.Context, id ID) error {
+	return t.error(ctx, t.r.Delete(ctx, id))
+}
+
+func (t *translated[T, ID]) SelectList(ctx context.Context, pagination *protobuf.Pagination) ([]*T, error) {
+	list, err := t.r.SelectList(ctx, pagination)
+	return list, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) SelectListBy(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
+	list, err := t.r.SelectListBy(ctx, pagination, query)
+	return list, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) SelectListByCursor(ctx context.Context, pagination *protobuf.CursorPagination, query *Query) ([]*T, error) {
+	list, err := t.r.SelectListByCursor(ctx, pagination, query)
+	return list, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) SelectOne(ctx context.Context, id ID) (*T, error) {
+	v, err := t.r.SelectOne(ctx, id)
+	return v, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) CreateBatch(ctx context.Context, list []*T) (int64, error) {
+	n, err := t.r.CreateBatch(ctx, list)
+	return n, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) Upsert(ctx context.Context, list []*T, opts ...UpsertOption) (int64, error) {
+	n, err := t.r.Upsert(ctx, list, opts...)
+	return n, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) DeleteByIDs(ctx context.Context, ids []ID) (int64, error) {
+	n, err := t.r.DeleteByIDs(ctx, ids)
+	return n, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) UpdateByIDs(ctx context.Context, ids []ID, columns map[string]any) (int64, error) {
+	n, err := t.r.UpdateByIDs(ctx, ids, columns)
+	return n, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) UpdateFields(ctx context.Context, id ID, v *T, mask FieldMask) error {
+	return t.error(ctx, t.r.UpdateFields(ctx, id, v, mask))
+}
+
+func (t *translated[T, ID]) Each(ctx context.Context, query *Query, batchSize int) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		for v, err := range t.r.Each(ctx, query, batchSize) {
+			if !yield(v, t.error(ctx, err)) {
+				return
+			}
+		}
+	}
+}
+
+func (t *translated[T, ID]) SelectListWithDeleted(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
+	list, err := t.r.SelectListWithDeleted(ctx, pagination, query)
+	return list, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) SelectDeleted(ctx context.Context, pagination *protobuf.Pagination, query *Query) ([]*T, error) {
+	list, err := t.r.SelectDeleted(ctx, pagination, query)
+	return list, t.error(ctx, err)
+}
+
+func (t *translated[T, ID]) Restore(ctx context.Context, id ID) error {
+	return t.error(ctx, t.r.Restore(ctx, id))
+}
+
+func (t *translated[T, ID]) ForceDelete(ctx context.Context, id ID) error {
+	return t.error(ctx, t.r.ForceDelete(ctx, id))
+}
+
+func (t *translated[T, ID]) PurgeDeleted(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	n, err := t.r.PurgeDeleted(ctx, before, batchSize)
+	return n, t.error(ctx, err)
+}
@@ -0,0 +1,34 @@
+package crud_test
+
+import (
+	"context"
+	"testing"
+
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	ormerrors "github.com/omalloc/contrib/kratos/orm/errors"
+)
+
+func TestCRUD_WithErrorTranslation(t *testing.T) {
+	repo := crud.New[Sku](newSkuDB(t), crud.WithErrorTranslation())
+	ctx := context.Background()
+
+	assert.NoError(t, repo.Create(ctx, &Sku{Code: "a"}))
+
+	err := repo.Create(ctx, &Sku{Code: "a"})
+	assert.True(t, kerrors.IsConflict(err))
+	assert.Equal(t, ormerrors.ReasonDuplicateKey, kerrors.Reason(err))
+	assert.Equal(t, "skus", kerrors.FromError(err).Metadata["table"])
+
+	_, err = repo.Upsert(ctx, []*Sku{{Code: "a"}}, crud.OnConflict("unknown"))
+	assert.ErrorIs(t, err, crud.ErrInvalidQuery)
+
+	_, err = repo.SelectOne(ctx, 100)
+	assert.True(t, kerrors.IsNotFound(err))
+	assert.Equal(t, ormerrors.ReasonNotFound, kerrors.Reason(err))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Equal(t, "skus", kerrors.FromError(err).Metadata["table"])
+}
@@ -0,0 +1,360 @@
+package encrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// SerializerName the serializer name of the encrypted fields.
+	SerializerName = "encrypted"
+	// deterministicTag the tag setting of the deterministic fields.
+	deterministicTag = "DETERMINISTIC"
+
+	modeEnvelope      = "e1"
+	modeDeterministic = "d1"
+
+	nonceSize = 12
+	dekSize   = 32
+)
+
+var ErrInvalidCiphertext = errors.New("encrypt: invalid ciphertext")
+
+// Serializer encrypts the fields tagged `gorm:"serializer:encrypted"` with AES-GCM.
+//
+// every value is encrypted with a random data key, the data key is encrypted with the current key of the KeyProvider,
+// and stored along with the key id: $e1$<key id>$<base64>, so the values encrypted with an old key still decrypt.
+//
+// the fields tagged `gorm:"serializer:encrypted;deterministic"` are encrypted with a nonce derived from the value:
+// $d1$<key id>$<base64>, the same value gives the same ciphertext, so they can be looked up by equality.
+// deterministic values leak the equality of the rows, use them for lookup columns only.
+// the key id is part of the ciphertext, after a rotation the equality lookups miss the rows
+// not re-encrypted by Reencrypt yet, look them up with the ciphertexts of Lookup meanwhile.
+//
+// the ciphertext is bound to the column name, a value copied to another column does not decrypt.
+// string and []byte fields are encrypted as is, the other types as JSON.
+type Serializer struct {
+	provider  KeyProvider
+	plaintext bool
+}
+
+type Option func(*Serializer)
+
+// WithPlaintextFallback reads the values not encrypted yet as plaintext, so the plain columns can be migrated
+// with Reencrypt.
+func WithPlaintextFallback() Option {
+	return func(s *Serializer) {
+		s.plaintext = true
+	}
+}
+
+func New(provider KeyProvider, opts ...Option) *Serializer {
+	s := &Serializer{provider: provider}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register registers the encrypted serializer of provider, must be called before the models are parsed.
+func Register(provider KeyProvider, opts ...Option) *Serializer {
+	s := New(provider, opts...)
+	schema.RegisterSerializer(SerializerName, s)
+	return s
+}
+
+// Scan implements schema.SerializerInterface.
+func (s *Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+
+	var ciphertext string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		ciphertext = string(v)
+	case string:
+		ciphertext = v
+	default:
+		return fmt.Errorf("%w: unsupported type %T of %s", ErrInvalidCiphertext, dbValue, field.DBName)
+	}
+
+	if ciphertext != "" {
+		plaintext, err := s.Decrypt(ctx, ciphertext, field.DBName)
+		if err != nil {
+			return err
+		}
+		if err := setPlaintext(fieldValue.Elem(), plaintext); err != nil {
+			return err
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+// Value implements schema.SerializerValuerInterface.
+func (s *Serializer) Value(ctx context.Context, field *schema.Field, _ reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, null, err := plaintextOf(fieldValue)
+	if err != nil || null {
+		return nil, err
+	}
+	_, deterministic := field.TagSettings[deterministicTag]
+	return s.Encrypt(ctx, plaintext, field.DBName, deterministic)
+}
+
+// Encrypt encrypts plaintext of column with the current key.
+func (s *Serializer) Encrypt(ctx context.Context, plaintext []byte, column string, deterministic bool) (string, error) {
+	id, err := s.provider.Current(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.encrypt(ctx, id, plaintext, column, deterministic)
+}
+
+// Lookup returns the deterministic ciphertexts of value in column with the current key and the old key ids,
+// for an IN lookup of the rows not migrated by Reencrypt yet:
+//
+//	tokens, err := s.Lookup(ctx, "tok-1", "token", "2024-01")
+//	db.Where("token IN ?", tokens).First(&c)
+func (s *Serializer) Lookup(ctx context.Context, value interface{}, column string, ids ...string) ([]string, error) {
+	plaintext, null, err := plaintextOf(value)
+	if err != nil || null {
+		return nil, err
+	}
+	current, err := s.provider.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(ids)+1)
+	for i, id := range append([]string{current}, ids...) {
+		if i > 0 && id == current {
+			continue
+		}
+		ciphertext, err := s.encrypt(ctx, id, plaintext, column, true)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ciphertext)
+	}
+	return list, nil
+}
+
+// encrypt encrypts plaintext of column with the key id.
+func (s *Serializer) encrypt(ctx context.Context, id string, plaintext []byte, column string, deterministic bool) (string, error) {
+	kek, err := s.provider.Key(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		mode = modeEnvelope
+		raw  []byte
+	)
+	if deterministic {
+		mode = modeDeterministic
+		raw, err = sealDeterministic(kek, plaintext, []byte(column))
+	} else {
+		raw, err = sealEnvelope(kek, plaintext, []byte(column))
+	}
+	if err != nil {
+		return "", err
+	}
+	return "$" + mode + "$" + id + "$" + base64.RawStdEncoding.EncodeToString(raw), nil
+}
+
+// Decrypt decrypts ciphertext of column with the key it was encrypted with.
+func (s *Serializer) Decrypt(ctx context.Context, ciphertext, column string) ([]byte, error) {
+	mode, id, raw, err := parse(ciphertext)
+	if err != nil {
+		if s.plaintext {
+			return []byte(ciphertext), nil
+		}
+		return nil, err
+	}
+	kek, err := s.provider.Key(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == modeDeterministic {
+		return openDeterministic(kek, raw, []byte(column))
+	}
+	return openEnvelope(kek, raw, []byte(column))
+}
+
+// KeyID returns the id of the key ciphertext was encrypted with, false if it is not encrypted.
+func KeyID(ciphertext string) (string, bool) {
+	_, id, _, err := parse(ciphertext)
+	return id, err == nil
+}
+
+func parse(ciphertext string) (mode, id string, raw []byte, err error) {
+	parts := strings.SplitN(ciphertext, "$", 4)
+	if len(parts) != 4 || parts[0] != "" || (parts[1] != modeEnvelope && parts[1] != modeDeterministic) || parts[2] == "" {
+		return "", "", nil, ErrInvalidCiphertext
+	}
+	raw, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return parts[1], parts[2], raw, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealEnvelope returns nonce | sealed data key | nonce | sealed plaintext.
+func sealEnvelope(kek, plaintext, aad []byte) ([]byte, error) {
+	dek := make([]byte, dekSize)
+	nonces := make([]byte, 2*nonceSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonces); err != nil {
+		return nil, err
+	}
+
+	keyGCM, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	dataGCM, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := append([]byte(nil), nonces[:nonceSize]...)
+	raw = keyGCM.Seal(raw, nonces[:nonceSize], dek, aad)
+	raw = append(raw, nonces[nonceSize:]...)
+	return dataGCM.Seal(raw, nonces[nonceSize:], plaintext, aad), nil
+}
+
+func openEnvelope(kek, raw, aad []byte) ([]byte, error) {
+	keyGCM, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	sealedSize := dekSize + keyGCM.Overhead()
+	if len(raw) < 2*nonceSize+sealedSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	dek, err := keyGCM.Open(nil, raw[:nonceSize], raw[nonceSize:nonceSize+sealedSize], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	dataGCM, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[nonceSize+sealedSize:]
+	plaintext, err := dataGCM.Open(nil, raw[:nonceSize], raw[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+// deterministicKeys derives the encryption key and the nonce key from kek.
+func deterministicKeys(kek []byte) (key, nonceKey []byte) {
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, kek)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	return derive("orm/encrypt deterministic key"), derive("orm/encrypt deterministic nonce")
+}
+
+// sealDeterministic returns nonce | sealed plaintext, the nonce is the HMAC of the column and plaintext.
+func sealDeterministic(kek, plaintext, aad []byte) ([]byte, error) {
+	key, nonceKey := deterministicKeys(kek)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write(aad)
+	mac.Write([]byte{0})
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:nonceSize]
+
+	return gcm.Seal(append([]byte(nil), nonce...), nonce, plaintext, aad), nil
+}
+
+func openDeterministic(kek, raw, aad []byte) ([]byte, error) {
+	if len(raw) < nonceSize {
+		return nil, ErrInvalidCiphertext
+	}
+	key, _ := deterministicKeys(kek)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, raw[:nonceSize], raw[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+// plaintextOf returns the bytes of a string / []byte value, or its JSON. null is true for a nil pointer.
+func plaintextOf(value interface{}) (plaintext []byte, null bool, err error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, true, nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch {
+	case !rv.IsValid():
+		return nil, true, nil
+	case rv.Kind() == reflect.String:
+		return []byte(rv.String()), false, nil
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		if rv.IsNil() {
+			return nil, true, nil
+		}
+		return rv.Bytes(), false, nil
+	default:
+		plaintext, err = json.Marshal(rv.Interface())
+		return plaintext, false, err
+	}
+}
+
+func setPlaintext(v reflect.Value, plaintext []byte) error {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(plaintext))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(plaintext)
+	default:
+		return json.Unmarshal(plaintext, v.Addr().Interface())
+	}
+	return nil
+}
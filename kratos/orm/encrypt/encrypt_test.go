@@ -0,0 +1,251 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/encrypt"
+)
+
+type Meta struct {
+	Scopes []string `json:"scopes"`
+}
+
+type Credential struct {
+	ID     int64   `gorm:"column:id;primaryKey;autoIncrement;"`
+	Name   string  `gorm:"column:name;"`
+	Secret string  `gorm:"column:secret;serializer:encrypted;"`
+	Token  string  `gorm:"column:token;serializer:encrypted;deterministic;"`
+	Note   *string `gorm:"column:note;serializer:encrypted;"`
+	Meta   Meta    `gorm:"column:meta;serializer:encrypted;"`
+	orm.DBModel
+}
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func keys(t *testing.T, current string) encrypt.KeyProvider {
+	t.Helper()
+
+	provider, err := encrypt.StaticKeys(current, map[string][]byte{"k1": key1, "k2": key2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// openDB opens the database of the test, the serializer is resolved when the models are parsed by a new *gorm.DB.
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Credential{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func rawColumn(t *testing.T, db *gorm.DB, column string, id int64) string {
+	t.Helper()
+
+	var v string
+	if err := db.Table("credentials").Select(column).Where("id = ?", id).Row().Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func first(t *testing.T, db *gorm.DB, id int64) Credential {
+	t.Helper()
+
+	var c Credential
+	if err := db.First(&c, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSerializer(t *testing.T) {
+	encrypt.Register(keys(t, "k1"))
+	db := openDB(t)
+	ctx := context.Background()
+
+	note := "note"
+	c := &Credential{Name: "a", Secret: "s3cr3t", Token: "tok-1", Note: &note, Meta: Meta{Scopes: []string{"read"}}}
+	assert.NoError(t, db.WithContext(ctx).Create(c).Error)
+	assert.NoError(t, db.Create(&Credential{Name: "b", Secret: "s3cr3t", Token: "tok-2"}).Error)
+
+	secret := rawColumn(t, db, "secret", c.ID)
+	assert.True(t, strings.HasPrefix(secret, "$e1$k1$"), secret)
+	assert.NotContains(t, secret, "s3cr3t")
+	// the random data key gives a different ciphertext for the same value.
+	assert.NotEqual(t, secret, rawColumn(t, db, "secret", 2))
+	assert.True(t, strings.HasPrefix(rawColumn(t, db, "token", c.ID), "$d1$k1$"))
+
+	got := first(t, db, c.ID)
+	assert.Equal(t, "s3cr3t", got.Secret)
+	assert.Equal(t, "tok-1", got.Token)
+	assert.Equal(t, "note", *got.Note)
+	assert.Equal(t, []string{"read"}, got.Meta.Scopes)
+
+	assert.Nil(t, first(t, db, 2).Note)
+
+	// the deterministic field is looked up by equality.
+	var found []Credential
+	assert.NoError(t, db.Where(&Credential{Token: "tok-2"}).Find(&found).Error)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "b", found[0].Name)
+	}
+
+	// a ciphertext copied to another column does not decrypt.
+	assert.NoError(t, db.Exec("UPDATE credentials SET secret = token WHERE id = ?", c.ID).Error)
+	assert.ErrorIs(t, db.First(&Credential{}, c.ID).Error, encrypt.ErrInvalidCiphertext)
+}
+
+func TestReencrypt(t *testing.T) {
+	encrypt.Register(keys(t, "k1"))
+	db := openDB(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		err := db.Create(&Credential{Name: fmt.Sprint(i), Secret: fmt.Sprintf("secret-%d", i), Token: fmt.Sprintf("tok-%d", i)}).Error
+		assert.NoError(t, err)
+	}
+	// a soft deleted row, and a plain column not migrated yet.
+	assert.NoError(t, db.Delete(&Credential{}, 5).Error)
+	assert.NoError(t, db.Exec("UPDATE credentials SET secret = 'plain' WHERE id = 4").Error)
+
+	// rotate to k2.
+	s := encrypt.Register(keys(t, "k2"), encrypt.WithPlaintextFallback())
+	db = openDB(t)
+
+	assert.Equal(t, "secret-1", first(t, db, 1).Secret)
+	assert.Equal(t, "plain", first(t, db, 4).Secret)
+
+	// rotated deterministic values are found once re-encrypted.
+	var got Credential
+	assert.ErrorIs(t, db.Where(&Credential{Token: "tok-1"}).First(&got).Error, gorm.ErrRecordNotFound)
+	// meanwhile they are found with the ciphertexts of every key.
+	tokens, err := s.Lookup(ctx, "tok-1", "token", "k1", "k2")
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2)
+	assert.NoError(t, db.Where("token IN ?", tokens).First(&got).Error)
+	assert.Equal(t, "secret-1", got.Secret)
+
+	n, err := encrypt.Reencrypt[Credential](ctx, db, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	for id := int64(1); id <= 5; id++ {
+		assert.True(t, strings.HasPrefix(rawColumn(t, db, "secret", id), "$e1$k2$"))
+		assert.True(t, strings.HasPrefix(rawColumn(t, db, "token", id), "$d1$k2$"))
+	}
+	assert.NoError(t, db.Where(&Credential{Token: "tok-1"}).First(&got).Error)
+	assert.Equal(t, "secret-1", got.Secret)
+	assert.Equal(t, "plain", first(t, db, 4).Secret)
+
+	n, err = encrypt.Reencrypt[Credential](ctx, db, 2)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	// k1 is gone.
+	provider, err := encrypt.StaticKeys("k2", map[string][]byte{"k2": key2})
+	assert.NoError(t, err)
+	encrypt.Register(provider)
+	db = openDB(t)
+	assert.Equal(t, "secret-1", first(t, db, 1).Secret)
+}
+
+func TestReencryptConcurrentWrite(t *testing.T) {
+	encrypt.Register(keys(t, "k1"))
+	db := openDB(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		assert.NoError(t, db.Create(&Credential{Name: fmt.Sprint(i), Secret: fmt.Sprintf("secret-%d", i)}).Error)
+	}
+
+	s := encrypt.Register(keys(t, "k2"))
+	db = openDB(t)
+	written, err := s.Encrypt(ctx, []byte("written"), "secret", false)
+	assert.NoError(t, err)
+
+	// the application writes row 1 after the batch has been read.
+	var once sync.Once
+	err = db.Callback().Update().Before("gorm:update").Register("test:write", func(tx *gorm.DB) {
+		once.Do(func() {
+			_ = tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE credentials SET secret = ? WHERE id = 1", written).Error
+		})
+	})
+	assert.NoError(t, err)
+
+	n, err := encrypt.Reencrypt[Credential](ctx, db, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, "written", first(t, db, 1).Secret)
+	assert.Equal(t, "secret-2", first(t, db, 2).Secret)
+}
+
+func TestKeyProviders(t *testing.T) {
+	ctx := context.Background()
+	data := fmt.Sprintf(`{"current": "k2", "keys": {"k1": %q, "k2": %q}}`,
+		base64.StdEncoding.EncodeToString(key1), base64.StdEncoding.EncodeToString(key2))
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	fromFile, err := encrypt.FileKeys(path)
+	assert.NoError(t, err)
+
+	t.Setenv("ORM_ENCRYPTION_KEYS", data)
+	fromEnv, err := encrypt.EnvKeys("ORM_ENCRYPTION_KEYS")
+	assert.NoError(t, err)
+
+	for _, provider := range []encrypt.KeyProvider{fromFile, fromEnv} {
+		current, err := provider.Current(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "k2", current)
+		key, err := provider.Key(ctx, "k1")
+		assert.NoError(t, err)
+		assert.Equal(t, key1, key)
+		_, err = provider.Key(ctx, "k3")
+		assert.ErrorIs(t, err, encrypt.ErrKeyNotFound)
+	}
+
+	_, err = encrypt.EnvKeys("ORM_ENCRYPTION_KEYS_MISSING")
+	assert.ErrorIs(t, err, encrypt.ErrKeyNotFound)
+	_, err = encrypt.StaticKeys("k1", map[string][]byte{"k1": []byte("short")})
+	assert.Error(t, err)
+	_, err = encrypt.StaticKeys("k$1", map[string][]byte{"k$1": key1})
+	assert.Error(t, err)
+
+	// encrypt / decrypt without gorm.
+	s := encrypt.New(keys(t, "k1"))
+	ciphertext, err := s.Encrypt(ctx, []byte("value"), "secret", false)
+	assert.NoError(t, err)
+	id, ok := encrypt.KeyID(ciphertext)
+	assert.True(t, ok)
+	assert.Equal(t, "k1", id)
+	plaintext, err := s.Decrypt(ctx, ciphertext, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(plaintext))
+	_, err = s.Decrypt(ctx, "plain", "secret")
+	assert.ErrorIs(t, err, encrypt.ErrInvalidCiphertext)
+}
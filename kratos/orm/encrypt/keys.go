@@ -0,0 +1,103 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrKeyNotFound = errors.New("encrypt: key not found")
+
+// KeyProvider the key encryption keys by id.
+//
+// keys are rotated by adding a new key and making it current, the old keys must be kept
+// until Reencrypt has migrated the rows encrypted with them.
+type KeyProvider interface {
+	// Current returns the id of the key the new values are encrypted with.
+	Current(ctx context.Context) (string, error)
+	// Key returns the AES-128 / 192 / 256 key of id.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+type staticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// StaticKeys a KeyProvider of keys in memory, the id must not contain a $.
+func StaticKeys(current string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: current key %q", ErrKeyNotFound, current)
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, "$") {
+			return nil, fmt.Errorf("encrypt: invalid key id %q", id)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("encrypt: key %q must be 16, 24 or 32 bytes", id)
+		}
+	}
+	return &staticKeys{current: current, keys: keys}, nil
+}
+
+func (s *staticKeys) Current(context.Context) (string, error) {
+	return s.current, nil
+}
+
+func (s *staticKeys) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// keyFile the JSON of FileKeys and EnvKeys.
+//
+//	{"current": "2024-06", "keys": {"2024-01": "<base64 key>", "2024-06": "<base64 key>"}}
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func parseKeys(data []byte) (KeyProvider, error) {
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("encrypt: parse keys: %w", err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt: decode key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return StaticKeys(f.Current, keys)
+}
+
+// FileKeys loads the keys from a JSON file, e.g. a mounted kubernetes secret.
+//
+//	{"current": "2024-06", "keys": {"2024-01": "<base64 key>", "2024-06": "<base64 key>"}}
+func FileKeys(path string) (KeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: read keys: %w", err)
+	}
+	return parseKeys(data)
+}
+
+// EnvKeys loads the keys from the environment variable name, in the JSON of FileKeys.
+func EnvKeys(name string) (KeyProvider, error) {
+	data, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: environment variable %s is not set", ErrKeyNotFound, name)
+	}
+	return parseKeys([]byte(data))
+}
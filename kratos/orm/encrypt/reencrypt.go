@@ -0,0 +1,161 @@
+package encrypt
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const defaultBatchSize = 500
+
+// Reencrypt migrates the encrypted columns of T to the current key batchSize rows at a time,
+// in the order of the primary key, returns the number of updated rows.
+//
+// the rows encrypted with an old key are re-encrypted, so is the plaintext of a Serializer WithPlaintextFallback.
+// the soft deleted rows are included, the hooks and updated_at are left alone.
+// a row whose encrypted columns are written while its batch is migrated is skipped, the new value is kept.
+//
+//	n, err := encrypt.Reencrypt[Credential](ctx, db, 500)
+func Reencrypt[T any](ctx context.Context, db *gorm.DB, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return 0, err
+	}
+	s := stmt.Schema
+	if len(s.PrimaryFields) != 1 {
+		return 0, fmt.Errorf("encrypt: %s must have a single primary key", s.Name)
+	}
+	pk := s.PrimaryFields[0]
+
+	var fields []*schema.Field
+	columns := []string{pk.DBName}
+	for _, field := range s.Fields {
+		if _, ok := field.Serializer.(*Serializer); ok && field.DBName != "" {
+			fields = append(fields, field)
+			columns = append(columns, field.DBName)
+		}
+	}
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("encrypt: %s has no encrypted field", s.Name)
+	}
+
+	var (
+		total int64
+		last  interface{}
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		tx := db.WithContext(ctx).Table(s.Table).Select(columns).Order(pk.DBName).Limit(batchSize)
+		if last != nil {
+			tx = tx.Where(clause.Gt{Column: clause.Column{Name: pk.DBName}, Value: last})
+		}
+		batch, err := scanRows(tx, len(columns))
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, row := range batch {
+				updates, err := reencryptRow(ctx, fields, row[1:])
+				if err != nil {
+					return fmt.Errorf("encrypt: %s %v: %w", s.Table, row[0], err)
+				}
+				if len(updates) == 0 {
+					continue
+				}
+				// guarded by the ciphertext that was read, a value written since is newer and kept.
+				conds := []clause.Expression{clause.Eq{Column: clause.Column{Name: pk.DBName}, Value: row[0]}}
+				for i, field := range fields {
+					if _, ok := updates[field.DBName]; ok {
+						conds = append(conds, clause.Eq{Column: clause.Column{Name: field.DBName}, Value: row[i+1]})
+					}
+				}
+				result := tx.Table(s.Table).Where(clause.And(conds...)).Updates(updates)
+				if result.Error != nil {
+					return result.Error
+				}
+				total += result.RowsAffected
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+
+		if len(batch) < batchSize {
+			return total, nil
+		}
+		last = batch[len(batch)-1][0]
+	}
+}
+
+func scanRows(tx *gorm.DB, n int) ([][]interface{}, error) {
+	rows, err := tx.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, n)
+		dest := make([]interface{}, n)
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		batch = append(batch, values)
+	}
+	return batch, rows.Err()
+}
+
+// reencryptRow returns the new ciphertext of the columns not encrypted with the current key.
+func reencryptRow(ctx context.Context, fields []*schema.Field, values []interface{}) (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	for i, field := range fields {
+		var ciphertext string
+		switch v := values[i].(type) {
+		case string:
+			ciphertext = v
+		case []byte:
+			ciphertext = string(v)
+		}
+		if ciphertext == "" {
+			continue
+		}
+
+		s := field.Serializer.(*Serializer)
+		current, err := s.provider.Current(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := KeyID(ciphertext); ok && id == current {
+			continue
+		}
+
+		plaintext, err := s.Decrypt(ctx, ciphertext, field.DBName)
+		if err != nil {
+			return nil, err
+		}
+		_, deterministic := field.TagSettings[deterministicTag]
+		if updates[field.DBName], err = s.Encrypt(ctx, plaintext, field.DBName, deterministic); err != nil {
+			return nil, err
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,245 @@
+// Package errors translates the gorm and driver errors into kratos errors with stable reasons,
+// so handlers don't match the error strings of the drivers.
+package errors
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+const (
+	ReasonNotFound            = "RECORD_NOT_FOUND"
+	ReasonDuplicateKey        = "DUPLICATE_KEY"
+	ReasonForeignKeyViolation = "FOREIGN_KEY_VIOLATION"
+	ReasonNotNullViolation    = "NOT_NULL_VIOLATION"
+	ReasonCheckViolation      = "CHECK_VIOLATION"
+	ReasonInvalidValue        = "INVALID_VALUE"
+	ReasonTimeout             = "DATABASE_TIMEOUT"
+	ReasonBusy                = "DATABASE_BUSY"
+	ReasonUnavailable         = "DATABASE_UNAVAILABLE"
+)
+
+// metadata keys of the translated errors.
+const (
+	MetadataTable      = "table"
+	MetadataConstraint = "constraint"
+	MetadataColumn     = "column"
+)
+
+// mysql error numbers.
+const (
+	mysqlDuplicateEntry   = 1062
+	mysqlRowIsReferenced  = 1451
+	mysqlNoReferencedRow  = 1452
+	mysqlBadNull          = 1048
+	mysqlNoDefault        = 1364
+	mysqlDataTooLong      = 1406
+	mysqlOutOfRange       = 1264
+	mysqlTruncatedValue   = 1366
+	mysqlCheckConstraint  = 3819
+	mysqlRowIsReferenced2 = 1217
+	mysqlNoReferencedRow2 = 1216
+)
+
+// sqlite extended result codes.
+const (
+	sqliteConstraintCheck      = 275
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintNotNull    = 1299
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+var (
+	mysqlKeyRe        = regexp.MustCompile("for key '([^']*)'")
+	mysqlForeignKeyRe = regexp.MustCompile("`([^`]*)`, CONSTRAINT `([^`]*)`")
+	mysqlColumnRe     = regexp.MustCompile("(?i)column '([^']*)'")
+	mysqlCheckRe      = regexp.MustCompile("(?i)check constraint '([^']*)'")
+	sqliteColumnsRe   = regexp.MustCompile(`constraint failed: (\w+\.\w+(?:, \w+\.\w+)*)`)
+)
+
+type options struct {
+	table string
+}
+
+type Option func(*options)
+
+// WithTable set the table metadata when the driver error doesn't carry it.
+func WithTable(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+// Translate returns the kratos error of err, with the table / constraint / column metadata found in the driver message.
+//
+//   - NotFound: gorm.ErrRecordNotFound
+//   - Conflict: duplicate key, deleting a referenced row
+//   - BadRequest: missing referenced row, not null / check violations, invalid values
+//   - ServiceUnavailable: context deadline, deadlock / lock timeout / busy, broken connection
+//
+// nil, kratos errors and unknown errors are returned as is. the original error is kept as the cause.
+func Translate(err error, opts ...Option) error {
+	if err == nil {
+		return nil
+	}
+	var kerr *kerrors.Error
+	if errors.As(err, &kerr) {
+		return err
+	}
+
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	e := translate(err)
+	if e == nil {
+		return err
+	}
+	if o.table != "" && e.Metadata[MetadataTable] == "" {
+		e.Metadata[MetadataTable] = o.table
+	}
+	return e.WithCause(err)
+}
+
+func newError(code int, reason, message string, kv ...string) *kerrors.Error {
+	md := make(map[string]string)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			md[kv[i]] = kv[i+1]
+		}
+	}
+	return kerrors.New(code, reason, message).WithMetadata(md)
+}
+
+func translate(err error) *kerrors.Error {
+	if e := translateMySQL(err); e != nil {
+		return e
+	}
+	if e := translateSQLite(err); e != nil {
+		return e
+	}
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return newError(404, ReasonNotFound, "record not found")
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return newError(409, ReasonDuplicateKey, "record already exists")
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return newError(409, ReasonForeignKeyViolation, "foreign key constraint violated")
+	case errors.Is(err, context.DeadlineExceeded):
+		return newError(503, ReasonTimeout, "database timeout")
+	case orm.IsRetryable(err):
+		return newError(503, ReasonBusy, "database busy")
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn):
+		return newError(503, ReasonUnavailable, "database unavailable")
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return newError(503, ReasonTimeout, "database timeout")
+		}
+		return newError(503, ReasonUnavailable, "database unavailable")
+	}
+	return nil
+}
+
+func submatch(re *regexp.Regexp, s string, i int) string {
+	if m := re.FindStringSubmatch(s); len(m) > i {
+		return m[i]
+	}
+	return ""
+}
+
+func translateMySQL(err error) *kerrors.Error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return nil
+	}
+
+	msg := mysqlErr.Message
+	switch mysqlErr.Number {
+	case mysqlDuplicateEntry:
+		// MySQL 8 reports the key as table.key
+		key := submatch(mysqlKeyRe, msg, 1)
+		table, constraint, ok := strings.Cut(key, ".")
+		if !ok {
+			table, constraint = "", key
+		}
+		return newError(409, ReasonDuplicateKey, "record already exists",
+			MetadataTable, table, MetadataConstraint, constraint)
+	case mysqlRowIsReferenced, mysqlRowIsReferenced2:
+		return newError(409, ReasonForeignKeyViolation, "record is referenced by other records",
+			MetadataTable, submatch(mysqlForeignKeyRe, msg, 1), MetadataConstraint, submatch(mysqlForeignKeyRe, msg, 2))
+	case mysqlNoReferencedRow, mysqlNoReferencedRow2:
+		return newError(400, ReasonForeignKeyViolation, "referenced record does not exist",
+			MetadataTable, submatch(mysqlForeignKeyRe, msg, 1), MetadataConstraint, submatch(mysqlForeignKeyRe, msg, 2))
+	case mysqlBadNull, mysqlNoDefault:
+		return newError(400, ReasonNotNullViolation, "required column is null",
+			MetadataColumn, submatch(mysqlColumnRe, msg, 1))
+	case mysqlCheckConstraint:
+		return newError(400, ReasonCheckViolation, "check constraint violated",
+			MetadataConstraint, submatch(mysqlCheckRe, msg, 1))
+	case mysqlDataTooLong, mysqlOutOfRange, mysqlTruncatedValue:
+		return newError(400, ReasonInvalidValue, "invalid column value",
+			MetadataColumn, submatch(mysqlColumnRe, msg, 1))
+	}
+	return nil
+}
+
+// sqliteColumns splits "users.email, users.name" of the sqlite message into the table and columns.
+func sqliteColumns(msg string) (string, string) {
+	var (
+		table   string
+		columns []string
+	)
+	for _, c := range strings.Split(submatch(sqliteColumnsRe, msg, 1), ", ") {
+		t, column, ok := strings.Cut(c, ".")
+		if !ok {
+			continue
+		}
+		table = t
+		columns = append(columns, column)
+	}
+	return table, strings.Join(columns, ",")
+}
+
+func translateSQLite(err error) *kerrors.Error {
+	// both glebarez/go-sqlite and modernc.org/sqlite errors carry the result code.
+	var sqliteErr interface {
+		error
+		Code() int
+	}
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+
+	msg := sqliteErr.Error()
+	switch sqliteErr.Code() {
+	case sqliteConstraintUnique, sqliteConstraintPrimaryKey:
+		table, columns := sqliteColumns(msg)
+		return newError(409, ReasonDuplicateKey, "record already exists",
+			MetadataTable, table, MetadataColumn, columns)
+	case sqliteConstraintForeignKey:
+		return newError(409, ReasonForeignKeyViolation, "foreign key constraint violated")
+	case sqliteConstraintNotNull:
+		table, columns := sqliteColumns(msg)
+		return newError(400, ReasonNotNullViolation, "required column is null",
+			MetadataTable, table, MetadataColumn, columns)
+	case sqliteConstraintCheck:
+		return newError(400, ReasonCheckViolation, "check constraint violated")
+	}
+	return nil
+}
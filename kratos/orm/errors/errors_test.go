@@ -0,0 +1,135 @@
+package errors_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	ormerrors "github.com/omalloc/contrib/kratos/orm/errors"
+)
+
+type busyError struct{}
+
+func (busyError) Error() string { return "database is locked" }
+func (busyError) Code() int     { return 5 }
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		code     int
+		reason   string
+		metadata map[string]string
+	}{
+		{"not found", fmt.Errorf("select: %w", gorm.ErrRecordNotFound), 404, ormerrors.ReasonNotFound, map[string]string{}},
+		{"gorm duplicated", gorm.ErrDuplicatedKey, 409, ormerrors.ReasonDuplicateKey, map[string]string{}},
+		{
+			"mysql duplicate entry",
+			&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a.com' for key 'domains.idx_name'"},
+			409, ormerrors.ReasonDuplicateKey,
+			map[string]string{"table": "domains", "constraint": "idx_name"},
+		},
+		{
+			"mysql 5.7 duplicate entry",
+			&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"},
+			409, ormerrors.ReasonDuplicateKey,
+			map[string]string{"constraint": "PRIMARY"},
+		},
+		{
+			"mysql row is referenced",
+			&mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row: a foreign key constraint fails (`app`.`orders`, CONSTRAINT `fk_orders_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"},
+			409, ormerrors.ReasonForeignKeyViolation,
+			map[string]string{"table": "orders", "constraint": "fk_orders_user"},
+		},
+		{
+			"mysql no referenced row",
+			&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`app`.`orders`, CONSTRAINT `fk_orders_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"},
+			400, ormerrors.ReasonForeignKeyViolation,
+			map[string]string{"table": "orders", "constraint": "fk_orders_user"},
+		},
+		{
+			"mysql bad null",
+			&mysql.MySQLError{Number: 1048, Message: "Column 'name' cannot be null"},
+			400, ormerrors.ReasonNotNullViolation,
+			map[string]string{"column": "name"},
+		},
+		{
+			"mysql data too long",
+			&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name' at row 1"},
+			400, ormerrors.ReasonInvalidValue,
+			map[string]string{"column": "name"},
+		},
+		{
+			"mysql check",
+			&mysql.MySQLError{Number: 3819, Message: "Check constraint 'chk_price' is violated."},
+			400, ormerrors.ReasonCheckViolation,
+			map[string]string{"constraint": "chk_price"},
+		},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, 503, ormerrors.ReasonBusy, map[string]string{}},
+		{"sqlite busy", busyError{}, 503, ormerrors.ReasonBusy, map[string]string{}},
+		{"timeout", fmt.Errorf("query: %w", context.DeadlineExceeded), 503, ormerrors.ReasonTimeout, map[string]string{}},
+		{"bad conn", driver.ErrBadConn, 503, ormerrors.ReasonUnavailable, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ormerrors.Translate(tt.err)
+			e := kerrors.FromError(err)
+			assert.Equal(t, tt.code, int(e.Code))
+			assert.Equal(t, tt.reason, e.Reason)
+			assert.Equal(t, tt.metadata, e.Metadata)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestTranslateUnchanged(t *testing.T) {
+	assert.NoError(t, ormerrors.Translate(nil))
+
+	err := errors.New("unknown")
+	assert.Equal(t, err, ormerrors.Translate(err))
+
+	kerr := kerrors.NotFound("USER_NOT_FOUND", "user not found")
+	assert.Equal(t, kerr, ormerrors.Translate(kerr))
+}
+
+type Domain struct {
+	ID   int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Name string `gorm:"column:name;uniqueIndex;not null;"`
+}
+
+func TestTranslateSQLite(t *testing.T) {
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Domain{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, db.Create(&Domain{Name: "a.com"}).Error)
+
+	err = ormerrors.Translate(db.Create(&Domain{Name: "a.com"}).Error)
+	assert.True(t, kerrors.IsConflict(err))
+	e := kerrors.FromError(err)
+	assert.Equal(t, ormerrors.ReasonDuplicateKey, e.Reason)
+	assert.Equal(t, map[string]string{"table": "domains", "column": "name"}, e.Metadata)
+
+	err = ormerrors.Translate(db.Exec("INSERT INTO domains (name) VALUES (NULL)").Error)
+	assert.True(t, kerrors.IsBadRequest(err))
+	assert.Equal(t, ormerrors.ReasonNotNullViolation, kerrors.Reason(err))
+
+	err = ormerrors.Translate(db.First(&Domain{}, 100).Error, ormerrors.WithTable("domains"))
+	assert.True(t, kerrors.IsNotFound(err))
+	assert.Equal(t, "domains", kerrors.FromError(err).Metadata["table"])
+}
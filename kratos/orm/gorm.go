@@ -2,6 +2,7 @@ package orm
 
 import (
 	"errors"
+	"strings"
 
 	"gorm.io/gorm"
 	glog "gorm.io/gorm/logger"
@@ -24,6 +25,7 @@ type Config struct {
 	tracer   *GormOpenTelemetryPlugin
 	tracing  bool
 	hasDebug bool
+	plugins  []gorm.Plugin
 }
 
 type Option func(*Config)
@@ -57,6 +59,13 @@ func WithLogger(opts ...GormLoggerOption) Option {
 	}
 }
 
+// WithPlugins set gorm-plugins. registered in order after the tracing plugin.
+func WithPlugins(plugins ...gorm.Plugin) Option {
+	return func(c *Config) {
+		c.plugins = append(c.plugins, plugins...)
+	}
+}
+
 func New(opts ...Option) (*gorm.DB, error) {
 	c := &Config{
 		hasDebug: false,
@@ -78,6 +87,9 @@ func New(opts ...Option) (*gorm.DB, error) {
 	if c.driver == nil {
 		return nil, ErrDriverNotFound
 	}
+	if gl, ok := c.log.(*gormLogger); ok {
+		gl.quotedIdentifiers = QuotesIdentifiers(c.driver)
+	}
 
 	db, err := gorm.Open(c.driver, c.opts)
 	if err != nil {
@@ -92,5 +104,18 @@ func New(opts ...Option) (*gorm.DB, error) {
 		_ = db.Use(c.tracer)
 	}
 
+	for _, plugin := range c.plugins {
+		if err := db.Use(plugin); err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
+
+// QuotesIdentifiers reports whether driver quotes the identifiers with ", e.g. postgres.
+func QuotesIdentifiers(driver gorm.Dialector) bool {
+	var b strings.Builder
+	driver.QuoteTo(&b, "t")
+	return strings.HasPrefix(b.String(), `"`)
+}
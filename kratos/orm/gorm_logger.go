@@ -3,29 +3,49 @@ package orm
 import (
 	"context"
 	"errors"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-kratos/kratos/v2/log"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	glog "gorm.io/gorm/logger"
 )
 
+var (
+	// literalRegexp matches the quoted strings and the numbers of a SQL, the double-quoted strings of MySQL included.
+	literalRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"|\b\d+(?:\.\d+)?\b`)
+	// singleQuotedRegexp is literalRegexp of the dialects quoting the identifiers with ", e.g. postgres.
+	singleQuotedRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|\b\d+(?:\.\d+)?\b`)
+)
+
+// ormPackage is the import path of this package, its frames and the gorm ones are skipped by the caller lookup.
+var ormPackage = reflect.TypeOf(gormLogger{}).PkgPath()
+
 type gormLogger struct {
-	debug                 bool
+	level                 glog.LogLevel // Silent / Error / Warn / Info, 默认 Warn
 	dbLog                 *log.Helper
 	SlowThreshold         time.Duration
-	SourceField           string
+	SourceField           string // 调用位置的字段名, 默认 caller
 	SkipCallerLookup      bool
 	SkipErrRecordNotFound bool
+	redact                bool // 隐去 SQL 中的字面量
+	quotedIdentifiers     bool // 方言的标识符使用双引号, 双引号字符串不隐去
 }
 type GormLoggerOption func(*gormLogger)
 
 func NewLogger(opts ...GormLoggerOption) *gormLogger {
 	// default options
 	r := &gormLogger{
-		debug:                 false,
+		level:                 glog.Warn,
 		dbLog:                 log.NewHelper(log.GetLogger()),
 		SlowThreshold:         500 * time.Millisecond, // 500毫秒查询 + 500毫秒业务响应 = 1s 用户最佳体验 loading 之内，超过则属于慢查询
+		SourceField:           "caller",
 		SkipCallerLookup:      false,
 		SkipErrRecordNotFound: true,
 	}
@@ -37,9 +57,18 @@ func NewLogger(opts ...GormLoggerOption) *gormLogger {
 	return r
 }
 
+// WithDebug logs every SQL at debug level, same as WithLogLevel(logger.Info).
 func WithDebug() GormLoggerOption {
 	return func(logger *gormLogger) {
-		logger.debug = true
+		logger.level = glog.Info
+	}
+}
+
+// WithLogLevel set the gorm log level, the errors are logged from Error, the slow queries from Warn
+// and every SQL from Info. default is Warn.
+func WithLogLevel(level glog.LogLevel) GormLoggerOption {
+	return func(logger *gormLogger) {
+		logger.level = level
 	}
 }
 
@@ -68,52 +97,143 @@ func WithSkipErrRecordNotFound(skip bool) GormLoggerOption {
 	}
 }
 
+// WithRedaction replaces the parameters and the string and number literals of the logged SQL with ?,
+// so the personal data never reaches the logs.
+//
+// the double-quoted strings are literals as in MySQL, unless orm.New finds the driver quotes the identifiers with them.
+func WithRedaction() GormLoggerOption {
+	return func(logger *gormLogger) {
+		logger.redact = true
+	}
+}
+
+// Redact replaces the string and number literals of sql with ?, the double-quoted strings are literals as in MySQL
+// unless quotedIdentifiers is set for a driver quoting the identifiers with them, see QuotesIdentifiers.
+func Redact(sql string, quotedIdentifiers bool) string {
+	if quotedIdentifiers {
+		return singleQuotedRegexp.ReplaceAllString(sql, "?")
+	}
+	return literalRegexp.ReplaceAllString(sql, "?")
+}
+
+// LogMode returns a copy at level, e.g. db.Debug() logs every SQL of the session.
 func (gl *gormLogger) LogMode(level glog.LogLevel) glog.Interface {
-	return gl
+	l := *gl
+	l.level = level
+	return &l
 }
 
 func (gl *gormLogger) Info(ctx context.Context, s string, args ...interface{}) {
-	gl.dbLog.Info(s, args)
+	if gl.level >= glog.Info {
+		gl.dbLog.WithContext(ctx).Infof(s, args...)
+	}
 }
 
 func (gl *gormLogger) Warn(ctx context.Context, s string, args ...interface{}) {
-	gl.dbLog.Warn(s, args)
+	if gl.level >= glog.Warn {
+		gl.dbLog.WithContext(ctx).Warnf(s, args...)
+	}
 }
 
 func (gl *gormLogger) Error(ctx context.Context, s string, args ...interface{}) {
-	gl.dbLog.Error(s, args)
+	if gl.level >= glog.Error {
+		gl.dbLog.WithContext(ctx).Errorf(s, args...)
+	}
+}
+
+// ParamsFilter implements gorm.ParamsFilter, the parameters are left as placeholders when redacting.
+func (gl *gormLogger) ParamsFilter(_ context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if gl.redact {
+		return sql, nil
+	}
+	return sql, params
 }
 
 func (gl *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
-	if !gl.debug {
+	if gl.level <= glog.Silent {
 		return
 	}
 
 	elapsed := time.Since(begin)
+	failed := err != nil && !(gl.SkipErrRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound))
+	slow := gl.SlowThreshold != 0 && elapsed > gl.SlowThreshold
+	switch {
+	case failed && gl.level >= glog.Error:
+	case slow && gl.level >= glog.Warn:
+	case gl.level >= glog.Info:
+	default:
+		return
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	timeUsed := float64(elapsed.Nanoseconds()) / 1e6
 
-	fields := make([]interface{}, 0)
+	fields := make([]interface{}, 0, 16)
 	fields = append(fields, "timeUsed", timeUsed)
 
 	sql, rows := fc()
+	if gl.redact {
+		sql = Redact(sql, gl.quotedIdentifiers)
+	}
+	fields = append(fields, "sql", sql)
+	fields = append(fields, "rows", rows)
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, "trace_id", sc.TraceID().String())
+		fields = append(fields, "span_id", sc.SpanID().String())
+	}
+	if !gl.SkipCallerLookup {
+		fields = append(fields, gl.SourceField, caller())
+	}
 
+	helper := gl.dbLog.WithContext(ctx)
 	switch {
 	//  check err
-	case err != nil && (gl.SkipErrRecordNotFound && !(errors.Is(err, gorm.ErrRecordNotFound))):
+	case failed:
 		fields = append(fields, "err", err)
-		fields = append(fields, "sql", sql)
-		fields = append(fields, "rows", rows)
-		gl.dbLog.Errorw(fields...)
+		helper.Errorw(fields...)
 	// check slow query
-	case elapsed > gl.SlowThreshold && gl.SlowThreshold != 0:
-		fields = append(fields, "sql", sql)
-		fields = append(fields, "rows", rows)
+	case slow:
 		fields = append(fields, "slowElapsed", gl.SlowThreshold)
-		gl.dbLog.Warnw(fields...)
+		helper.Warnw(fields...)
 	// normal
 	default:
-		fields = append(fields, "sql", sql)
-		fields = append(fields, "rows", rows)
-		gl.dbLog.Debugw(fields...)
+		helper.Debugw(fields...)
+	}
+}
+
+// caller returns dir/file:line of the first frame outside gorm and the orm packages, e.g. the repository of the service.
+func caller() string {
+	frame, ok := callerFrame()
+	if !ok {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(frame.File)) + "/" + filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+}
+
+// callerFrame returns the first frame outside gorm and the orm packages.
+func callerFrame() (runtime.Frame, bool) {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !internalFrame(frame) {
+			return frame, true
+		}
+		if !more {
+			return runtime.Frame{}, false
+		}
+	}
+}
+
+func internalFrame(frame runtime.Frame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
 	}
+	return strings.HasPrefix(frame.Function, "gorm.io/") ||
+		strings.HasPrefix(frame.Function, ormPackage+".") ||
+		strings.HasPrefix(frame.Function, ormPackage+"/")
 }
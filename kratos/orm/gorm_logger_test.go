@@ -0,0 +1,201 @@
+package orm_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	glog "gorm.io/gorm/logger"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+type logEntry struct {
+	level  log.Level
+	fields map[string]string
+}
+
+// captureLogger keeps the log entries in memory.
+type captureLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *captureLogger) Log(level log.Level, keyvals ...interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := logEntry{level: level, fields: make(map[string]string)}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		e.fields[fmt.Sprint(keyvals[i])] = fmt.Sprint(keyvals[i+1])
+	}
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+func (l *captureLogger) take() []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := l.entries
+	l.entries = nil
+	return entries
+}
+
+func newLoggerDB(t *testing.T, opts ...orm.GormLoggerOption) (*gorm.DB, *captureLogger) {
+	t.Helper()
+
+	capture := &captureLogger{}
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared")),
+		orm.WithLogger(append([]orm.GormLoggerOption{orm.WithLogHelper(capture)}, opts...)...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+	return db, capture
+}
+
+func TestLoggerLevel(t *testing.T) {
+	db, capture := newLoggerDB(t)
+
+	// the normal queries are not logged by default.
+	assert.NoError(t, db.Create(&User{Name: "a"}).Error)
+	assert.Empty(t, capture.take())
+
+	// errors are always logged.
+	assert.Error(t, db.Exec("SELECT * FROM missing").Error)
+	entries := capture.take()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, log.LevelError, entries[0].level)
+		assert.Equal(t, "SELECT * FROM missing", entries[0].fields["sql"])
+		assert.Contains(t, entries[0].fields["err"], "missing")
+		assert.True(t, strings.HasPrefix(entries[0].fields["caller"], "orm/gorm_logger_test.go:"), entries[0].fields["caller"])
+	}
+
+	// record not found is skipped by default.
+	assert.Error(t, db.First(&User{}, 100).Error)
+	assert.Empty(t, capture.take())
+
+	// every SQL of a Debug session.
+	assert.NoError(t, db.Debug().First(&User{}).Error)
+	entries = capture.take()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, log.LevelDebug, entries[0].level)
+	}
+
+	silent := db.Session(&gorm.Session{Logger: db.Logger.LogMode(glog.Silent)})
+	assert.Error(t, silent.Exec("SELECT * FROM missing").Error)
+	assert.Empty(t, capture.take())
+}
+
+func TestLoggerSlowQuery(t *testing.T) {
+	db, capture := newLoggerDB(t, orm.WIthSlowThreshold(time.Nanosecond), orm.WithSkipCallerLookup(true))
+
+	assert.NoError(t, db.Find(&[]User{}).Error)
+	entries := capture.take()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, log.LevelWarn, entries[0].level)
+		assert.Equal(t, "1ns", entries[0].fields["slowElapsed"])
+		assert.NotContains(t, entries[0].fields, "caller")
+	}
+
+	// slow queries are not logged at Error.
+	assert.NoError(t, db.Session(&gorm.Session{Logger: db.Logger.LogMode(glog.Error)}).Find(&[]User{}).Error)
+	assert.Empty(t, capture.take())
+}
+
+func TestLoggerTraceID(t *testing.T) {
+	db, capture := newLoggerDB(t, orm.WithDebug())
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	assert.NoError(t, db.WithContext(ctx).Find(&[]User{}).Error)
+
+	entries := capture.take()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, sc.TraceID().String(), entries[0].fields["trace_id"])
+		assert.Equal(t, sc.SpanID().String(), entries[0].fields["span_id"])
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	db, capture := newLoggerDB(t, orm.WithDebug(), orm.WithRedaction())
+
+	assert.NoError(t, db.Where("name = ?", "alice@example.com").Find(&[]User{}).Error)
+	assert.NoError(t, db.Exec("UPDATE users SET name = 'bob''s' WHERE id = 42").Error)
+	// the double-quoted strings of MySQL.
+	assert.NoError(t, db.Exec(`UPDATE users SET name = "carol ""c""" WHERE id = 42`).Error)
+
+	entries := capture.take()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "SELECT * FROM `users` WHERE name = ? AND `users`.`deleted_at` IS NULL", entries[0].fields["sql"])
+		assert.Equal(t, "UPDATE users SET name = ? WHERE id = ?", entries[1].fields["sql"])
+		assert.Equal(t, "UPDATE users SET name = ? WHERE id = ?", entries[2].fields["sql"])
+	}
+}
+
+// quotedDialector quotes the identifiers with " as postgres does.
+type quotedDialector struct {
+	gorm.Dialector
+}
+
+func (quotedDialector) QuoteTo(w clause.Writer, s string) {
+	_ = w.WriteByte('"')
+	_, _ = w.WriteString(s)
+	_ = w.WriteByte('"')
+}
+
+func TestLoggerRedactionQuotedIdentifiers(t *testing.T) {
+	capture := &captureLogger{}
+	db, err := orm.New(
+		orm.WithDriver(quotedDialector{sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")}),
+		orm.WithLogger(orm.WithLogHelper(capture), orm.WithDebug(), orm.WithRedaction()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.AutoMigrate(&User{}))
+	capture.take()
+
+	assert.NoError(t, db.Where("name = ?", "alice").Find(&[]User{}).Error)
+
+	entries := capture.take()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, `SELECT * FROM "users" WHERE name = ? AND "users"."deleted_at" IS NULL`, entries[0].fields["sql"])
+	}
+}
+
+func TestLoggerMessages(t *testing.T) {
+	capture := &captureLogger{}
+	logger := orm.NewLogger(orm.WithLogHelper(capture))
+	ctx := context.Background()
+
+	logger.Info(ctx, "info %d", 1)
+	logger.Warn(ctx, "warn %d", 2)
+	logger.Error(ctx, "error %d", 3)
+	logger.LogMode(glog.Info).Info(ctx, "info %d", 4)
+
+	entries := capture.take()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "warn 2", entries[0].fields["msg"])
+		assert.Equal(t, "error 3", entries[1].fields["msg"])
+		assert.Equal(t, "info 4", entries[2].fields["msg"])
+	}
+}
@@ -3,11 +3,35 @@ package orm
 import (
 	"time"
 
+	"github.com/go-kratos/kratos/v2/errors"
 	"gorm.io/gorm"
 )
 
+// ErrStaleObject the versioned record has been modified or deleted since it was read.
+var ErrStaleObject = errors.Conflict("STALE_OBJECT", "record has been modified by another transaction")
+
 type DBModel struct {
 	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at;comment:创建时间"`
 	UpdatedAt time.Time      `json:"updated_at" gorm:"column:updated_at;comment:更新时间"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index;column:deleted_at;comment:删除时间"`
 }
+
+// VersionedDBModel a DBModel with optimistic locking, updates only succeed against the version that was read.
+type VersionedDBModel struct {
+	DBModel
+	Version int64 `json:"version" gorm:"column:version;not null;default:1;comment:版本号"`
+}
+
+// Versioned is implemented by the models embedding VersionedDBModel.
+type Versioned interface {
+	GetVersion() int64
+	SetVersion(version int64)
+}
+
+func (m *VersionedDBModel) GetVersion() int64 {
+	return m.Version
+}
+
+func (m *VersionedDBModel) SetVersion(version int64) {
+	m.Version = version
+}
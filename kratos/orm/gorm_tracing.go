@@ -18,6 +18,10 @@ const (
 	opQuery            = "SELECT"
 	opDelete           = "DELETE"
 	opUpdate           = "UPDATE"
+	opBegin            = "BEGIN"
+	opCommit           = "COMMIT"
+	opRollback         = "ROLLBACK"
+	spanSettingKey     = "otel:span"
 )
 
 type traceHookFunc func(tx *gorm.DB)
@@ -32,7 +36,9 @@ func (op *GormOpenTelemetryPlugin) Name() string {
 }
 
 func NewTracer(opts ...TraceOption) *GormOpenTelemetryPlugin {
-	c := &config{}
+	c := &config{
+		maxStatementSize: eventMaxSize * maxChunks,
+	}
 	for _, opt := range opts {
 		opt.apply(c)
 	}
@@ -79,10 +85,10 @@ func (op *GormOpenTelemetryPlugin) Initialize(db *gorm.DB) error {
 		{db.Callback().Raw().Before("gorm:raw"), op.before(""), beforeName("raw")},
 
 		// after hooks
-		{db.Callback().Create().After("gorm:after_create"), op.after(opCreate), afterName("create")},
+		{db.Callback().Create().After("gorm:after_create").Before("gorm:commit_or_rollback_transaction"), op.after(opCreate), afterName("create")},
 		{db.Callback().Query().After("gorm:after_query"), op.after(opQuery), afterName("select")},
-		{db.Callback().Delete().After("gorm:after_delete"), op.after(opDelete), afterName("delete")},
-		{db.Callback().Update().After("gorm:after_update"), op.after(opUpdate), afterName("update")},
+		{db.Callback().Delete().After("gorm:after_delete").Before("gorm:commit_or_rollback_transaction"), op.after(opDelete), afterName("delete")},
+		{db.Callback().Update().After("gorm:after_update").Before("gorm:commit_or_rollback_transaction"), op.after(opUpdate), afterName("update")},
 		{db.Callback().Row().After("gorm:row"), op.after(""), afterName("row")},
 		{db.Callback().Raw().After("gorm:raw"), op.after(""), afterName("raw")},
 	}
@@ -93,5 +99,10 @@ func (op *GormOpenTelemetryPlugin) Initialize(db *gorm.DB) error {
 		}
 	}
 
+	// BEGIN / COMMIT / ROLLBACK have no callbacks, the connection pool is wrapped to trace them.
+	pool := &tracedConnPool{ConnPool: db.ConnPool, op: op, dialect: db.Dialector.Name()}
+	db.ConnPool = pool
+	db.Statement.ConnPool = pool
+
 	return nil
 }
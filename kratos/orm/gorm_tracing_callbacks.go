@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -17,6 +18,7 @@ type contextTraceKey string
 const (
 	dbTableKey        = attribute.Key("db.sql.table")
 	dbRowsAffectedKey = attribute.Key("db.rows_affected")
+	dbTruncatedKey    = attribute.Key("db.statement.truncated")
 	dbOperationKey    = semconv.DBOperationKey
 	dbStatementKey    = semconv.DBStatementKey
 	omitVarsKey       = contextTraceKey("omit_vars")
@@ -43,18 +45,63 @@ func dbOperation(op string) attribute.KeyValue {
 	return dbOperationKey.String(op)
 }
 
+func dbSystem(dialect string) attribute.KeyValue {
+	switch dialect {
+	case "mysql":
+		return semconv.DBSystemMySQL
+	case "postgres":
+		return semconv.DBSystemPostgreSQL
+	case "sqlite":
+		return semconv.DBSystemSqlite
+	case "sqlserver":
+		return semconv.DBSystemMSSQL
+	default:
+		return semconv.DBSystemKey.String(dialect)
+	}
+}
+
+// commonAttributes the db.system / db.name and the calling code location of every span.
+func (op *GormOpenTelemetryPlugin) commonAttributes(dialect string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{dbSystem(dialect)}
+	if op.c.dbName != "" {
+		attrs = append(attrs, semconv.DBNameKey.String(op.c.dbName))
+	}
+	if !op.c.skipCaller {
+		if frame, ok := callerFrame(); ok {
+			attrs = append(attrs,
+				semconv.CodeFunctionKey.String(frame.Function),
+				semconv.CodeFilepathKey.String(frame.File),
+				semconv.CodeLineNumberKey.Int(frame.Line),
+			)
+		}
+	}
+	return attrs
+}
+
+func (op *GormOpenTelemetryPlugin) sampled(ctx context.Context, table, operation string) bool {
+	return op.c.sampler == nil || op.c.sampler(ctx, table, operation)
+}
+
 func (op *GormOpenTelemetryPlugin) spanName(tx *gorm.DB, operation string) string {
 	query := op.extractQuery(tx)
 
 	operation = operationForQuery(query, operation)
 
-	target := tx.Dialector.Name()
+	table := ""
+	if tx.Statement != nil {
+		table = tx.Statement.Table
+	}
+	return op.name(tx.Dialector.Name(), table, operation)
+}
+
+func (op *GormOpenTelemetryPlugin) name(dialect, table, operation string) string {
+	target := dialect
 	if op.c.dbName != "" {
 		target += "." + op.c.dbName
 	}
 
-	if tx.Statement != nil && tx.Statement.Table != "" {
-		target += "." + tx.Statement.Table
+	if table != "" {
+		target += "." + table
 	}
 
 	return fmt.Sprintf("%s %s", operation, target)
@@ -71,10 +118,17 @@ func operationForQuery(query, op string) string {
 func (op *GormOpenTelemetryPlugin) before(operation string) traceHookFunc {
 	return func(tx *gorm.DB) {
 		// skip the reporting if not recording
-		if !tx.Statement.SkipHooks {
-			tx.Statement.Context, _ = op.tracer.
-				Start(tx.Statement.Context, op.spanName(tx, operation), trace.WithSpanKind(trace.SpanKindClient))
+		if tx.Statement.SkipHooks {
+			return
+		}
+		if !op.sampled(tx.Statement.Context, tx.Statement.Table, operationForQuery(tx.Statement.SQL.String(), operation)) {
+			return
 		}
+
+		var span trace.Span
+		tx.Statement.Context, span = op.tracer.
+			Start(tx.Statement.Context, op.spanName(tx, operation), trace.WithSpanKind(trace.SpanKindClient))
+		tx.InstanceSet(spanSettingKey, span)
 	}
 }
 
@@ -90,21 +144,25 @@ func (op *GormOpenTelemetryPlugin) extractQuery(tx *gorm.DB) string {
 	return tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
 }
 
-func chunkBy(val string, size int, callback func(string, ...trace.EventOption)) {
-	if len(val) > maxChunks*size {
-		return
+// truncate keeps the head of val up to size bytes, on a rune boundary.
+func truncate(val string, size int) string {
+	if size <= 0 || len(val) <= size {
+		return val
+	}
+	for size > 0 && !utf8.RuneStart(val[size]) {
+		size--
 	}
+	return val[:size]
+}
 
-	for i := 0; i < maxChunks*size; i += size {
-		end := len(val)
-		if end > size {
-			end = size
-		}
-		callback(val[0:end])
-		if end > len(val)-1 {
-			break
+func chunkBy(val string, size int, callback func(string, ...trace.EventOption)) {
+	for len(val) > 0 {
+		chunk := truncate(val, size)
+		if chunk == "" {
+			chunk = val[:size]
 		}
-		val = val[end:]
+		callback(chunk)
+		val = val[len(chunk):]
 	}
 }
 
@@ -115,7 +173,12 @@ func (op *GormOpenTelemetryPlugin) after(operation string) traceHookFunc {
 			return
 		}
 
-		span := trace.SpanFromContext(tx.Statement.Context)
+		v, ok := tx.InstanceGet(spanSettingKey)
+		if !ok {
+			// skipped by the sampler
+			return
+		}
+		span := v.(trace.Span)
 		if !span.IsRecording() {
 			// skip the reporting if not recording
 			return
@@ -130,6 +193,10 @@ func (op *GormOpenTelemetryPlugin) after(operation string) traceHookFunc {
 
 		// extract the db operation
 		query := strings.ToValidUTF8(op.extractQuery(tx), "")
+		if truncated := truncate(query, op.c.maxStatementSize); len(truncated) < len(query) {
+			query = truncated
+			span.SetAttributes(dbTruncatedKey.Bool(true))
+		}
 
 		// If query is longer then max size log it as chunked event, otherwise log it in attribute
 		if len(query) > eventMaxSize {
@@ -138,15 +205,16 @@ func (op *GormOpenTelemetryPlugin) after(operation string) traceHookFunc {
 			span.SetAttributes(dbStatement(query))
 		}
 
-		operation = operationForQuery(query, operation)
 		if tx.Statement.Table != "" {
 			span.SetAttributes(dbTable(tx.Statement.Table))
 		}
 
 		span.SetAttributes(
-			dbOperation(operation),
+			// the hook is shared by the raw statements, operation must not be reassigned.
+			dbOperation(operationForQuery(query, operation)),
 			dbCount(tx.Statement.RowsAffected),
 		)
+		span.SetAttributes(op.commonAttributes(tx.Dialector.Name())...)
 	}
 }
 
@@ -1,15 +1,24 @@
 package orm
 
 import (
+	"context"
+
 	"go.opentelemetry.io/otel/trace"
 )
 
 type config struct {
-	dbName         string
-	tracerProvider trace.TracerProvider
-	alwaysOmitVars bool
+	dbName           string
+	tracerProvider   trace.TracerProvider
+	alwaysOmitVars   bool
+	maxStatementSize int         // db.statement 的最大长度, 超出则截断保留开头
+	sampler          SpanSampler // 返回 false 则不记录该 span
+	skipCaller       bool        // 不记录调用位置
 }
 
+// SpanSampler decides whether the span of a statement is recorded, table is empty for raw SQL and transactions,
+// operation is SELECT / INSERT / UPDATE / DELETE / BEGIN / COMMIT / ROLLBACK etc.
+type SpanSampler func(ctx context.Context, table, operation string) bool
+
 // TraceOption allows for managing options for the tracing plugin.
 type TraceOption interface {
 	apply(*config)
@@ -45,3 +54,25 @@ func WithAlwaysOmitVariables() TraceOption {
 		c.alwaysOmitVars = true
 	})
 }
+
+// WithMaxStatementSize truncates the statements longer than size, the head is kept.
+// default is 2000 bytes, 0 never truncates.
+func WithMaxStatementSize(size int) TraceOption {
+	return traceOptionFunc(func(c *config) {
+		c.maxStatementSize = size
+	})
+}
+
+// WithSampler skips the spans the sampler returns false for, e.g. the health checks or a noisy table.
+func WithSampler(sampler SpanSampler) TraceOption {
+	return traceOptionFunc(func(c *config) {
+		c.sampler = sampler
+	})
+}
+
+// WithoutCallerAttributes will omit the code.* attributes of the calling code location.
+func WithoutCallerAttributes() TraceOption {
+	return traceOptionFunc(func(c *config) {
+		c.skipCaller = true
+	})
+}
@@ -0,0 +1,135 @@
+package orm_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+func newTracingDB(t *testing.T, opts ...orm.TraceOption) (*gorm.DB, *tracetest.SpanRecorder) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared")),
+		orm.WithTracingOpts(append([]orm.TraceOption{orm.WithTracerProvider(provider), orm.WithDatabaseName("test")}, opts...)...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Reset()
+	return db, recorder
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	return names
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	db, recorder := newTracingDB(t)
+
+	assert.NoError(t, db.Create(&User{Name: "a"}).Error)
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, []string{"BEGIN sqlite.test", "INSERT sqlite.test.users", "COMMIT sqlite.test"}, spanNames(spans))
+
+		attrs := spanAttributes(spans[1])
+		assert.Equal(t, "sqlite", attrs["db.system"].AsString())
+		assert.Equal(t, "test", attrs["db.name"].AsString())
+		assert.Equal(t, "users", attrs["db.sql.table"].AsString())
+		assert.True(t, strings.HasSuffix(attrs["code.filepath"].AsString(), "gorm_tracing_test.go"), attrs["code.filepath"].AsString())
+		assert.Equal(t, "github.com/omalloc/contrib/kratos/orm_test.TestTracing", attrs["code.function"].AsString())
+		assert.Positive(t, attrs["code.lineno"].AsInt64())
+	}
+	recorder.Reset()
+
+	// a rolled back TransactionManager transaction.
+	data := &Data{db: db}
+	ctx, parent := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "request")
+	err := orm.NewTransactionManager(data).Transaction(ctx, func(ctx context.Context) error {
+		return errRollback
+	})
+	parent.End()
+	assert.ErrorIs(t, err, errRollback)
+
+	spans = recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, []string{"BEGIN sqlite.test", "ROLLBACK sqlite.test"}, spanNames(spans))
+		for _, span := range spans {
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		}
+	}
+	recorder.Reset()
+
+	// a deferred Rollback after Commit is not traced.
+	tx := db.Begin()
+	assert.NoError(t, tx.Commit().Error)
+	tx.Rollback()
+	assert.Equal(t, []string{"BEGIN sqlite.test", "COMMIT sqlite.test"}, spanNames(recorder.Ended()))
+
+	// db.DB() still works on the traced connection pool.
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	assert.NoError(t, sqlDB.Ping())
+}
+
+func TestTracing_Truncation(t *testing.T) {
+	db, recorder := newTracingDB(t, orm.WithMaxStatementSize(1200), orm.WithAlwaysOmitVariables())
+
+	query := "SELECT * FROM users WHERE name = ?" + strings.Repeat(" AND name = ?", 200)
+	assert.NoError(t, db.Exec(query, make([]interface{}, 201)...).Error)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.True(t, spanAttributes(spans[0])["db.statement.truncated"].AsBool())
+
+		var chunks []string
+		for _, event := range spans[0].Events() {
+			chunks = append(chunks, event.Name)
+		}
+		// the head of the statement is kept in 500 bytes chunks.
+		assert.Len(t, chunks, 3)
+		assert.Equal(t, query[:1200], strings.Join(chunks, ""))
+	}
+}
+
+func TestTracing_Sampler(t *testing.T) {
+	db, recorder := newTracingDB(t, orm.WithSampler(func(ctx context.Context, table, operation string) bool {
+		return table != "users" && operation != "BEGIN" && operation != "COMMIT"
+	}))
+
+	ctx, parent := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "request")
+	assert.NoError(t, db.WithContext(ctx).Create(&User{Name: "a"}).Error)
+	assert.NoError(t, db.WithContext(ctx).Find(&[]User{}).Error)
+	assert.NoError(t, db.WithContext(ctx).Exec("SELECT 1").Error)
+	// the skipped spans don't end the parent.
+	assert.True(t, parent.IsRecording())
+	parent.End()
+
+	assert.Equal(t, []string{"SELECT sqlite.test"}, spanNames(recorder.Ended()))
+}
@@ -0,0 +1,122 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracedConnPool traces the BEGIN of the transactions started by db.Begin / db.Transaction and the TransactionManager.
+type tracedConnPool struct {
+	gorm.ConnPool
+	op      *GormOpenTelemetryPlugin
+	dialect string
+}
+
+// BeginTx implements gorm.ConnPoolBeginner.
+func (p *tracedConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	span := p.op.startTx(ctx, p.dialect, opBegin)
+
+	var (
+		tx  gorm.ConnPool
+		err error
+	)
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		var sqlTx *sql.Tx
+		if sqlTx, err = beginner.BeginTx(ctx, opts); err == nil {
+			tx = sqlTx
+		}
+	case gorm.ConnPoolBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	default:
+		err = gorm.ErrInvalidTransaction
+	}
+	endTx(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracedTx{ConnPool: tx, pool: p, ctx: ctx}, nil
+}
+
+// GetDBConn implements gorm.GetDBConnector, so db.DB() still returns the *sql.DB.
+func (p *tracedConnPool) GetDBConn() (*sql.DB, error) {
+	switch pool := p.ConnPool.(type) {
+	case *sql.DB:
+		return pool, nil
+	case gorm.GetDBConnector:
+		return pool.GetDBConn()
+	default:
+		return nil, gorm.ErrInvalidDB
+	}
+}
+
+// tracedTx traces the COMMIT / ROLLBACK of a transaction, as siblings of its BEGIN.
+type tracedTx struct {
+	gorm.ConnPool
+	pool *tracedConnPool
+	ctx  context.Context // BeginTx 的 ctx
+	done bool            // 已提交或回滚
+}
+
+func (t *tracedTx) Commit() error {
+	return t.finish(opCommit, t.ConnPool.(gorm.TxCommitter).Commit)
+}
+
+func (t *tracedTx) Rollback() error {
+	return t.finish(opRollback, t.ConnPool.(gorm.TxCommitter).Rollback)
+}
+
+// finish a rollback after the transaction is done, e.g. a deferred Rollback, is not traced.
+func (t *tracedTx) finish(operation string, fn func() error) error {
+	if t.done {
+		return fn()
+	}
+
+	span := t.pool.op.startTx(t.ctx, t.pool.dialect, operation)
+	err := fn()
+	endTx(span, err)
+	t.done = err == nil
+	return err
+}
+
+// StmtContext implements gorm.Tx for the prepared statements.
+func (t *tracedTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if tx, ok := t.ConnPool.(gorm.Tx); ok {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// GetDBConn implements gorm.GetDBConnector.
+func (t *tracedTx) GetDBConn() (*sql.DB, error) {
+	return t.pool.GetDBConn()
+}
+
+func (op *GormOpenTelemetryPlugin) startTx(ctx context.Context, dialect, operation string) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !op.sampled(ctx, "", operation) {
+		return nil
+	}
+
+	_, span := op.tracer.Start(ctx, op.name(dialect, "", operation), trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(dbOperation(operation))
+	span.SetAttributes(op.commonAttributes(dialect)...)
+	return span
+}
+
+func endTx(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -2,16 +2,23 @@ package orm_test
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/glebarez/sqlite"
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 
 	"github.com/omalloc/contrib/kratos/orm"
 )
 
+var errRollback = errors.New("rollback")
+
 type Data struct {
 	db *gorm.DB
 }
@@ -37,10 +44,39 @@ func newData() *Data {
 	}
 }
 
+// newTestData returns a Data backed by a private in-memory database named after the test.
+func newTestData(t *testing.T) *Data {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Data{
+		db: db,
+	}
+}
+
 func (d *Data) GetDataSource() *gorm.DB {
 	return d.db
 }
 
+func countUsers(t *testing.T, data *Data) int64 {
+	t.Helper()
+
+	var n int64
+	if err := data.db.Model(&User{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
 func TestTransaction(t *testing.T) {
 	data := newData()
 	data.db.AutoMigrate(&User{})
@@ -55,3 +91,349 @@ func TestTransaction(t *testing.T) {
 		return nil
 	})
 }
+
+func TestTransactionRequired(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := txm.WithContext(ctx).Create(&User{Name: "outer"}).Error; err != nil {
+			return err
+		}
+		// joins the outer transaction, the failure rolls back the whole unit.
+		_ = txm.Transaction(ctx, func(ctx context.Context) error {
+			return txm.WithContext(ctx).Create(&User{Name: "inner"}).Error
+		})
+		return errRollback
+	})
+
+	assert.ErrorIs(t, err, errRollback)
+	assert.Equal(t, int64(0), countUsers(t, data))
+}
+
+func TestTransactionRequiresNew(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		err := txm.Transaction(ctx, func(ctx context.Context) error {
+			return txm.WithContext(ctx).Create(&User{Name: "inner"}).Error
+		}, orm.WithPropagation(orm.PropagationRequiresNew))
+		if err != nil {
+			return err
+		}
+		if err := txm.WithContext(ctx).Create(&User{Name: "outer"}).Error; err != nil {
+			return err
+		}
+		return errRollback
+	})
+
+	assert.ErrorIs(t, err, errRollback)
+
+	var users []User
+	assert.NoError(t, data.db.Find(&users).Error)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "inner", users[0].Name)
+	}
+}
+
+func TestTransactionNested(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := txm.WithContext(ctx).Create(&User{Name: "outer"}).Error; err != nil {
+			return err
+		}
+		// rolled back to the savepoint only.
+		err := txm.Transaction(ctx, func(ctx context.Context) error {
+			if err := txm.WithContext(ctx).Create(&User{Name: "inner"}).Error; err != nil {
+				return err
+			}
+			return errRollback
+		}, orm.WithPropagation(orm.PropagationNested))
+		assert.ErrorIs(t, err, errRollback)
+		return nil
+	})
+
+	assert.NoError(t, err)
+
+	var users []User
+	assert.NoError(t, data.db.Find(&users).Error)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "outer", users[0].Name)
+	}
+}
+
+func TestTransactionSupports(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	// no transaction in ctx, runs non-transactionally.
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := txm.WithContext(ctx).Create(&User{Name: "supports"}).Error; err != nil {
+			return err
+		}
+		return errRollback
+	}, orm.WithPropagation(orm.PropagationSupports))
+
+	assert.ErrorIs(t, err, errRollback)
+	assert.Equal(t, int64(1), countUsers(t, data))
+}
+
+func TestTransactionNotSupported(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		err := txm.Transaction(ctx, func(ctx context.Context) error {
+			return txm.WithContext(ctx).Create(&User{Name: "suspended"}).Error
+		}, orm.WithPropagation(orm.PropagationNotSupported))
+		if err != nil {
+			return err
+		}
+		return errRollback
+	})
+
+	assert.ErrorIs(t, err, errRollback)
+	assert.Equal(t, int64(1), countUsers(t, data))
+}
+
+func TestTransactionNever(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, orm.WithPropagation(orm.PropagationNever))
+	assert.NoError(t, err)
+
+	err = txm.Transaction(context.Background(), func(ctx context.Context) error {
+		return txm.Transaction(ctx, func(ctx context.Context) error {
+			return nil
+		}, orm.WithPropagation(orm.PropagationNever))
+	})
+	assert.ErrorIs(t, err, orm.ErrTransactionExists)
+}
+
+func TestTransactionSQLOptions(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	assert.NoError(t, data.db.Create(&User{Name: "test"}).Error)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		var user User
+		return txm.WithContext(ctx).Take(&user).Error
+	}, orm.WithIsolation(sql.LevelSerializable), orm.WithReadOnly())
+	assert.NoError(t, err)
+}
+
+func TestTransactionHooks(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	var calls []string
+	record := func(name string) func(context.Context) {
+		return func(context.Context) {
+			calls = append(calls, name)
+		}
+	}
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		orm.AfterCommit(ctx, record("commit-1"))
+		orm.AfterRollback(ctx, record("rollback-1"))
+		orm.AfterCommit(ctx, func(context.Context) {
+			panic("recovered")
+		})
+
+		// joined transaction, hooks belong to the outermost one.
+		_ = txm.Transaction(ctx, func(ctx context.Context) error {
+			orm.AfterCommit(ctx, record("commit-2"))
+			return nil
+		})
+
+		// savepoint rolled back, its commit hook is dropped.
+		_ = txm.Transaction(ctx, func(ctx context.Context) error {
+			orm.AfterCommit(ctx, record("nested-commit"))
+			orm.AfterRollback(ctx, record("nested-rollback"))
+			return errRollback
+		}, orm.WithPropagation(orm.PropagationNested))
+
+		assert.Empty(t, calls)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"commit-1", "commit-2", "nested-rollback"}, calls)
+
+	calls = nil
+	err = txm.Transaction(context.Background(), func(ctx context.Context) error {
+		orm.AfterCommit(ctx, record("commit"))
+		orm.AfterRollback(ctx, record("rollback"))
+		return errRollback
+	})
+
+	assert.ErrorIs(t, err, errRollback)
+	assert.Equal(t, []string{"rollback"}, calls)
+}
+
+func TestTransactionHooksWithoutTransaction(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	var calls []string
+	record := func(name string) func(context.Context) {
+		return func(context.Context) {
+			calls = append(calls, name)
+		}
+	}
+
+	// AfterCommit runs right away, AfterRollback is ignored.
+	orm.AfterCommit(context.Background(), record("commit"))
+	orm.AfterRollback(context.Background(), record("rollback"))
+	assert.Equal(t, []string{"commit"}, calls)
+
+	// nor is it run by a later transaction on the same ctx.
+	calls = nil
+	ctx := context.Background()
+	orm.AfterRollback(ctx, record("before"))
+	err := txm.Transaction(ctx, func(ctx context.Context) error {
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+	assert.Empty(t, calls)
+
+	// a suspended transaction is no transaction.
+	err = txm.Transaction(ctx, func(ctx context.Context) error {
+		return txm.Transaction(ctx, func(ctx context.Context) error {
+			orm.AfterRollback(ctx, record("suspended"))
+			return nil
+		}, orm.WithPropagation(orm.PropagationNotSupported))
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+}
+
+func TestNewTxContext(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data)
+
+	var calls []string
+	record := func(name string) func(context.Context) {
+		return func(context.Context) {
+			calls = append(calls, name)
+		}
+	}
+
+	ctx, finish := orm.NewTxContext(context.Background(), nil)
+	tx, ok := orm.FromContext(ctx)
+	assert.True(t, ok)
+	assert.Nil(t, tx)
+	// without a *gorm.DB the TransactionManager falls back to the data source.
+	assert.NotNil(t, txm.WithContext(ctx))
+
+	orm.AfterCommit(ctx, record("commit"))
+	orm.AfterRollback(ctx, record("rollback"))
+	assert.Empty(t, calls)
+	finish(true)
+	assert.Equal(t, []string{"commit"}, calls)
+
+	calls = nil
+	ctx, finish = orm.NewTxContext(context.Background(), nil)
+	orm.AfterCommit(ctx, record("commit"))
+	orm.AfterRollback(ctx, record("rollback"))
+	finish(false)
+	assert.Equal(t, []string{"rollback"}, calls)
+
+	// a nested transaction joins the one without a *gorm.DB.
+	calls = nil
+	ctx, finish = orm.NewTxContext(context.Background(), nil)
+	err := txm.Transaction(ctx, func(ctx context.Context) error {
+		orm.AfterCommit(ctx, record("nested"))
+		return nil
+	}, orm.WithPropagation(orm.PropagationNested))
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+	finish(true)
+	assert.Equal(t, []string{"nested"}, calls)
+}
+
+// busyError mimics a sqlite error carrying the SQLITE_BUSY result code.
+type busyError struct{}
+
+func (busyError) Error() string { return "database is locked (5) (SQLITE_BUSY)" }
+func (busyError) Code() int     { return 5 }
+
+func TestTransactionRetry(t *testing.T) {
+	data := newTestData(t)
+	txm := orm.NewTransactionManager(data, orm.WithRetry(orm.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     orm.ExponentialBackoff(time.Millisecond, 10*time.Millisecond),
+	}))
+
+	var (
+		attempts int
+		calls    []string
+	)
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		orm.AfterCommit(ctx, func(context.Context) {
+			calls = append(calls, "commit")
+		})
+		if err := txm.WithContext(ctx).Create(&User{Name: "retry"}).Error; err != nil {
+			return err
+		}
+		if attempts < 2 {
+			return busyError{}
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []string{"commit"}, calls)
+	assert.Equal(t, int64(1), countUsers(t, data))
+
+	// not retryable.
+	attempts = 0
+	err = txm.Transaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+	assert.Equal(t, 1, attempts)
+
+	// gives up after MaxAttempts.
+	attempts = 0
+	err = txm.Transaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return busyError{}
+	})
+	assert.ErrorIs(t, err, busyError{})
+	assert.Equal(t, 3, attempts)
+
+	// a joined transaction is never retried on its own.
+	attempts = 0
+	err = txm.Transaction(context.Background(), func(ctx context.Context) error {
+		err := txm.Transaction(ctx, func(ctx context.Context) error {
+			attempts++
+			return busyError{}
+		})
+		if attempts < 2 {
+			return err
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, orm.IsRetryable(&mysql.MySQLError{Number: 1213}))
+	assert.True(t, orm.IsRetryable(fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1205})))
+	assert.False(t, orm.IsRetryable(&mysql.MySQLError{Number: 1062}))
+	assert.True(t, orm.IsRetryable(busyError{}))
+	assert.False(t, orm.IsRetryable(gorm.ErrRecordNotFound))
+	assert.False(t, orm.IsRetryable(nil))
+}
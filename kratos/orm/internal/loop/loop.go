@@ -0,0 +1,111 @@
+// Package loop runs the periodic tasks of the transport.Server implementations, e.g. outbox.Relay and crud.Purger.
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStarted = errors.New("already started")
+)
+
+// Task is run every Interval, one task at a time.
+type Task struct {
+	Interval time.Duration
+	Run      func(ctx context.Context)
+}
+
+// Loop runs once, Start returns ErrStarted while it is running and returns at once after Stop.
+// the zero Loop is ready to use.
+type Loop struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped bool
+}
+
+// Start runs the tasks until Stop is called or ctx is done.
+func (l *Loop) Start(ctx context.Context, tasks ...Task) error {
+	for _, t := range tasks {
+		if t.Interval <= 0 {
+			return fmt.Errorf("non-positive interval %s", t.Interval)
+		}
+	}
+
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return nil
+	}
+	if l.done != nil {
+		l.mu.Unlock()
+		return ErrStarted
+	}
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	l.mu.Unlock()
+
+	defer close(l.done)
+
+	if len(tasks) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	now := time.Now()
+	next := make([]time.Time, len(tasks))
+	for i, t := range tasks {
+		next[i] = now.Add(t.Interval)
+	}
+
+	timer := time.NewTimer(tasks[0].Interval)
+	defer timer.Stop()
+
+	for {
+		i := 0
+		for j := range next {
+			if next[j].Before(next[i]) {
+				i = j
+			}
+		}
+		timer.Reset(time.Until(next[i]))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		tasks[i].Run(ctx)
+		// the ticks missed by a slow task are dropped as time.Ticker does.
+		next[i] = next[i].Add(tasks[i].Interval)
+		if now := time.Now(); next[i].Before(now) {
+			next[i] = now.Add(tasks[i].Interval)
+		}
+	}
+}
+
+// Stop cancels the tasks and waits for the running one to finish.
+// a Loop stopped before it is started never runs.
+func (l *Loop) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	l.stopped = true
+	cancel, done := l.cancel, l.done
+	l.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package loop_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/contrib/kratos/orm/internal/loop"
+)
+
+func TestLoop(t *testing.T) {
+	var (
+		l          loop.Loop
+		fast, slow atomic.Int32
+	)
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Start(ctx,
+			loop.Task{Interval: 5 * time.Millisecond, Run: func(context.Context) { fast.Add(1) }},
+			loop.Task{Interval: time.Hour, Run: func(context.Context) { slow.Add(1) }},
+		)
+	}()
+	assert.Eventually(t, func() bool {
+		return fast.Load() >= 3
+	}, time.Second, 5*time.Millisecond)
+	assert.Zero(t, slow.Load())
+	assert.ErrorIs(t, l.Start(ctx), loop.ErrStarted)
+
+	assert.NoError(t, l.Stop(ctx))
+	assert.NoError(t, <-done)
+
+	// a stopped Loop does not run again.
+	assert.NoError(t, l.Start(ctx, loop.Task{Interval: time.Millisecond, Run: func(context.Context) { slow.Add(1) }}))
+	assert.Zero(t, slow.Load())
+}
+
+func TestLoopStopBeforeStart(t *testing.T) {
+	var l loop.Loop
+	ctx := context.Background()
+
+	assert.NoError(t, l.Stop(ctx))
+	assert.NoError(t, l.Start(ctx, loop.Task{Interval: time.Millisecond, Run: func(context.Context) {
+		t.Error("the task of a stopped loop runs")
+	}}))
+}
+
+func TestLoopInvalidInterval(t *testing.T) {
+	var l loop.Loop
+	assert.Error(t, l.Start(context.Background(), loop.Task{Run: func(context.Context) {}}))
+}
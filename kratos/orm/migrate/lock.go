@@ -0,0 +1,69 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLocked = errors.New("migrate: locked by another runner")
+)
+
+const lockID = 1
+
+// lockRecord the single row of the lock table, the primary key makes a second insert fail.
+type lockRecord struct {
+	ID       int       `gorm:"column:id;primaryKey;autoIncrement:false;"`
+	Owner    string    `gorm:"column:owner;size:255;"`
+	LockedAt time.Time `gorm:"column:locked_at;"`
+}
+
+// lock guards concurrent runners with a row in the lock table, works on every dialect.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	db := m.db.WithContext(ctx)
+	if err := db.Table(m.lockTable).AutoMigrate(&lockRecord{}); err != nil {
+		return nil, err
+	}
+
+	if m.lockTimeout > 0 {
+		// take over the stale lock left by a crashed runner.
+		err := db.Table(m.lockTable).
+			Where("id = ? AND locked_at < ?", lockID, time.Now().Add(-m.lockTimeout)).
+			Delete(&lockRecord{}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	owner := lockOwner()
+	err := db.Table(m.lockTable).Create(&lockRecord{
+		ID:       lockID,
+		Owner:    owner,
+		LockedAt: time.Now(),
+	}).Error
+	if err != nil {
+		var held lockRecord
+		if db.Table(m.lockTable).Where("id = ?", lockID).Take(&held).Error == nil {
+			return nil, fmt.Errorf("%w: %s since %s", ErrLocked, held.Owner, held.LockedAt.Format(time.RFC3339))
+		}
+		return nil, err
+	}
+
+	return func() {
+		// released on a fresh context, the migration ctx may have been canceled.
+		m.db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).
+			Table(m.lockTable).
+			Where("id = ? AND owner = ?", lockID, owner).
+			Delete(&lockRecord{})
+	}, nil
+}
+
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
@@ -0,0 +1,315 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	glog "gorm.io/gorm/logger"
+)
+
+var (
+	ErrDuplicateVersion = errors.New("migrate: duplicate migration version")
+	ErrNoChange         = errors.New("migrate: no change")
+	ErrIrreversible     = errors.New("migrate: migration has no down")
+)
+
+// Migration a versioned schema change, either Go functions or plain SQL.
+//
+// Up / Down take precedence over UpSQL / DownSQL when both are set.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+	UpSQL   string
+	DownSQL string
+}
+
+// Record an applied migration.
+type Record struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false;"`
+	Name      string    `gorm:"column:name;size:255;"`
+	AppliedAt time.Time `gorm:"column:applied_at;"`
+}
+
+// Status the state of a registered migration.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db          *gorm.DB
+	migrations  []*Migration
+	table       string
+	lockTable   string
+	lockTimeout time.Duration
+	dryRun      io.Writer
+}
+
+type Option func(*Migrator)
+
+// WithTable set the table tracking the applied versions. default is schema_migrations.
+func WithTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+		m.lockTable = table + "_lock"
+	}
+}
+
+// WithLockTimeout a lock held longer than timeout is considered stale and taken over. 0 never.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = timeout
+	}
+}
+
+// WithDryRun print the SQL to w instead of executing it.
+func WithDryRun(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.dryRun = w
+	}
+}
+
+func New(db *gorm.DB, opts ...Option) *Migrator {
+	m := &Migrator{
+		db:          db,
+		table:       "schema_migrations",
+		lockTable:   "schema_migrations_lock",
+		lockTimeout: 0,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Register adds migrations, they are applied in version order.
+func (m *Migrator) Register(migrations ...*Migration) error {
+	for _, mi := range migrations {
+		for _, exist := range m.migrations {
+			if exist.Version == mi.Version {
+				return fmt.Errorf("%w: %d", ErrDuplicateVersion, mi.Version)
+			}
+		}
+		m.migrations = append(m.migrations, mi)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies the pending migrations up to and including version, 0 means all.
+func (m *Migrator) UpTo(ctx context.Context, version int64) error {
+	return m.run(ctx, func(applied map[int64]*Record) error {
+		var pending []*Migration
+		for _, mi := range m.migrations {
+			if _, ok := applied[mi.Version]; ok {
+				continue
+			}
+			if version > 0 && mi.Version > version {
+				break
+			}
+			pending = append(pending, mi)
+		}
+		if len(pending) == 0 {
+			return ErrNoChange
+		}
+
+		for _, mi := range pending {
+			if err := m.apply(ctx, mi, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.run(ctx, func(applied map[int64]*Record) error {
+		var rollback []*Migration
+		for i := len(m.migrations) - 1; i >= 0 && len(rollback) < steps; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				rollback = append(rollback, m.migrations[i])
+			}
+		}
+		if len(rollback) == 0 {
+			return ErrNoChange
+		}
+
+		for _, mi := range rollback {
+			if err := m.apply(ctx, mi, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns the state of every registered migration in version order.
+// it is read-only, every migration is pending before the version table is created.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.existing(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Status, 0, len(m.migrations))
+	for _, mi := range m.migrations {
+		s := &Status{Version: mi.Version, Name: mi.Name}
+		if r, ok := applied[mi.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.AppliedAt
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// run prepares the version table and holds the lock while fn runs.
+// dry-run neither creates the table nor takes the lock.
+func (m *Migrator) run(ctx context.Context, fn func(applied map[int64]*Record) error) error {
+	if m.dryRun != nil {
+		applied, err := m.existing(ctx)
+		if err != nil {
+			return err
+		}
+		return fn(applied)
+	}
+
+	if err := m.db.WithContext(ctx).Table(m.table).AutoMigrate(&Record{}); err != nil {
+		return err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(applied)
+}
+
+// existing reads the applied versions without creating the version table.
+func (m *Migrator) existing(ctx context.Context) (map[int64]*Record, error) {
+	if !m.db.WithContext(ctx).Migrator().HasTable(m.table) {
+		return make(map[int64]*Record), nil
+	}
+	return m.applied(ctx)
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]*Record, error) {
+	var records []*Record
+	if err := m.db.WithContext(ctx).Table(m.table).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]*Record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// apply runs a single migration and records its version in the same transaction.
+func (m *Migrator) apply(ctx context.Context, mi *Migration, up bool) error {
+	fn, rawSQL := mi.Up, mi.UpSQL
+	if !up {
+		fn, rawSQL = mi.Down, mi.DownSQL
+	}
+	if !up && fn == nil && rawSQL == "" {
+		return fmt.Errorf("%w: %d_%s", ErrIrreversible, mi.Version, mi.Name)
+	}
+
+	if m.dryRun != nil {
+		return m.print(ctx, mi, up, fn, rawSQL)
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := execute(tx, fn, rawSQL); err != nil {
+			return err
+		}
+
+		if up {
+			return tx.Table(m.table).Create(&Record{
+				Version:   mi.Version,
+				Name:      mi.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}
+		return tx.Table(m.table).Where("version = ?", mi.Version).Delete(&Record{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: %d_%s: %w", mi.Version, mi.Name, err)
+	}
+	return nil
+}
+
+func execute(tx *gorm.DB, fn func(tx *gorm.DB) error, rawSQL string) error {
+	if fn != nil {
+		return fn(tx)
+	}
+
+	for _, stmt := range splitStatements(rawSQL) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print writes the SQL of a migration, Go migrations run on a DryRun session and their statements are recorded.
+func (m *Migrator) print(ctx context.Context, mi *Migration, up bool, fn func(tx *gorm.DB) error, rawSQL string) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	_, _ = fmt.Fprintf(m.dryRun, "-- %d_%s.%s\n", mi.Version, mi.Name, direction)
+
+	if fn == nil {
+		for _, stmt := range splitStatements(rawSQL) {
+			_, _ = fmt.Fprintf(m.dryRun, "%s;\n", stmt)
+		}
+		return nil
+	}
+
+	tx := m.db.Session(&gorm.Session{
+		DryRun:  true,
+		Context: ctx,
+		Logger:  &recorder{w: m.dryRun},
+	})
+	return fn(tx)
+}
+
+// recorder a gorm logger writing the traced SQL.
+type recorder struct {
+	w io.Writer
+}
+
+func (r *recorder) LogMode(glog.LogLevel) glog.Interface          { return r }
+func (r *recorder) Info(context.Context, string, ...interface{})  {}
+func (r *recorder) Warn(context.Context, string, ...interface{})  {}
+func (r *recorder) Error(context.Context, string, ...interface{}) {}
+func (r *recorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	_, _ = fmt.Fprintf(r.w, "%s;\n", sql)
+}
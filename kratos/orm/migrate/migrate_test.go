@@ -0,0 +1,221 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/migrate"
+)
+
+type Article struct {
+	ID    int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Title string `gorm:"column:title;"`
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newMigrator(t *testing.T, db *gorm.DB, opts ...migrate.Option) *migrate.Migrator {
+	t.Helper()
+
+	fsys := fstest.MapFS{
+		"migrations/2_add_summary.up.sql": {Data: []byte(`
+-- add a column with a default containing a semicolon
+ALTER TABLE articles ADD COLUMN summary TEXT DEFAULT 'a;b';
+UPDATE articles SET summary = title;
+`)},
+		"migrations/2_add_summary.down.sql": {Data: []byte("ALTER TABLE articles DROP COLUMN summary;")},
+	}
+	migrations, err := migrate.LoadFS(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := migrate.New(db, opts...)
+	err = m.Register(append(migrations, &migrate.Migration{
+		Version: 1,
+		Name:    "create_articles",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&Article{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&Article{})
+		},
+	})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMigrator(t *testing.T) {
+	db := newTestDB(t)
+	m := newMigrator(t, db)
+	ctx := context.Background()
+
+	// status does not create the version and lock tables.
+	list, err := m.Status(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.False(t, list[0].Applied)
+	}
+	assert.False(t, db.Migrator().HasTable("schema_migrations"))
+	assert.False(t, db.Migrator().HasTable("schema_migrations_lock"))
+
+	assert.NoError(t, m.UpTo(ctx, 1))
+	assert.NoError(t, db.Create(&Article{Title: "hello"}).Error)
+
+	assert.NoError(t, m.Up(ctx))
+	assert.ErrorIs(t, m.Up(ctx), migrate.ErrNoChange)
+
+	var summary string
+	assert.NoError(t, db.Table("articles").Select("summary").Take(&summary).Error)
+	assert.Equal(t, "hello", summary)
+
+	list, err = m.Status(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, int64(1), list[0].Version)
+		assert.True(t, list[0].Applied)
+		assert.Equal(t, "add_summary", list[1].Name)
+		assert.True(t, list[1].Applied)
+	}
+
+	assert.NoError(t, m.Down(ctx, 1))
+	assert.False(t, db.Migrator().HasColumn("articles", "summary"))
+
+	assert.NoError(t, m.Down(ctx, 1))
+	assert.False(t, db.Migrator().HasTable("articles"))
+	assert.ErrorIs(t, m.Down(ctx, 1), migrate.ErrNoChange)
+}
+
+func TestMigratorDuplicateVersion(t *testing.T) {
+	m := migrate.New(newTestDB(t))
+	assert.NoError(t, m.Register(&migrate.Migration{Version: 1, Name: "a"}))
+	assert.ErrorIs(t, m.Register(&migrate.Migration{Version: 1, Name: "b"}), migrate.ErrDuplicateVersion)
+}
+
+func TestMigratorLock(t *testing.T) {
+	db := newTestDB(t)
+	m := newMigrator(t, db)
+
+	// a migration holding the lock blocks the second runner.
+	blocked := migrate.New(db)
+	_ = blocked.Register(&migrate.Migration{
+		Version: 3,
+		Name:    "concurrent",
+		Up: func(tx *gorm.DB) error {
+			assert.ErrorIs(t, m.Up(context.Background()), migrate.ErrLocked)
+			return nil
+		},
+	})
+	assert.NoError(t, blocked.Up(context.Background()))
+
+	// released after the run.
+	assert.NoError(t, m.Up(context.Background()))
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	db := newTestDB(t)
+	out := &bytes.Buffer{}
+	m := newMigrator(t, db, migrate.WithDryRun(out))
+
+	assert.NoError(t, m.Up(context.Background()))
+	assert.False(t, db.Migrator().HasTable("articles"))
+	assert.False(t, db.Migrator().HasTable("schema_migrations"))
+
+	sql := out.String()
+	assert.Contains(t, sql, "-- 1_create_articles.up")
+	assert.Contains(t, sql, "CREATE TABLE `articles`")
+	assert.Contains(t, sql, "-- 2_add_summary.up")
+	assert.Contains(t, sql, "ALTER TABLE articles ADD COLUMN summary TEXT DEFAULT 'a;b';")
+	assert.Contains(t, sql, "UPDATE articles SET summary = title;")
+}
+
+func TestMigratorStatementBlock(t *testing.T) {
+	db := newTestDB(t)
+	fsys := fstest.MapFS{
+		"migrations/1_create_counters.up.sql": {Data: []byte(`
+/* the counters; bumped by the trigger */
+CREATE TABLE counters (name TEXT PRIMARY KEY, hits INTEGER NOT NULL DEFAULT 0);
+CREATE TABLE visits (name TEXT);
+
+-- +migrate StatementBegin
+CREATE TRIGGER count_visits AFTER INSERT ON visits
+BEGIN
+	INSERT OR IGNORE INTO counters (name) VALUES (NEW.name);
+	UPDATE counters SET hits = hits + 1 WHERE name = NEW.name;
+END;
+-- +migrate StatementEnd
+
+INSERT INTO visits (name) VALUES ('it''s; /* not a comment */');
+/* a trailing comment is not run as a statement */
+`)},
+	}
+	migrations, err := migrate.LoadFS(fsys, "migrations")
+	assert.NoError(t, err)
+
+	m := migrate.New(db)
+	assert.NoError(t, m.Register(migrations...))
+	assert.NoError(t, m.Up(context.Background()))
+
+	var hits int
+	assert.NoError(t, db.Table("counters").Where("name = ?", "it's; /* not a comment */").Select("hits").Take(&hits).Error)
+	assert.Equal(t, 1, hits)
+
+	// the mysqldump forms, printed as they would be run on MySQL.
+	fsys = fstest.MapFS{
+		"migrations/2_mysqldump.up.sql": {Data: []byte(`
+/*!40101 SET NAMES utf8 */;
+# the dump; of a table
+CREATE TABLE a (id int);
+SELECT 'a\'b;c', "d\"e;f";
+SELECT 1 # 2;
+`)},
+	}
+	migrations, err = migrate.LoadFS(fsys, "migrations")
+	assert.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	m = migrate.New(db, migrate.WithDryRun(out))
+	assert.NoError(t, m.Register(migrations...))
+	assert.NoError(t, m.Up(context.Background()))
+	assert.Equal(t, "-- 2_mysqldump.up\n"+
+		"/*!40101 SET NAMES utf8 */;\n"+
+		"CREATE TABLE a (id int);\n"+
+		`SELECT 'a\'b;c', "d\"e;f";`+"\n"+
+		"SELECT 1 # 2;\n", out.String())
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := migrate.Create(dir, "add users")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(up, "_add_users.up.sql"))
+	assert.True(t, strings.HasSuffix(down, "_add_users.down.sql"))
+
+	migrations, err := migrate.LoadFS(os.DirFS(dir), ".")
+	assert.NoError(t, err)
+	if assert.Len(t, migrations, 1) {
+		assert.Equal(t, "add_users", migrations[0].Name)
+	}
+}
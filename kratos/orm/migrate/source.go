@@ -0,0 +1,185 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// sqlFilePattern <version>_<name>.(up|down).sql
+var sqlFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadFS reads the SQL migrations named <version>_<name>.up.sql / <version>_<name>.down.sql from dir of fsys.
+func LoadFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := sqlFilePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version of %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mi, ok := migrations[version]
+		if !ok {
+			mi = &Migration{Version: version, Name: matches[2]}
+			migrations[version] = mi
+		} else if mi.Name != matches[2] {
+			return nil, fmt.Errorf("%w: %d (%s, %s)", ErrDuplicateVersion, version, mi.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			mi.UpSQL = string(body)
+		} else {
+			mi.DownSQL = string(body)
+		}
+	}
+
+	list := make([]*Migration, 0, len(migrations))
+	for _, mi := range migrations {
+		list = append(list, mi)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// Create writes an empty pair of up / down SQL files to dir, versioned by the current time.
+func Create(dir, name string) (up string, down string, err error) {
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+
+	name = strings.ReplaceAll(strings.TrimSpace(name), " ", "_")
+	version := time.Now().UTC().Format("20060102150405")
+	up = filepath.Join(dir, fmt.Sprintf("%s_%s.up.sql", version, name))
+	down = filepath.Join(dir, fmt.Sprintf("%s_%s.down.sql", version, name))
+
+	for _, file := range []string{up, down} {
+		if err = os.WriteFile(file, nil, 0o644); err != nil {
+			return "", "", err
+		}
+	}
+	return up, down, nil
+}
+
+const (
+	// statementBegin and statementEnd enclose a statement with semicolons in its body, e.g. a trigger or procedure.
+	statementBegin = "+migrate StatementBegin"
+	statementEnd   = "+migrate StatementEnd"
+)
+
+// splitStatements splits a SQL script on the semicolons outside of quotes and comments,
+// so it runs on drivers without multi statements support.
+//
+// the -- comments and the # comments starting a line (MySQL) are dropped, a \ escapes the next character in quotes.
+// the block comments are kept, a /*! ... */ of MySQL is run as a statement of its own. a BEGIN ... END body
+// must be enclosed by the lines `-- +migrate StatementBegin` and `-- +migrate StatementEnd` to run as one statement.
+func splitStatements(script string) []string {
+	var (
+		list  []string
+		sb    strings.Builder
+		quote rune
+		block bool   // in /* */
+		whole bool   // between StatementBegin and StatementEnd
+		empty = true // only comments so far, not sent to the driver
+		line  = true // only spaces since the last newline
+	)
+	flush := func() {
+		if !empty {
+			list = append(list, strings.TrimSpace(sb.String()))
+		}
+		sb.Reset()
+		empty = true
+	}
+
+	runes := []rune(script)
+	next := func(i int, r rune) bool {
+		return i+1 < len(runes) && runes[i+1] == r
+	}
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		start := line
+		line = c == '\n' || line && unicode.IsSpace(c)
+
+		switch {
+		case block:
+			if c == '*' && next(i, '/') {
+				block = false
+				sb.WriteString("*/")
+				i++
+				continue
+			}
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(runes) {
+				sb.WriteRune(c)
+				i++
+				c = runes[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '/' && next(i, '*'):
+			block = true
+			// the executable comment of MySQL is a statement.
+			if next(i+1, '!') {
+				empty = false
+			}
+			sb.WriteString("/*")
+			i++
+			continue
+		case c == '-' && next(i, '-'), c == '#' && start:
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			if c == '-' {
+				switch strings.TrimSpace(string(runes[i+2 : end])) {
+				case statementBegin:
+					flush()
+					whole = true
+				case statementEnd:
+					flush()
+					whole = false
+				}
+			}
+			// the newline is kept.
+			i = end - 1
+			continue
+		case c == ';' && !whole:
+			flush()
+			continue
+		}
+		if !block && !unicode.IsSpace(c) {
+			empty = false
+		}
+		sb.WriteRune(c)
+	}
+	flush()
+	return list
+}
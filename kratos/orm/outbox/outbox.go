@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+var (
+	ErrNoTransaction = errors.New("outbox: no transaction in context")
+)
+
+// Message a row of the outbox table, written in the same transaction as the business data.
+type Message struct {
+	ID          int64      `json:"id" gorm:"column:id;primaryKey;autoIncrement;"`
+	Topic       string     `json:"topic" gorm:"column:topic;size:255;comment:消息主题"`
+	Payload     []byte     `json:"payload" gorm:"column:payload;comment:消息内容"`
+	Attempts    int        `json:"attempts" gorm:"column:attempts;default:0;comment:投递次数"`
+	LastError   string     `json:"last_error" gorm:"column:last_error;size:1024;comment:最后一次投递错误"`
+	DeliveredAt *time.Time `json:"delivered_at" gorm:"column:delivered_at;index;comment:投递时间"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"column:created_at;comment:创建时间"`
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// Publisher delivers outbox messages to the message broker.
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// Add writes a message to the outbox with the transaction in ctx,
+// so it is only visible to the Relay once the transaction has been committed.
+//
+// ctx must be one given by orm.Transaction, or ErrNoTransaction is returned.
+func Add(ctx context.Context, topic string, payload []byte) error {
+	tx, ok := orm.FromContext(ctx)
+	if !ok || tx == nil {
+		return ErrNoTransaction
+	}
+
+	return tx.Create(&Message{
+		Topic:   topic,
+		Payload: payload,
+	}).Error
+}
@@ -0,0 +1,194 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/outbox"
+)
+
+type data struct {
+	db *gorm.DB
+}
+
+func (d *data) GetDataSource() *gorm.DB {
+	return d.db
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:" + t.Name() + "?mode=memory&cache=shared")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&outbox.Message{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAdd(t *testing.T) {
+	db := newTestDB(t)
+	txm := orm.NewTransactionManager(&data{db: db})
+
+	assert.ErrorIs(t, outbox.Add(context.Background(), "topic", nil), outbox.ErrNoTransaction)
+
+	// rolled back with the transaction.
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := outbox.Add(ctx, "topic", []byte("rollback")); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.Error(t, err)
+
+	err = txm.Transaction(context.Background(), func(ctx context.Context) error {
+		return outbox.Add(ctx, "topic", []byte("commit"))
+	})
+	assert.NoError(t, err)
+
+	var messages []*outbox.Message
+	assert.NoError(t, db.Find(&messages).Error)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "commit", string(messages[0].Payload))
+	}
+}
+
+func TestRelay(t *testing.T) {
+	db := newTestDB(t)
+	txm := orm.NewTransactionManager(&data{db: db})
+	pub := outbox.NewMemoryPublisher()
+	relay := outbox.NewRelay(db, pub, outbox.WithRetry(1, time.Millisecond), outbox.WithRetention(time.Hour, time.Hour))
+	ctx := context.Background()
+
+	err := txm.Transaction(ctx, func(ctx context.Context) error {
+		for _, payload := range []string{"1", "2", "3"} {
+			if err := outbox.Add(ctx, "topic", []byte(payload)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// failed messages stay in the outbox with the attempts recorded.
+	pub.SetError(errors.New("broker unavailable"))
+	n, err := relay.Relay(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	var first outbox.Message
+	assert.NoError(t, db.Order("id").Take(&first).Error)
+	assert.Equal(t, 2, first.Attempts)
+	assert.Equal(t, "broker unavailable", first.LastError)
+	assert.Nil(t, first.DeliveredAt)
+
+	pub.SetError(nil)
+	n, err = relay.Relay(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	published := pub.Messages()
+	if assert.Len(t, published, 3) {
+		for i, payload := range []string{"1", "2", "3"} {
+			assert.Equal(t, payload, string(published[i].Payload))
+		}
+	}
+
+	n, err = relay.Relay(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// delivered messages are kept until the retention.
+	deleted, err := relay.Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	assert.NoError(t, db.Model(&outbox.Message{}).Where("1 = 1").
+		Update("delivered_at", time.Now().Add(-2*time.Hour)).Error)
+	deleted, err = relay.Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+}
+
+func TestRelayStartStop(t *testing.T) {
+	db := newTestDB(t)
+	txm := orm.NewTransactionManager(&data{db: db})
+	pub := outbox.NewMemoryPublisher()
+	relay := outbox.NewRelay(db, pub, outbox.WithInterval(10*time.Millisecond))
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		return outbox.Add(ctx, "topic", []byte("payload"))
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		_ = relay.Start(context.Background())
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(pub.Messages()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, relay.Stop(ctx))
+}
+
+func TestRelayStopBeforeStart(t *testing.T) {
+	db := newTestDB(t)
+	relay := outbox.NewRelay(db, outbox.NewMemoryPublisher())
+
+	assert.NoError(t, relay.Stop(context.Background()))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Start(context.Background())
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Start after Stop did not return")
+	}
+}
+
+func TestRelayInvalidOptions(t *testing.T) {
+	db := newTestDB(t)
+	txm := orm.NewTransactionManager(&data{db: db})
+	pub := outbox.NewMemoryPublisher()
+	// the zero intervals keep the defaults instead of panicking in time.NewTicker.
+	relay := outbox.NewRelay(db, pub,
+		outbox.WithInterval(0),
+		outbox.WithBatchSize(0),
+		outbox.WithRetention(time.Hour, 0),
+	)
+
+	err := txm.Transaction(context.Background(), func(ctx context.Context) error {
+		return outbox.Add(ctx, "topic", []byte("payload"))
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		_ = relay.Start(context.Background())
+	}()
+	assert.Eventually(t, func() bool {
+		return len(pub.Messages()) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	assert.ErrorIs(t, relay.Start(context.Background()), outbox.ErrRelayStarted)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, relay.Stop(ctx))
+}
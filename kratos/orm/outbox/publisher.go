@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPublisher keeps the published messages in memory, used for tests.
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish implements Publisher.
+func (p *MemoryPublisher) Publish(_ context.Context, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	cp := *msg
+	p.messages = append(p.messages, &cp)
+	return nil
+}
+
+// Messages returns the published messages in order.
+func (p *MemoryPublisher) Messages() []*Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*Message(nil), p.messages...)
+}
+
+// SetError makes the following Publish calls fail with err, nil to recover.
+func (p *MemoryPublisher) SetError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
@@ -0,0 +1,205 @@
+package outbox
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/go-kratos/kratos/v2/log"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm/internal/loop"
+)
+
+var (
+	ErrRelayStarted = loop.ErrStarted
+)
+
+// Relay polls the undelivered outbox messages in order and hands them to the Publisher.
+//
+// messages are delivered at least once, a Publisher must tolerate duplicates.
+// the messages are not locked while they are published, only one Relay may run against a table,
+// e.g. on the leader of the replicas, or they are delivered twice and out of order.
+type Relay struct {
+	db  *gorm.DB
+	pub Publisher
+	log *log.Helper
+
+	interval        time.Duration // 轮询间隔
+	batchSize       int           // 每次轮询的消息数量
+	retryCount      uint          // 单条消息投递的重试次数
+	retryDelay      time.Duration // 重试间隔
+	retention       time.Duration // 已投递消息的保留时间, 0 则不清理
+	cleanupInterval time.Duration // 清理间隔
+
+	loop loop.Loop
+}
+
+type Option func(*Relay)
+
+// WithInterval set polling interval, a non-positive interval keeps the default of a second.
+func WithInterval(interval time.Duration) Option {
+	return func(r *Relay) {
+		r.interval = interval
+	}
+}
+
+// WithBatchSize set the number of messages fetched in one poll, a non-positive size keeps the default of 100.
+func WithBatchSize(size int) Option {
+	return func(r *Relay) {
+		r.batchSize = size
+	}
+}
+
+// WithRetry set the publish retry count and delay of a single message.
+func WithRetry(count uint, delay time.Duration) Option {
+	return func(r *Relay) {
+		r.retryCount = count
+		r.retryDelay = delay
+	}
+}
+
+// WithRetention delete the delivered messages older than retention every interval,
+// a non-positive interval keeps the default of an hour.
+func WithRetention(retention, interval time.Duration) Option {
+	return func(r *Relay) {
+		r.retention = retention
+		r.cleanupInterval = interval
+	}
+}
+
+// WithLogger set relay logger.
+func WithLogger(logger log.Logger) Option {
+	return func(r *Relay) {
+		r.log = log.NewHelper(logger)
+	}
+}
+
+func NewRelay(db *gorm.DB, pub Publisher, opts ...Option) *Relay {
+	r := &Relay{
+		db:              db,
+		pub:             pub,
+		log:             log.NewHelper(log.GetLogger()),
+		interval:        time.Second,
+		batchSize:       100,
+		retryCount:      3,
+		retryDelay:      100 * time.Millisecond,
+		retention:       0,
+		cleanupInterval: time.Hour,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = 100
+	}
+	if r.cleanupInterval <= 0 {
+		r.cleanupInterval = time.Hour
+	}
+	return r
+}
+
+// Start implements transport.Server, polls until Stop is called.
+//
+// a Relay runs once, Start returns ErrRelayStarted while it is running and returns at once after Stop.
+func (r *Relay) Start(ctx context.Context) error {
+	tasks := []loop.Task{{Interval: r.interval, Run: r.poll}}
+	if r.retention > 0 {
+		tasks = append(tasks, loop.Task{Interval: r.cleanupInterval, Run: r.cleanup})
+	}
+	return r.loop.Start(ctx, tasks...)
+}
+
+// Stop implements transport.Server, waits for the running poll to finish.
+// a Relay stopped before it is started never polls.
+func (r *Relay) Stop(ctx context.Context) error {
+	return r.loop.Stop(ctx)
+}
+
+func (r *Relay) poll(ctx context.Context) {
+	if _, err := r.Relay(ctx); err != nil && ctx.Err() == nil {
+		r.log.WithContext(ctx).Errorf("outbox: relay messages failed: %v", err)
+	}
+}
+
+func (r *Relay) cleanup(ctx context.Context) {
+	if _, err := r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+		r.log.WithContext(ctx).Errorf("outbox: cleanup messages failed: %v", err)
+	}
+}
+
+// Relay publishes one batch of undelivered messages in id order, returns the number of delivered messages.
+//
+// it stops at the first message that still fails after the retries to keep the order,
+// the message is retried on the next poll.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	var messages []*Message
+	err := r.db.WithContext(ctx).
+		Where("delivered_at IS NULL").
+		Order("id").
+		Limit(r.batchSize).
+		Find(&messages).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i, msg := range messages {
+		if err := r.publish(ctx, msg); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+func (r *Relay) publish(ctx context.Context, msg *Message) error {
+	// 0 attempts of retry-go is to retry forever.
+	limit := r.retryCount
+	if limit < math.MaxUint {
+		limit++
+	}
+
+	attempts := 0
+	err := retry.Do(func() error {
+		attempts++
+		return r.pub.Publish(ctx, msg)
+	},
+		retry.Context(ctx),
+		retry.Attempts(limit),
+		retry.Delay(r.retryDelay),
+		retry.LastErrorOnly(true),
+	)
+
+	updates := map[string]interface{}{
+		"attempts": gorm.Expr("attempts + ?", attempts),
+	}
+	if err != nil {
+		updates["last_error"] = truncate(err.Error(), 1024)
+	} else {
+		updates["delivered_at"] = time.Now()
+		updates["last_error"] = ""
+	}
+
+	if uerr := r.db.WithContext(ctx).Model(msg).Updates(updates).Error; uerr != nil && err == nil {
+		return uerr
+	}
+	return err
+}
+
+// Cleanup deletes the messages delivered before the retention, returns the number of deleted messages.
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("delivered_at < ?", time.Now().Add(-r.retention)).
+		Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
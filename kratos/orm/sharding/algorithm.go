@@ -0,0 +1,187 @@
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Algorithm maps the sharding key value to the table suffix.
+type Algorithm interface {
+	// Shard returns the table suffix of the sharding key value, e.g. _07 or _202401.
+	Shard(value interface{}) (string, error)
+	// Shards returns the suffixes of every shard, used by the scatter-gather queries and the migration.
+	Shards() []string
+}
+
+type hashMod struct {
+	n      int
+	format string
+}
+
+var (
+	ErrInvalidShards = errors.New("sharding: the number of shards must be positive")
+)
+
+// Hash shards by the sharding key mod n, the strings are hashed with FNV-1a first.
+// the suffixes are _00 .. _<n-1>, zero padded to the width of n-1.
+//
+// the key is converted to the type of its field before hashing, so `domain_id = "7"` and 7 have the same shard.
+func Hash(n int) (Algorithm, error) {
+	if n <= 0 {
+		return nil, ErrInvalidShards
+	}
+	width := len(strconv.Itoa(n - 1))
+	if width < 2 {
+		width = 2
+	}
+	return &hashMod{n: n, format: "_%0" + strconv.Itoa(width) + "d"}, nil
+}
+
+// MustHash is Hash that panics on an invalid n, for the constant rules of ShardingRule.
+func MustHash(n int) Algorithm {
+	h, err := Hash(n)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func (h *hashMod) Shard(value interface{}) (string, error) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	var sum uint64
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v := rv.Int()
+		if v < 0 {
+			v = -v
+		}
+		sum = uint64(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		sum = rv.Uint()
+	case reflect.String:
+		sum = fnv32a([]byte(rv.String()))
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return "", fmt.Errorf("sharding: unsupported hash key type %T", value)
+		}
+		sum = fnv32a(rv.Bytes())
+	default:
+		return "", fmt.Errorf("sharding: unsupported hash key type %T", value)
+	}
+	return fmt.Sprintf(h.format, sum%uint64(h.n)), nil
+}
+
+func (h *hashMod) Shards() []string {
+	suffixes := make([]string, 0, h.n)
+	for i := 0; i < h.n; i++ {
+		suffixes = append(suffixes, fmt.Sprintf(h.format, i))
+	}
+	return suffixes
+}
+
+func fnv32a(b []byte) uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write(b)
+	return uint64(h.Sum32())
+}
+
+type timeRange struct {
+	layout string
+	since  time.Time
+	retain int
+	add    func(t time.Time, n int) time.Time
+	start  func(time.Time) time.Time
+}
+
+// RangeOption configures Monthly and Daily.
+type RangeOption func(*timeRange)
+
+// Retain keeps the n latest shards up to the current one, the older ones are left out of Shards
+// and their keys are rejected by Shard. default is every shard since the start.
+func Retain(n int) RangeOption {
+	return func(r *timeRange) {
+		r.retain = n
+	}
+}
+
+// Monthly shards by the month of the time sharding key, the suffixes are _200601.
+// Shards returns the months from since to the current month, see Retain to bound them. the keys before since are rejected.
+func Monthly(since time.Time, opts ...RangeOption) Algorithm {
+	return newTimeRange(&timeRange{
+		layout: "_200601",
+		since:  since,
+		add:    func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		},
+	}, opts)
+}
+
+// Daily shards by the day of the time sharding key, the suffixes are _20060102.
+// Shards returns the days from since to today, see Retain to bound them. the keys before since are rejected.
+func Daily(since time.Time, opts ...RangeOption) Algorithm {
+	return newTimeRange(&timeRange{
+		layout: "_20060102",
+		since:  since,
+		add:    func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		},
+	}, opts)
+}
+
+func newTimeRange(r *timeRange, opts []RangeOption) *timeRange {
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// first returns the start of the oldest shard kept at now.
+func (r *timeRange) first(now time.Time) time.Time {
+	first := r.start(r.since)
+	if r.retain > 0 {
+		if t := r.add(r.start(now), 1-r.retain); t.After(first) {
+			first = t
+		}
+	}
+	return first
+}
+
+func (r *timeRange) Shard(value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return "", fmt.Errorf("sharding: unsupported time range key type %T", value)
+		}
+		t = *v
+	default:
+		return "", fmt.Errorf("sharding: unsupported time range key type %T", value)
+	}
+
+	// the keys of the shards left out of Shards would not be read back.
+	t = t.In(r.since.Location())
+	if t.Before(r.start(r.since)) {
+		return "", fmt.Errorf("sharding: %s is before the first shard of %s", t.Format(time.RFC3339), r.since.Format(r.layout))
+	}
+	if r.retain > 0 && t.Before(r.first(time.Now().In(r.since.Location()))) {
+		return "", fmt.Errorf("sharding: %s is older than the %d retained shards", t.Format(time.RFC3339), r.retain)
+	}
+	return t.Format(r.layout), nil
+}
+
+func (r *timeRange) Shards() []string {
+	var suffixes []string
+	end := time.Now().In(r.since.Location())
+	for t := r.first(end); !t.After(end); t = r.add(t, 1) {
+		suffixes = append(suffixes, t.Format(r.layout))
+	}
+	return suffixes
+}
@@ -0,0 +1,132 @@
+package sharding
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// scatterConcurrency the number of shards queried at the same time by FindAll / Count.
+const scatterConcurrency = 8
+
+// Tables returns the table names of every shard of T.
+func Tables[T any](db *gorm.DB) ([]string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	s := stmt.Schema
+	rule, _, ok := ruleOf(s)
+	if !ok {
+		return nil, fmt.Errorf("sharding: %s is not a sharded model", s.Name)
+	}
+
+	suffixes := rule.Algorithm.Shards()
+	tables := make([]string, 0, len(suffixes))
+	for _, suffix := range suffixes {
+		tables = append(tables, s.Table+suffix)
+	}
+	return tables, nil
+}
+
+// AutoMigrate migrates the table of every shard of T.
+func AutoMigrate[T any](db *gorm.DB) error {
+	tables, err := Tables[T](db)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := db.Table(table).AutoMigrate(new(T)); err != nil {
+			return fmt.Errorf("sharding: migrate %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// FindAll runs the query of scope on every shard of T and merges the rows, sorted by less when it's not nil.
+//
+// the ORDER BY and LIMIT of scope apply to every shard, e.g. the latest 10 logs of all shards:
+//
+//	list, err := sharding.FindAll[AccessLog](db.WithContext(ctx), func(tx *gorm.DB) *gorm.DB {
+//		return tx.Where("status = ?", 500).Order("id DESC").Limit(10)
+//	}, func(a, b *AccessLog) bool { return a.ID > b.ID })
+//	list = list[:min(10, len(list))]
+func FindAll[T any](db *gorm.DB, scope func(*gorm.DB) *gorm.DB, less func(a, b *T) bool) ([]*T, error) {
+	results, err := scatter[T](db, func(tx *gorm.DB) ([]*T, error) {
+		var list []*T
+		err := tx.Scopes(scope).Find(&list).Error
+		return list, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*T
+	for _, rows := range results {
+		list = append(list, rows...)
+	}
+	if less != nil {
+		sort.SliceStable(list, func(i, j int) bool { return less(list[i], list[j]) })
+	}
+	return list, nil
+}
+
+// Count returns the sum of the count of scope on every shard of T.
+func Count[T any](db *gorm.DB, scope func(*gorm.DB) *gorm.DB) (int64, error) {
+	results, err := scatter[T](db, func(tx *gorm.DB) (int64, error) {
+		var n int64
+		err := tx.Model(new(T)).Scopes(scope).Count(&n).Error
+		return n, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, n := range results {
+		total += n
+	}
+	return total, nil
+}
+
+// scatter runs fn on the table of every shard of T, in shard order.
+// the shards are queried concurrently, unless db is a transaction which holds a single connection.
+func scatter[T, R any](db *gorm.DB, fn func(tx *gorm.DB) (R, error)) ([]R, error) {
+	tables, err := Tables[T](db)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := scatterConcurrency
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		concurrency = 1
+	}
+
+	var (
+		results = make([]R, len(tables))
+		errs    = make([]error, len(tables))
+		sem     = make(chan struct{}, concurrency)
+		wg      sync.WaitGroup
+	)
+	for i, table := range tables {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, table string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = fn(db.Session(&gorm.Session{NewDB: true}).Table(table))
+		}(i, table)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("sharding: query %s: %w", tables[i], err)
+		}
+	}
+	return results, nil
+}
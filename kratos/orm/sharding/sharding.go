@@ -0,0 +1,302 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const callBackBeforeName = "sharding:before"
+
+var (
+	ErrMissingShardingKey = errors.New("sharding: the sharding key is missing, use FindAll / Count to query every shard")
+	ErrCrossShard         = errors.New("sharding: the rows belong to different shards")
+)
+
+// Model is implemented by the sharded models.
+//
+//	func (AccessLog) ShardingRule() sharding.Rule {
+//		return sharding.Rule{Key: "domain_id", Algorithm: sharding.MustHash(64)}
+//	}
+type Model interface {
+	ShardingRule() Rule
+}
+
+// Rule the sharding key and the algorithm of a model.
+type Rule struct {
+	Key       string    // 分片键, 字段名或列名
+	Algorithm Algorithm // 分片算法
+}
+
+// Plugin rewrites the table of the statements of the sharded models to the shard of the sharding key,
+// e.g. access_logs to access_logs_07.
+//
+//   - create takes the key from the created rows, a batch must belong to one shard.
+//   - query / update / delete / row take the key from a `key = ?` condition, or the model.
+//   - a statement without the key fails with ErrMissingShardingKey, use FindAll / Count to scatter-gather.
+//
+// a statement with an explicit db.Table is not rewritten, Raw / Exec SQL neither.
+type Plugin struct{}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return "ShardingPlugin"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		name string
+		err  error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register(callBackBeforeName+"_create", p.create)},
+		{"query", cb.Query().Before("gorm:query").Register(callBackBeforeName+"_query", p.route)},
+		{"update", cb.Update().Before("gorm:update").Register(callBackBeforeName+"_update", p.route)},
+		{"delete", cb.Delete().Before("gorm:delete").Register(callBackBeforeName+"_delete", p.route)},
+		{"row", cb.Row().Before("gorm:row").Register(callBackBeforeName+"_row", p.route)},
+	}
+	for _, h := range hooks {
+		if h.err != nil {
+			return fmt.Errorf("register %s hook: %w", h.name, h.err)
+		}
+	}
+	return nil
+}
+
+// ruleOf returns the rule and the key field of the statement model.
+func ruleOf(s *schema.Schema) (Rule, *schema.Field, bool) {
+	if s == nil {
+		return Rule{}, nil, false
+	}
+	m, ok := reflect.New(s.ModelType).Interface().(Model)
+	if !ok {
+		return Rule{}, nil, false
+	}
+	rule := m.ShardingRule()
+	field := s.LookUpField(rule.Key)
+	if field == nil || rule.Algorithm == nil {
+		return Rule{}, nil, false
+	}
+	return rule, field, true
+}
+
+// sharded is false for the statements with an explicit table, or already routed.
+func sharded(db *gorm.DB) (Rule, *schema.Field, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Table != stmt.Schema.Table || stmt.TableExpr != nil {
+		return Rule{}, nil, false
+	}
+	return ruleOf(stmt.Schema)
+}
+
+func (p *Plugin) create(db *gorm.DB) {
+	rule, field, ok := sharded(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+
+	var suffix string
+	for _, rv := range rows(stmt.ReflectValue) {
+		value, zero := field.ValueOf(stmt.Context, rv)
+		if zero && field.AutoCreateTime > 0 {
+			// the time key filled on create, e.g. created_at, must be known to pick the shard.
+			now := db.NowFunc()
+			if err := field.Set(stmt.Context, rv, now); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+			value, zero = now, false
+		}
+		if zero {
+			_ = db.AddError(fmt.Errorf("%w: %s", ErrMissingShardingKey, stmt.Schema.Table))
+			return
+		}
+
+		s, err := rule.Algorithm.Shard(value)
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if suffix != "" && s != suffix {
+			_ = db.AddError(fmt.Errorf("%w: %s%s and %s%s", ErrCrossShard, stmt.Schema.Table, suffix, stmt.Schema.Table, s))
+			return
+		}
+		suffix = s
+	}
+	if suffix == "" {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrMissingShardingKey, stmt.Schema.Table))
+		return
+	}
+	stmt.Table = stmt.Schema.Table + suffix
+}
+
+func (p *Plugin) route(db *gorm.DB) {
+	rule, field, ok := sharded(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+
+	value, ok := keyFromWhere(stmt, field.DBName)
+	if ok {
+		v, err := convert(stmt.Context, field, value)
+		if err != nil {
+			_ = db.AddError(fmt.Errorf("sharding: invalid sharding key %v: %w", value, err))
+			return
+		}
+		value = v
+	} else if stmt.ReflectValue.Kind() == reflect.Struct {
+		var zero bool
+		value, zero = field.ValueOf(stmt.Context, stmt.ReflectValue)
+		ok = !zero
+	}
+	if !ok {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrMissingShardingKey, stmt.Schema.Table))
+		return
+	}
+
+	suffix, err := rule.Algorithm.Shard(value)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	stmt.Table = stmt.Schema.Table + suffix
+}
+
+// convert converts the value of a condition to the type of field, e.g. the "7" of `domain_id = ?`
+// to the int64 of the created rows, so both have the same shard.
+func convert(ctx context.Context, field *schema.Field, value interface{}) (interface{}, error) {
+	rv := reflect.New(field.Schema.ModelType).Elem()
+	if err := field.Set(ctx, rv, value); err != nil {
+		return nil, err
+	}
+	v, _ := field.ValueOf(ctx, rv)
+	return v, nil
+}
+
+// rows returns the struct values of a created struct or slice.
+func rows(rv reflect.Value) []reflect.Value {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		list := make([]reflect.Value, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list = append(list, reflect.Indirect(rv.Index(i)))
+		}
+		return list
+	case reflect.Struct:
+		return []reflect.Value{rv}
+	default:
+		return nil
+	}
+}
+
+// keyFromWhere returns the value of the `column = ?` condition AND-ed in the WHERE clause.
+func keyFromWhere(stmt *gorm.Statement, column string) (interface{}, bool) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return nil, false
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return nil, false
+	}
+	return keyFromExprs(where.Exprs, column)
+}
+
+// an OR-ed condition, e.g. `key = 1 OR name = 'a'`, does not pin the shard.
+func keyFromExprs(exprs []clause.Expression, column string) (interface{}, bool) {
+	for _, expr := range exprs {
+		if _, ok := expr.(clause.OrConditions); ok {
+			return nil, false
+		}
+	}
+
+	for _, expr := range exprs {
+		switch e := expr.(type) {
+		case clause.Eq:
+			if columnName(e.Column) == column && single(e.Value) {
+				return e.Value, true
+			}
+		case clause.Expr:
+			if v, ok := keyFromExpr(e, column); ok {
+				return v, true
+			}
+		case clause.AndConditions:
+			if v, ok := keyFromExprs(e.Exprs, column); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+var (
+	andRegexp = regexp.MustCompile(`(?i)\s+AND\s+`)
+	orRegexp  = regexp.MustCompile(`(?i)\bOR\b`)
+)
+
+// keyFromExpr returns the value of `column = ?` in a condition string, e.g. `domain_id = ? AND status = ?`.
+func keyFromExpr(e clause.Expr, column string) (interface{}, bool) {
+	if strings.Count(e.SQL, "?") != len(e.Vars) || strings.Contains(e.SQL, "(") || orRegexp.MatchString(e.SQL) {
+		return nil, false
+	}
+
+	i := 0
+	for _, part := range andRegexp.Split(e.SQL, -1) {
+		n := strings.Count(part, "?")
+		if n == 1 && single(e.Vars[i]) && eqRegexp(column).MatchString(part) {
+			return e.Vars[i], true
+		}
+		i += n
+	}
+	return nil, false
+}
+
+func columnName(column interface{}) string {
+	switch c := column.(type) {
+	case string:
+		return c
+	case clause.Column:
+		return c.Name
+	default:
+		return ""
+	}
+}
+
+// single is false for the IN lists.
+func single(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array:
+		_, bytes := value.([]byte)
+		return bytes
+	default:
+		return true
+	}
+}
+
+var eqRegexps sync.Map // column => *regexp.Regexp
+
+// eqRegexp matches `column = ?`, the column may be quoted and qualified by the table.
+func eqRegexp(column string) *regexp.Regexp {
+	if re, ok := eqRegexps.Load(column); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile("^\\s*(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?" + regexp.QuoteMeta(column) + "[`\"]?\\s*=\\s*\\?\\s*$")
+	eqRegexps.Store(column, re)
+	return re
+}
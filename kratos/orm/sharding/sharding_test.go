@@ -0,0 +1,229 @@
+package sharding_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/sharding"
+)
+
+type AccessLog struct {
+	ID       int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	DomainID int64  `gorm:"column:domain_id;"`
+	Path     string `gorm:"column:path;"`
+	Status   int    `gorm:"column:status;"`
+}
+
+func (AccessLog) ShardingRule() sharding.Rule {
+	return sharding.Rule{Key: "domain_id", Algorithm: sharding.MustHash(4)}
+}
+
+type Stat struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement;"`
+	Hits      int64     `gorm:"column:hits;"`
+	CreatedAt time.Time `gorm:"column:created_at;"`
+}
+
+func (Stat) ShardingRule() sharding.Rule {
+	return sharding.Rule{Key: "created_at", Algorithm: sharding.Monthly(time.Now().AddDate(0, -2, 0))}
+}
+
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared")),
+		orm.WithPlugins(sharding.New()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sharding.AutoMigrate[AccessLog](db); err != nil {
+		t.Fatal(err)
+	}
+	if err := sharding.AutoMigrate[Stat](db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func count(t *testing.T, db *gorm.DB, table string) int64 {
+	t.Helper()
+
+	var n int64
+	if err := db.Table(table).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAlgorithm(t *testing.T) {
+	hash, err := sharding.Hash(64)
+	assert.NoError(t, err)
+	suffix, err := hash.Shard(int64(70))
+	assert.NoError(t, err)
+	assert.Equal(t, "_06", suffix)
+	suffix, err = hash.Shard("example.com")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^_\d\d$`, suffix)
+	_, err = hash.Shard(1.5)
+	assert.Error(t, err)
+	shards := hash.Shards()
+	assert.Len(t, shards, 64)
+	assert.Equal(t, "_63", shards[63])
+	assert.Equal(t, "_999", sharding.MustHash(1000).Shards()[999])
+	_, err = sharding.Hash(0)
+	assert.ErrorIs(t, err, sharding.ErrInvalidShards)
+	assert.Panics(t, func() { sharding.MustHash(-1) })
+
+	monthly := sharding.Monthly(time.Date(2024, 11, 15, 0, 0, 0, 0, time.UTC))
+	suffix, err = monthly.Shard(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "_202502", suffix)
+	shards = monthly.Shards()
+	assert.Equal(t, []string{"_202411", "_202412", "_202501"}, shards[:3])
+	assert.Equal(t, time.Now().UTC().Format("_200601"), shards[len(shards)-1])
+
+	_, err = monthly.Shard("2025-02")
+	assert.Error(t, err)
+	// before the first shard.
+	_, err = monthly.Shard(time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC))
+	assert.Error(t, err)
+	suffix, err = monthly.Shard(time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "_202411", suffix)
+
+	// the retained shards end at the current one.
+	now := time.Now().UTC()
+	daily := sharding.Daily(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), sharding.Retain(3))
+	assert.Equal(t, []string{
+		now.AddDate(0, 0, -2).Format("_20060102"),
+		now.AddDate(0, 0, -1).Format("_20060102"),
+		now.Format("_20060102"),
+	}, daily.Shards())
+	_, err = daily.Shard(now.AddDate(0, 0, -3))
+	assert.Error(t, err)
+	suffix, err = daily.Shard(now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Format("_20060102"), suffix)
+}
+
+func TestPlugin(t *testing.T) {
+	db := newDB(t)
+	ctx := context.Background()
+
+	// domain 5 => access_logs_01, domain 6 => access_logs_02
+	assert.NoError(t, db.WithContext(ctx).Create(&AccessLog{DomainID: 5, Path: "/a", Status: 200}).Error)
+	assert.NoError(t, db.Create([]*AccessLog{
+		{DomainID: 6, Path: "/b", Status: 200},
+		{DomainID: 6, Path: "/c", Status: 500},
+	}).Error)
+	assert.Equal(t, int64(1), count(t, db, "access_logs_01"))
+	assert.Equal(t, int64(2), count(t, db, "access_logs_02"))
+
+	// a batch of several shards.
+	err := db.Create([]*AccessLog{{DomainID: 5}, {DomainID: 6}}).Error
+	assert.ErrorIs(t, err, sharding.ErrCrossShard)
+
+	var list []*AccessLog
+	assert.NoError(t, db.Where("domain_id = ?", 6).Order("id").Find(&list).Error)
+	assert.Len(t, list, 2)
+	// a string key is converted to the type of domain_id first.
+	assert.NoError(t, db.Where("domain_id = ?", "6").Find(&list).Error)
+	assert.Len(t, list, 2)
+	assert.Error(t, db.Where("domain_id = ?", "six").Find(&list).Error)
+	assert.NoError(t, db.Where(&AccessLog{DomainID: 6, Status: 500}).Find(&list).Error)
+	assert.Len(t, list, 1)
+	assert.NoError(t, db.Where(map[string]interface{}{"domain_id": 5}).Find(&list).Error)
+	assert.Len(t, list, 1)
+
+	var n int64
+	assert.NoError(t, db.Model(&AccessLog{}).Where("`domain_id` = ?", 6).Count(&n).Error)
+	assert.Equal(t, int64(2), n)
+
+	// the key is missing, an IN list or OR-ed.
+	assert.ErrorIs(t, db.Find(&list).Error, sharding.ErrMissingShardingKey)
+	assert.ErrorIs(t, db.First(&AccessLog{}, 1).Error, sharding.ErrMissingShardingKey)
+	assert.ErrorIs(t, db.Where("domain_id IN ?", []int64{5, 6}).Find(&list).Error, sharding.ErrMissingShardingKey)
+	assert.ErrorIs(t, db.Where("domain_id = ?", 6).Or("status = ?", 500).Find(&list).Error, sharding.ErrMissingShardingKey)
+	assert.ErrorIs(t, db.Create(&AccessLog{Path: "/d"}).Error, sharding.ErrMissingShardingKey)
+
+	// update / delete by the model or the condition.
+	log := list[0]
+	log.Status = 404
+	assert.NoError(t, db.Save(log).Error)
+	assert.NoError(t, db.Model(&AccessLog{}).Where("domain_id = ?", 6).Where("status = ?", 500).Update("status", 502).Error)
+	assert.NoError(t, db.Where("domain_id = ? AND status = ?", 6, 502).Find(&list).Error)
+	assert.Len(t, list, 1)
+	assert.NoError(t, db.Where("domain_id = ?", 6).Where("status = ?", 502).Find(&list).Error)
+	assert.Len(t, list, 1)
+
+	assert.NoError(t, db.Delete(log).Error)
+	assert.Zero(t, count(t, db, "access_logs_01"))
+	assert.ErrorIs(t, db.Where("status = ?", 200).Delete(&AccessLog{}).Error, sharding.ErrMissingShardingKey)
+
+	// an explicit table is not rewritten.
+	assert.NoError(t, db.Table("access_logs_02").Find(&list).Error)
+	assert.Len(t, list, 2)
+
+	// the time key filled on create picks the current month.
+	stat := &Stat{Hits: 1}
+	assert.NoError(t, db.Create(stat).Error)
+	assert.False(t, stat.CreatedAt.IsZero())
+	assert.Equal(t, int64(1), count(t, db, "stats"+time.Now().Format("_200601")))
+	assert.NoError(t, db.Create(&Stat{Hits: 2, CreatedAt: time.Now().AddDate(0, -1, 0)}).Error)
+	assert.Equal(t, int64(1), count(t, db, "stats"+time.Now().AddDate(0, -1, 0).Format("_200601")))
+}
+
+func TestFindAll(t *testing.T) {
+	db := newDB(t)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 10; i++ {
+		err := db.Create(&AccessLog{DomainID: i, Path: fmt.Sprintf("/%d", i), Status: 200 + int(i%2)*300}).Error
+		assert.NoError(t, err)
+	}
+
+	list, err := sharding.FindAll[AccessLog](db.WithContext(ctx), func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("status = ?", 500)
+	}, func(a, b *AccessLog) bool { return a.DomainID < b.DomainID })
+	assert.NoError(t, err)
+	domains := make([]int64, 0, len(list))
+	for _, log := range list {
+		domains = append(domains, log.DomainID)
+	}
+	assert.Equal(t, []int64{1, 3, 5, 7, 9}, domains)
+
+	n, err := sharding.Count[AccessLog](db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("status = ?", 200)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	// sequentially within a transaction.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		n, err := sharding.Count[AccessLog](tx, func(tx *gorm.DB) *gorm.DB { return tx })
+		assert.Equal(t, int64(10), n)
+		return err
+	})
+	assert.NoError(t, err)
+
+	tables, err := sharding.Tables[AccessLog](db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"access_logs_00", "access_logs_01", "access_logs_02", "access_logs_03"}, tables)
+
+	_, err = sharding.FindAll[struct{ ID int64 }](db, nil, nil)
+	assert.Error(t, err)
+}
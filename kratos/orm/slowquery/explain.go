@@ -0,0 +1,117 @@
+package slowquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type explainJob struct {
+	fingerprint string
+	sql         string
+	vars        []interface{}
+	elapsed     time.Duration
+	spanContext trace.SpanContext
+}
+
+func (p *Plugin) run() {
+	defer close(p.done)
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case job := <-p.jobs:
+			p.handle(job)
+		}
+	}
+}
+
+func (p *Plugin) handle(job explainJob) {
+	// the log and the span are linked to the trace of the slow query.
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), job.spanContext)
+
+	plan, err := p.explain(ctx, job.sql, job.vars)
+	if err != nil {
+		p.log.WithContext(ctx).Warnf("slowquery: explain %q failed: %v", job.sql, err)
+		return
+	}
+	p.setPlan(job.fingerprint, plan)
+
+	p.log.WithContext(ctx).Warnw(
+		"msg", "slow query plan",
+		"sql", job.sql,
+		"fingerprint", job.fingerprint,
+		"timeUsed", float64(job.elapsed.Nanoseconds())/1e6,
+		"plan", plan,
+	)
+
+	if job.spanContext.IsValid() {
+		_, span := p.tracer.Start(ctx, "EXPLAIN", trace.WithSpanKind(trace.SpanKindClient))
+		span.SetAttributes(
+			attribute.String("db.statement", job.sql),
+			attribute.String("db.query.fingerprint", job.fingerprint),
+			attribute.Float64("db.query.time_used_ms", float64(job.elapsed.Nanoseconds())/1e6),
+			attribute.String("db.query.plan", plan),
+		)
+		span.End()
+	}
+}
+
+// explain runs EXPLAIN of sql on a connection of the pool, the statement may have run in a transaction
+// holding another connection.
+func (p *Plugin) explain(ctx context.Context, sql string, vars []interface{}) (string, error) {
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.explainTimeout)
+	defer cancel()
+
+	prefix := "EXPLAIN "
+	if p.db.Dialector.Name() == "sqlite" {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := sqlDB.QueryContext(ctx, prefix+sql, vars...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	lines := []string{strings.Join(columns, " | ")}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			switch v := v.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = string(v)
+			default:
+				cells[i] = fmt.Sprint(v)
+			}
+		}
+		lines = append(lines, strings.Join(cells, " | "))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,78 @@
+package slowquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type statsView struct {
+	Fingerprint string     `json:"fingerprint"`
+	Count       int64      `json:"count"`
+	Slow        int64      `json:"slow"`
+	TotalMs     float64    `json:"total_ms"`
+	P50Ms       float64    `json:"p50_ms"`
+	P99Ms       float64    `json:"p99_ms"`
+	Plan        string     `json:"plan,omitempty"`
+	ExplainedAt *time.Time `json:"explained_at,omitempty"`
+}
+
+// ServeHTTP implements http.Handler, GET returns the top fingerprints as JSON and DELETE resets the statistics.
+//
+//	GET /debug/slowquery?n=20&sort=total   // sort by total / count / slow / p50 / p99
+func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		p.Reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := 20
+	if s := r.URL.Query().Get("n"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "invalid n: "+s, http.StatusBadRequest)
+			return
+		}
+		n = v
+	}
+	by := r.URL.Query().Get("sort")
+	switch by {
+	case "", "total", "count", "slow", "p50", "p99":
+	default:
+		http.Error(w, "invalid sort: "+by, http.StatusBadRequest)
+		return
+	}
+
+	list := p.top(n, by)
+	items := make([]statsView, 0, len(list))
+	for _, s := range list {
+		v := statsView{
+			Fingerprint: s.Fingerprint,
+			Count:       s.Count,
+			Slow:        s.Slow,
+			TotalMs:     milliseconds(s.Total),
+			P50Ms:       milliseconds(s.P50),
+			P99Ms:       milliseconds(s.P99),
+			Plan:        s.Plan,
+		}
+		if !s.ExplainedAt.IsZero() {
+			v.ExplainedAt = &s.ExplainedAt
+		}
+		items = append(items, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1e6
+}
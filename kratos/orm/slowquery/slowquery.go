@@ -0,0 +1,217 @@
+package slowquery
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+const (
+	callBackBeforeName = "slowquery:before"
+	callBackAfterName  = "slowquery:after"
+	startSettingKey    = "slowquery:start"
+	tracerName         = "gorm-slowquery"
+)
+
+// Plugin aggregates the executed statements by fingerprint, the SQL with the literals stripped,
+// and captures the plan of the slow SELECTs with EXPLAIN.
+//
+// the EXPLAIN runs asynchronously on a separate connection of the pool and is rate limited,
+// the plan is logged and recorded as a child span of the slow query.
+//
+// Initialize starts the EXPLAIN worker, Close stops it and must be called when the db is closed.
+type Plugin struct {
+	log    *log.Helper
+	tracer trace.Tracer
+
+	threshold       time.Duration // 慢查询阈值
+	maxFingerprints int           // 统计的最大指纹数量
+	explainInterval time.Duration // 两次 EXPLAIN 的最小间隔
+	explainCooldown time.Duration // 同一指纹两次 EXPLAIN 的最小间隔
+	explainTimeout  time.Duration // EXPLAIN 的超时时间
+
+	db                *gorm.DB
+	quotedIdentifiers bool // 方言的标识符使用双引号
+	jobs              chan explainJob
+	stop              chan struct{}
+	done              chan struct{}
+	closed            sync.Once
+
+	entries sync.Map   // fingerprint -> *entry
+	mu      sync.Mutex // 新增和淘汰指纹
+	count   int        // 指纹数量
+
+	explainMu   sync.Mutex
+	lastExplain time.Time
+}
+
+type Option func(*Plugin)
+
+// WithThreshold set the slow query threshold, default is 500ms.
+func WithThreshold(threshold time.Duration) Option {
+	return func(p *Plugin) {
+		p.threshold = threshold
+	}
+}
+
+// WithMaxFingerprints set the number of fingerprints kept, the one with the least total time is evicted.
+// default is 1000.
+func WithMaxFingerprints(n int) Option {
+	return func(p *Plugin) {
+		p.maxFingerprints = n
+	}
+}
+
+// WithExplainRate run at most one EXPLAIN every interval, and one per fingerprint every cooldown.
+// default is a second and a minute.
+func WithExplainRate(interval, cooldown time.Duration) Option {
+	return func(p *Plugin) {
+		p.explainInterval = interval
+		p.explainCooldown = cooldown
+	}
+}
+
+// WithExplainTimeout set the EXPLAIN timeout, default is 5s.
+func WithExplainTimeout(timeout time.Duration) Option {
+	return func(p *Plugin) {
+		p.explainTimeout = timeout
+	}
+}
+
+// WithLogger set the logger of the captured plans.
+func WithLogger(logger log.Logger) Option {
+	return func(p *Plugin) {
+		p.log = log.NewHelper(logger)
+	}
+}
+
+// WithTracerProvider set the tracer provider of the plan spans. default is the global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(p *Plugin) {
+		p.tracer = provider.Tracer(tracerName)
+	}
+}
+
+func New(opts ...Option) *Plugin {
+	p := &Plugin{
+		log:             log.NewHelper(log.GetLogger()),
+		tracer:          otel.GetTracerProvider().Tracer(tracerName),
+		threshold:       500 * time.Millisecond,
+		maxFingerprints: 1000,
+		explainInterval: time.Second,
+		explainCooldown: time.Minute,
+		explainTimeout:  5 * time.Second,
+		jobs:            make(chan explainJob, 16),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Plugin) Name() string {
+	return "SlowQueryPlugin"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		name string
+		err  error
+	}{
+		{"before_create", cb.Create().Before("gorm:create").Register(callBackBeforeName+"_create", p.before)},
+		{"before_query", cb.Query().Before("gorm:query").Register(callBackBeforeName+"_query", p.before)},
+		{"before_update", cb.Update().Before("gorm:update").Register(callBackBeforeName+"_update", p.before)},
+		{"before_delete", cb.Delete().Before("gorm:delete").Register(callBackBeforeName+"_delete", p.before)},
+		{"before_row", cb.Row().Before("gorm:row").Register(callBackBeforeName+"_row", p.before)},
+		{"before_raw", cb.Raw().Before("gorm:raw").Register(callBackBeforeName+"_raw", p.before)},
+		{"after_create", cb.Create().After("gorm:create").Register(callBackAfterName+"_create", p.after)},
+		{"after_query", cb.Query().After("gorm:query").Register(callBackAfterName+"_query", p.after)},
+		{"after_update", cb.Update().After("gorm:update").Register(callBackAfterName+"_update", p.after)},
+		{"after_delete", cb.Delete().After("gorm:delete").Register(callBackAfterName+"_delete", p.after)},
+		{"after_row", cb.Row().After("gorm:row").Register(callBackAfterName+"_row", p.after)},
+		{"after_raw", cb.Raw().After("gorm:raw").Register(callBackAfterName+"_raw", p.after)},
+	}
+	for _, h := range hooks {
+		if h.err != nil {
+			return fmt.Errorf("register %s hook: %w", h.name, h.err)
+		}
+	}
+
+	p.db = db
+	p.quotedIdentifiers = orm.QuotesIdentifiers(db.Dialector)
+	go p.run()
+	return nil
+}
+
+// Close stops the EXPLAIN worker, the statements are still aggregated.
+func (p *Plugin) Close() error {
+	p.closed.Do(func() {
+		close(p.stop)
+		if p.db != nil {
+			<-p.done
+		}
+	})
+	return nil
+}
+
+func (p *Plugin) before(db *gorm.DB) {
+	db.InstanceSet(startSettingKey, time.Now())
+}
+
+func (p *Plugin) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(startSettingKey)
+	if !ok {
+		return
+	}
+	start, _ := v.(time.Time)
+	sql := db.Statement.SQL.String()
+	if sql == "" || db.DryRun {
+		return
+	}
+
+	elapsed := time.Since(start)
+	slow := p.threshold > 0 && elapsed > p.threshold
+	fingerprint := fingerprintOf(sql, p.quotedIdentifiers)
+
+	e := p.load(fingerprint)
+	e.mu.Lock()
+	e.record(elapsed, slow)
+	explain := slow && db.Error == nil && isSelect(sql) && p.allowExplain(e, time.Now())
+	e.mu.Unlock()
+
+	if !explain {
+		return
+	}
+	job := explainJob{
+		fingerprint: fingerprint,
+		sql:         sql,
+		vars:        append([]interface{}(nil), db.Statement.Vars...),
+		elapsed:     elapsed,
+		spanContext: trace.SpanContextFromContext(db.Statement.Context),
+	}
+	select {
+	case p.jobs <- job:
+	default:
+		// the worker is busy, the plan is captured by a later slow query.
+	}
+}
+
+func isSelect(sql string) bool {
+	sql = strings.TrimLeft(sql, " \t\r\n(")
+	if len(sql) < 6 {
+		return false
+	}
+	prefix := strings.ToUpper(sql[:6])
+	return prefix == "SELECT" || strings.HasPrefix(prefix, "WITH ")
+}
@@ -0,0 +1,211 @@
+package slowquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/slowquery"
+)
+
+type User struct {
+	ID   int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Name string `gorm:"column:name;index;"`
+}
+
+func newDB(t *testing.T, plugin *slowquery.Plugin) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared")),
+		orm.WithPlugins(plugin),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = plugin.Close()
+	})
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	plugin.Reset()
+	return db
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM users WHERE id = 1", "SELECT * FROM users WHERE id = ?"},
+		{"SELECT * FROM users WHERE name = 'bob''s'  AND\n score > 1.5", "SELECT * FROM users WHERE name = ? AND score > ?"},
+		{"SELECT * FROM t1 WHERE id IN (1, 2, 3)", "SELECT * FROM t1 WHERE id IN (...)"},
+		{"SELECT * FROM t1 WHERE id in (?,?)", "SELECT * FROM t1 WHERE id IN (...)"},
+		{"INSERT INTO users (name) VALUES (?),(?),(?)", "INSERT INTO users (name) VALUES (...)"},
+		{`SELECT * FROM users WHERE name = "bob"`, "SELECT * FROM users WHERE name = ?"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, slowquery.Fingerprint(tt.sql))
+	}
+}
+
+func TestPlugin_Top(t *testing.T) {
+	plugin := slowquery.New(slowquery.WithThreshold(time.Hour))
+	db := newDB(t, plugin)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, db.Raw("SELECT * FROM users WHERE id = ?", i).Scan(&[]User{}).Error)
+		assert.NoError(t, db.Exec("UPDATE users SET name = 'a' WHERE id IN (1, 2)").Error)
+	}
+	assert.NoError(t, db.Where("name = ?", "a").Find(&[]User{}).Error)
+
+	top := plugin.Top(0)
+	if assert.Len(t, top, 3) {
+		counts := map[string]int64{}
+		for _, s := range top {
+			counts[s.Fingerprint] = s.Count
+			assert.Zero(t, s.Slow)
+			assert.Empty(t, s.Plan)
+			assert.True(t, s.P50 <= s.P99 && s.P99 <= s.Total)
+		}
+		assert.Equal(t, map[string]int64{
+			"SELECT * FROM users WHERE id = ?":            3,
+			"UPDATE users SET name = ? WHERE id IN (...)": 3,
+			"SELECT * FROM `users` WHERE name = ?":        1,
+		}, counts)
+	}
+	assert.Len(t, plugin.Top(1), 1)
+
+	plugin.Reset()
+	assert.Empty(t, plugin.Top(0))
+}
+
+func TestPlugin_MaxFingerprints(t *testing.T) {
+	plugin := slowquery.New(slowquery.WithMaxFingerprints(2))
+	db := newDB(t, plugin)
+
+	assert.NoError(t, db.Exec("SELECT 1").Error)
+	assert.NoError(t, db.Exec("SELECT 1 FROM users").Error)
+	assert.NoError(t, db.Exec("SELECT 1 FROM users WHERE id = 1").Error)
+	assert.Len(t, plugin.Top(0), 2)
+}
+
+func TestPlugin_Concurrent(t *testing.T) {
+	plugin := slowquery.New()
+	db := newDB(t, plugin)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = db.Exec(fmt.Sprintf("SELECT %d FROM users WHERE id = ?", j%2), i).Error
+				_ = db.Exec(fmt.Sprintf("SELECT * FROM users u%d", j%8)).Error
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := make(map[string]int64)
+	for _, s := range plugin.Top(0) {
+		counts[s.Fingerprint] = s.Count
+	}
+	assert.Len(t, counts, 9)
+	assert.Equal(t, int64(400), counts["SELECT ? FROM users WHERE id = ?"])
+	assert.Equal(t, int64(56), counts["SELECT * FROM users u0"])
+}
+
+func TestPlugin_Explain(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	plugin := slowquery.New(
+		slowquery.WithThreshold(time.Nanosecond),
+		slowquery.WithExplainRate(0, time.Hour),
+		slowquery.WithTracerProvider(provider),
+	)
+	db := newDB(t, plugin)
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	assert.NoError(t, db.WithContext(ctx).Where("name = ?", "a").Find(&[]User{}).Error)
+	span.End()
+
+	var plan string
+	assert.Eventually(t, func() bool {
+		plan = plugin.Top(1)[0].Plan
+		return plan != ""
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, plan, "idx_users_name")
+
+	// the EXPLAIN span is a child of the request.
+	assert.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, time.Second, 10*time.Millisecond)
+	explain := recorder.Ended()[1]
+	assert.Equal(t, "EXPLAIN", explain.Name())
+	assert.Equal(t, span.SpanContext().SpanID(), explain.Parent().SpanID())
+
+	// the same fingerprint is explained once per cooldown, the writes are never explained.
+	assert.NoError(t, db.Where("name = ?", "b").Find(&[]User{}).Error)
+	assert.NoError(t, db.Create(&User{Name: "c"}).Error)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, recorder.Ended(), 2)
+
+	for _, s := range plugin.Top(0) {
+		assert.NotZero(t, s.Slow)
+		if s.Fingerprint != "SELECT * FROM `users` WHERE name = ?" {
+			assert.Empty(t, s.Plan)
+		}
+	}
+}
+
+func TestPlugin_ServeHTTP(t *testing.T) {
+	plugin := slowquery.New()
+	db := newDB(t, plugin)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, db.Exec("SELECT 1").Error)
+	}
+	assert.NoError(t, db.Exec("SELECT 1 FROM users").Error)
+
+	rec := httptest.NewRecorder()
+	plugin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/slowquery?n=1&sort=count", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Items []struct {
+			Fingerprint string  `json:"fingerprint"`
+			Count       int64   `json:"count"`
+			TotalMs     float64 `json:"total_ms"`
+		} `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	if assert.Len(t, body.Items, 1) {
+		assert.Equal(t, "SELECT ?", body.Items[0].Fingerprint)
+		assert.Equal(t, int64(2), body.Items[0].Count)
+		assert.Positive(t, body.Items[0].TotalMs)
+	}
+
+	rec = httptest.NewRecorder()
+	plugin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/slowquery?sort=name", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	plugin.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug/slowquery", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, plugin.Top(0))
+}
@@ -0,0 +1,229 @@
+package slowquery
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omalloc/contrib/kratos/orm"
+)
+
+// maxSamples the number of recent durations kept per fingerprint for the percentiles.
+const maxSamples = 512
+
+var (
+	inListRegexp     = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	valuesRegexp     = regexp.MustCompile(`(?i)\bVALUES\s*\([^)]*\)(?:\s*,\s*\([^)]*\))*`)
+	whitespaceRegexp = regexp.MustCompile(`\s+`)
+)
+
+// Fingerprint normalises sql, the string and number literals are replaced with ? as orm.Redact does,
+// the IN lists and the VALUES rows are collapsed, so the same query with different parameters has one fingerprint.
+//
+//	SELECT * FROM users WHERE id IN (1, 2, 3) AND name = 'a'
+//	SELECT * FROM users WHERE id IN (...) AND name = ?
+func Fingerprint(sql string) string {
+	return fingerprintOf(sql, false)
+}
+
+// fingerprintOf is Fingerprint of a driver quoting the identifiers with " when quotedIdentifiers is set.
+func fingerprintOf(sql string, quotedIdentifiers bool) string {
+	sql = orm.Redact(sql, quotedIdentifiers)
+	sql = inListRegexp.ReplaceAllString(sql, "IN (...)")
+	sql = valuesRegexp.ReplaceAllString(sql, "VALUES (...)")
+	sql = whitespaceRegexp.ReplaceAllString(sql, " ")
+	return strings.TrimSpace(sql)
+}
+
+// Stats of a fingerprint.
+type Stats struct {
+	Fingerprint string
+	Count       int64         // 执行次数
+	Slow        int64         // 慢查询次数
+	Total       time.Duration // 总耗时
+	P50         time.Duration // 最近执行的 p50 耗时
+	P99         time.Duration // 最近执行的 p99 耗时
+	Plan        string        // 最近一次捕获的执行计划
+	ExplainedAt time.Time     // 最近一次捕获执行计划的时间
+}
+
+type entry struct {
+	mu          sync.Mutex
+	count       int64
+	slow        int64
+	total       time.Duration
+	samples     []time.Duration // 环形缓冲
+	next        int
+	plan        string
+	explainedAt time.Time // 最近一次 EXPLAIN 的时间, 包括未完成的
+	plannedAt   time.Time
+}
+
+// load returns the entry of fingerprint, the new ones evict the entry with the least total time when full.
+func (p *Plugin) load(fingerprint string) *entry {
+	if e, ok := p.entries.Load(fingerprint); ok {
+		return e.(*entry)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries.Load(fingerprint); ok {
+		return e.(*entry)
+	}
+	if p.maxFingerprints > 0 && p.count >= p.maxFingerprints {
+		p.evict()
+	}
+	e := &entry{}
+	p.entries.Store(fingerprint, e)
+	p.count++
+	return e
+}
+
+// record must be called with e.mu held.
+func (e *entry) record(elapsed time.Duration, slow bool) {
+	e.count++
+	e.total += elapsed
+	if slow {
+		e.slow++
+	}
+	if len(e.samples) < maxSamples {
+		e.samples = append(e.samples, elapsed)
+	} else {
+		e.samples[e.next] = elapsed
+		e.next = (e.next + 1) % maxSamples
+	}
+}
+
+// evict removes the fingerprint with the least total time, it must be called with p.mu held.
+func (p *Plugin) evict() {
+	var (
+		victim any
+		least  time.Duration = math.MaxInt64
+	)
+	p.entries.Range(func(fingerprint, v any) bool {
+		e := v.(*entry)
+		e.mu.Lock()
+		total := e.total
+		e.mu.Unlock()
+		if total < least {
+			victim, least = fingerprint, total
+		}
+		return true
+	})
+	if victim != nil {
+		p.entries.Delete(victim)
+		p.count--
+	}
+}
+
+// allowExplain must be called with e.mu held.
+func (p *Plugin) allowExplain(e *entry, now time.Time) bool {
+	if !e.explainedAt.IsZero() && now.Sub(e.explainedAt) < p.explainCooldown {
+		return false
+	}
+
+	p.explainMu.Lock()
+	defer p.explainMu.Unlock()
+
+	if now.Sub(p.lastExplain) < p.explainInterval {
+		return false
+	}
+	p.lastExplain = now
+	e.explainedAt = now
+	return true
+}
+
+func (p *Plugin) setPlan(fingerprint, plan string) {
+	v, ok := p.entries.Load(fingerprint)
+	if !ok {
+		return
+	}
+	e := v.(*entry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.plan = plan
+	e.plannedAt = time.Now()
+}
+
+// Top returns the n fingerprints with the most total time, n <= 0 returns all.
+func (p *Plugin) Top(n int) []Stats {
+	return p.top(n, "total")
+}
+
+func (p *Plugin) top(n int, by string) []Stats {
+	list := make([]Stats, 0)
+	p.entries.Range(func(fingerprint, v any) bool {
+		e := v.(*entry)
+		e.mu.Lock()
+		list = append(list, e.stats(fingerprint.(string)))
+		e.mu.Unlock()
+		return true
+	})
+
+	key := func(s Stats) int64 {
+		switch by {
+		case "count":
+			return s.Count
+		case "slow":
+			return s.Slow
+		case "p50":
+			return int64(s.P50)
+		case "p99":
+			return int64(s.P99)
+		default:
+			return int64(s.Total)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if ki, kj := key(list[i]), key(list[j]); ki != kj {
+			return ki > kj
+		}
+		return list[i].Fingerprint < list[j].Fingerprint
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// Reset clears the aggregated statistics.
+func (p *Plugin) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.entries.Clear()
+	p.count = 0
+}
+
+func (e *entry) stats(fingerprint string) Stats {
+	samples := append([]time.Duration(nil), e.samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	return Stats{
+		Fingerprint: fingerprint,
+		Count:       e.count,
+		Slow:        e.slow,
+		Total:       e.total,
+		P50:         percentile(samples, 0.5),
+		P99:         percentile(samples, 0.99),
+		Plan:        e.plan,
+		ExplainedAt: e.plannedAt,
+	}
+}
+
+// percentile of the sorted samples by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
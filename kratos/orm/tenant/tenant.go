@@ -0,0 +1,184 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	callBackBeforeName = "tenant:before"
+	scopedSettingKey   = "tenant:scoped"
+)
+
+var (
+	ErrMissingTenant = errors.New("tenant: missing tenant id in context")
+)
+
+type tenantKey struct{}
+type unscopedKey struct{}
+
+// NewContext returns a new context that carries the tenant id.
+func NewContext(ctx context.Context, id interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant id in ctx.
+func FromContext(ctx context.Context) (interface{}, bool) {
+	id := ctx.Value(tenantKey{})
+	return id, id != nil
+}
+
+// Unscoped returns a new context that disables the tenant scoping, it is the only way to access rows across tenants.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unscopedKey{}, true)
+}
+
+func isUnscoped(ctx context.Context) bool {
+	unscoped, _ := ctx.Value(unscopedKey{}).(bool)
+	return unscoped
+}
+
+// Plugin scopes the statements of the models carrying the tenant field to the tenant in ctx.
+//
+//   - query / update / delete / row get `tenant_id = ?` injected.
+//   - create sets the tenant field.
+//   - a statement without tenant in ctx fails with ErrMissingTenant, unless ctx is Unscoped.
+//
+// Raw / Exec SQL is not rewritten.
+type Plugin struct {
+	column string
+}
+
+type Option func(*Plugin)
+
+// WithColumn set the tenant column name. default is tenant_id.
+func WithColumn(column string) Option {
+	return func(p *Plugin) {
+		p.column = column
+	}
+}
+
+func New(opts ...Option) *Plugin {
+	p := &Plugin{
+		column: "tenant_id",
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Plugin) Name() string {
+	return "TenantPlugin"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		name string
+		err  error
+	}{
+		{"create", cb.Create().Before("gorm:before_create").Register(callBackBeforeName+"_create", p.create)},
+		{"query", cb.Query().Before("gorm:query").Register(callBackBeforeName+"_query", p.scope)},
+		{"update", cb.Update().Before("gorm:update").Register(callBackBeforeName+"_update", p.scope)},
+		{"delete", cb.Delete().Before("gorm:delete").Register(callBackBeforeName+"_delete", p.scope)},
+		{"row", cb.Row().Before("gorm:row").Register(callBackBeforeName+"_row", p.scope)},
+	}
+	for _, h := range hooks {
+		if h.err != nil {
+			return fmt.Errorf("register %s hook: %w", h.name, h.err)
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) scope(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || isUnscoped(stmt.Context) {
+		return
+	}
+	field := stmt.Schema.LookUpField(p.column)
+	if field == nil {
+		return
+	}
+
+	id, ok := FromContext(stmt.Context)
+	if !ok {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrMissingTenant, stmt.Schema.Table))
+		return
+	}
+
+	// a chained statement, e.g. Count then Find, is scoped once,
+	// a chain reused under another tenant has the condition of the previous tenant replaced.
+	expr := clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id}
+	prev, scoped := stmt.Settings.Load(scopedSettingKey)
+	if scoped && reflect.DeepEqual(prev, expr) {
+		return
+	}
+	stmt.Settings.Store(scopedSettingKey, expr)
+	if scoped && rescope(stmt, prev, expr) {
+		return
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{expr}})
+}
+
+// rescope replaces the tenant condition prev in the WHERE clause of stmt with expr, false if prev is not found.
+func rescope(stmt *gorm.Statement, prev interface{}, expr clause.Expression) bool {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return false
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return false
+	}
+	for i, e := range where.Exprs {
+		if reflect.DeepEqual(e, prev) {
+			// the exprs are shared with the statement the chain was cloned from.
+			exprs := append([]clause.Expression(nil), where.Exprs...)
+			exprs[i] = expr
+			c.Expression = clause.Where{Exprs: exprs}
+			stmt.Clauses["WHERE"] = c
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plugin) create(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || isUnscoped(stmt.Context) {
+		return
+	}
+	field := stmt.Schema.LookUpField(p.column)
+	if field == nil {
+		return
+	}
+
+	id, ok := FromContext(stmt.Context)
+	if !ok {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrMissingTenant, stmt.Schema.Table))
+		return
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			if err := field.Set(stmt.Context, reflect.Indirect(stmt.ReflectValue.Index(i)), id); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(stmt.Context, stmt.ReflectValue, id); err != nil {
+			_ = db.AddError(err)
+		}
+	case reflect.Map:
+		stmt.SetColumn(field.DBName, id)
+	}
+}
@@ -0,0 +1,116 @@
+package tenant_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/omalloc/contrib/kratos/orm"
+	"github.com/omalloc/contrib/kratos/orm/crud"
+	"github.com/omalloc/contrib/kratos/orm/tenant"
+	"github.com/omalloc/contrib/protobuf"
+)
+
+type Domain struct {
+	ID       int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	TenantID int64  `gorm:"column:tenant_id;index;"`
+	Name     string `gorm:"column:name;"`
+}
+
+// Setting has no tenant field, it is never scoped.
+type Setting struct {
+	ID   int64  `gorm:"column:id;primaryKey;autoIncrement;"`
+	Name string `gorm:"column:name;"`
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := orm.New(
+		orm.WithDriver(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared")),
+		orm.WithPlugins(tenant.New()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Domain{}, &Setting{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPlugin(t *testing.T) {
+	db := newTestDB(t)
+	t1 := tenant.NewContext(context.Background(), int64(1))
+	t2 := tenant.NewContext(context.Background(), int64(2))
+
+	// tenant is set on create.
+	assert.NoError(t, db.WithContext(t1).Create(&Domain{Name: "a.com"}).Error)
+	assert.NoError(t, db.WithContext(t1).Create([]*Domain{{Name: "b.com"}, {Name: "c.com"}}).Error)
+	assert.NoError(t, db.WithContext(t2).Create(&Domain{Name: "d.com", TenantID: 1}).Error)
+
+	var domains []*Domain
+	assert.NoError(t, db.WithContext(t1).Find(&domains).Error)
+	assert.Len(t, domains, 3)
+
+	assert.NoError(t, db.WithContext(t2).Find(&domains).Error)
+	if assert.Len(t, domains, 1) {
+		assert.Equal(t, int64(2), domains[0].TenantID)
+	}
+
+	// count then find on the same statement.
+	pagination := protobuf.PageWrap(nil)
+	list, err := crud.New[Domain](db).SelectList(t1, pagination)
+	assert.NoError(t, err)
+	assert.Len(t, list, 3)
+	assert.Equal(t, int32(3), pagination.Resp().Total)
+
+	// a chain reused under another tenant is scoped to that tenant only.
+	chain := db.WithContext(t1).Model(&Domain{}).Where("name <> ?", "x.com")
+	var n int64
+	assert.NoError(t, chain.Count(&n).Error)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, chain.WithContext(t2).Find(&domains).Error)
+	if assert.Len(t, domains, 1) {
+		assert.Equal(t, int64(2), domains[0].TenantID)
+	}
+	assert.NoError(t, chain.WithContext(t1).Find(&domains).Error)
+	assert.Len(t, domains, 3)
+
+	// other tenant rows are not visible for update and delete.
+	result := db.WithContext(t2).Model(&Domain{}).Where("name = ?", "a.com").Update("name", "x.com")
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	result = db.WithContext(t2).Where("name = ?", "a.com").Delete(&Domain{})
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	result = db.WithContext(t1).Where("name = ?", "a.com").Delete(&Domain{})
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	// unscoped escape hatch.
+	var total int64
+	assert.NoError(t, db.WithContext(tenant.Unscoped(context.Background())).Model(&Domain{}).Count(&total).Error)
+	assert.Equal(t, int64(3), total)
+}
+
+func TestPluginMissingTenant(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	var domains []*Domain
+	assert.ErrorIs(t, db.WithContext(ctx).Find(&domains).Error, tenant.ErrMissingTenant)
+	assert.ErrorIs(t, db.WithContext(ctx).Create(&Domain{Name: "a.com"}).Error, tenant.ErrMissingTenant)
+	assert.ErrorIs(t, db.WithContext(ctx).Where("id = ?", 1).Delete(&Domain{}).Error, tenant.ErrMissingTenant)
+
+	// models without the tenant field are not scoped.
+	assert.NoError(t, db.WithContext(ctx).Create(&Setting{Name: "setting"}).Error)
+	var settings []*Setting
+	assert.NoError(t, db.WithContext(ctx).Find(&settings).Error)
+	assert.Len(t, settings, 1)
+}
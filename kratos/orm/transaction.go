@@ -2,29 +2,94 @@ package orm
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 
 	"gorm.io/gorm"
 )
 
+var (
+	ErrTransactionExists = errors.New("orm: existing transaction found with propagation never")
+)
+
 // txContextKey gorm database transaction context key
 type txContextKey struct{}
 
+// Propagation defines how a transaction behaves when ctx already carries one.
+type Propagation int
+
+const (
+	// PropagationRequired joins the current transaction, or starts a new one if there is none. (default)
+	PropagationRequired Propagation = iota
+	// PropagationRequiresNew always starts a new transaction on a separate connection,
+	// the current transaction is suspended until it finishes.
+	PropagationRequiresNew
+	// PropagationNested runs within a SAVEPOINT of the current transaction,
+	// so a failure only rolls back to the savepoint. Behaves like PropagationRequired if there is none.
+	PropagationNested
+	// PropagationSupports joins the current transaction, or runs non-transactionally if there is none.
+	PropagationSupports
+	// PropagationNotSupported always runs non-transactionally, the current transaction is suspended.
+	PropagationNotSupported
+	// PropagationNever runs non-transactionally, and fails with ErrTransactionExists if there is a current transaction.
+	PropagationNever
+)
+
 type DataSourceManager interface {
 	GetDataSource() *gorm.DB
 }
 
 type Transaction interface {
-	Transaction(context.Context, func(ctx context.Context) error) error
+	Transaction(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error
 	WithContext(context.Context) *gorm.DB
 }
 
+type txOptions struct {
+	propagation Propagation
+	sqlOpts     *sql.TxOptions
+	retry       *RetryPolicy
+}
+
+type TxOption func(*txOptions)
+
+// WithPropagation set transaction propagation. default is PropagationRequired.
+func WithPropagation(propagation Propagation) TxOption {
+	return func(o *txOptions) {
+		o.propagation = propagation
+	}
+}
+
+// WithIsolation set transaction isolation level, only used when a new transaction is started.
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.sqlTxOptions().Isolation = level
+	}
+}
+
+// WithReadOnly mark the transaction read-only, only used when a new transaction is started.
+func WithReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.sqlTxOptions().ReadOnly = true
+	}
+}
+
+func (o *txOptions) sqlTxOptions() *sql.TxOptions {
+	if o.sqlOpts == nil {
+		o.sqlOpts = &sql.TxOptions{}
+	}
+	return o.sqlOpts
+}
+
 type transactionManager struct {
-	dsm DataSourceManager
+	dsm  DataSourceManager
+	opts []TxOption
 }
 
-func NewTransactionManager(dsm DataSourceManager) Transaction {
+// NewTransactionManager opts are the default options of every transaction, e.g. WithRetry.
+func NewTransactionManager(dsm DataSourceManager, opts ...TxOption) Transaction {
 	return &transactionManager{
-		dsm: dsm,
+		dsm:  dsm,
+		opts: opts,
 	}
 }
 
@@ -33,27 +98,127 @@ func (tm *transactionManager) WithContext(ctx context.Context) *gorm.DB {
 		return tm.dsm.GetDataSource()
 	}
 
-	if tx, ok := ctx.Value(txContextKey{}).(*gorm.DB); ok {
-		return tx
+	if tc := txFromContext(ctx); tc != nil && tc.db != nil {
+		return tc.db.WithContext(ctx)
 	}
 
 	return tm.dsm.GetDataSource().WithContext(ctx)
 }
 
-func (tm *transactionManager) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+func (tm *transactionManager) Transaction(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
 	if ctx == nil {
-		return tm.with(context.Background(), fn)
+		ctx = context.Background()
+	}
+
+	o := &txOptions{
+		propagation: PropagationRequired,
+	}
+	for _, opt := range tm.opts {
+		opt(o)
+	}
+	for _, opt := range opts {
+		opt(o)
 	}
 
-	if _, ok := ctx.Value(txContextKey{}).(*gorm.DB); ok {
+	tc := txFromContext(ctx)
+	switch o.propagation {
+	case PropagationRequiresNew:
+		return tm.with(ctx, fn, o)
+	case PropagationNested:
+		if tc != nil {
+			return tm.nested(ctx, tc, fn)
+		}
+		return tm.with(ctx, fn, o)
+	case PropagationSupports:
 		return fn(ctx)
+	case PropagationNotSupported:
+		if tc != nil {
+			// suspend the current transaction.
+			ctx = context.WithValue(ctx, txContextKey{}, (*txContext)(nil))
+		}
+		return fn(ctx)
+	case PropagationNever:
+		if tc != nil {
+			return ErrTransactionExists
+		}
+		return fn(ctx)
+	default:
+		if tc != nil {
+			return fn(ctx)
+		}
+		return tm.with(ctx, fn, o)
 	}
+}
 
-	return tm.with(ctx, fn)
+func (tm *transactionManager) with(ctx context.Context, fn func(ctx context.Context) error, o *txOptions) error {
+	var hooks *txHooks
+	err := o.retry.retry(ctx, func() error {
+		// hooks registered by a failed attempt are dropped when it is retried.
+		hooks = &txHooks{}
+		return tm.dsm.GetDataSource().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txContextKey{}, &txContext{db: tx, hooks: hooks}))
+		}, o.sqlOpts)
+	})
+
+	// the outermost transaction has finished, run the registered hooks.
+	hooks.run(ctx, err == nil)
+	return err
 }
 
-func (tm *transactionManager) with(ctx context.Context, fn func(ctx context.Context) error) error {
-	return tm.dsm.GetDataSource().Transaction(func(tx *gorm.DB) error {
-		return fn(context.WithValue(ctx, txContextKey{}, tx))
+// nested gorm uses SAVEPOINT / ROLLBACK TO when Transaction is called on a running transaction.
+// a transaction of NewTxContext without a *gorm.DB has no savepoint, it is joined.
+func (tm *transactionManager) nested(ctx context.Context, parent *txContext, fn func(ctx context.Context) error) error {
+	if parent.db == nil {
+		return fn(ctx)
+	}
+
+	hooks := &txHooks{}
+	err := parent.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txContextKey{}, &txContext{db: tx, hooks: hooks}))
 	})
+
+	parent.hooks.merge(hooks, err == nil)
+	return err
+}
+
+// txContext is the transaction bound to ctx.
+type txContext struct {
+	db    *gorm.DB
+	hooks *txHooks
+}
+
+// txFromContext returns the transaction bound to ctx, nil if there is none or it has been suspended.
+func txFromContext(ctx context.Context) *txContext {
+	tc, _ := ctx.Value(txContextKey{}).(*txContext)
+	return tc
+}
+
+// NewTxContext binds tx to ctx as the running transaction, for the Transaction implementations
+// other than the TransactionManager, e.g. the fakes of the tests. tx may be nil if there is no database.
+//
+// finish runs the hooks registered by AfterCommit and AfterRollback on the returned ctx,
+// it must be called once the transaction has been committed or rolled back.
+func NewTxContext(ctx context.Context, tx *gorm.DB) (txCtx context.Context, finish func(committed bool)) {
+	hooks := &txHooks{}
+	txCtx = context.WithValue(ctx, txContextKey{}, &txContext{db: tx, hooks: hooks})
+	return txCtx, func(committed bool) {
+		hooks.run(ctx, committed)
+	}
+}
+
+// FromContext returns the *gorm.DB of the transaction bound to ctx by the TransactionManager,
+// the *gorm.DB is nil for a transaction of NewTxContext without a database.
+func FromContext(ctx context.Context) (*gorm.DB, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	tc := txFromContext(ctx)
+	if tc == nil {
+		return nil, false
+	}
+	if tc.db == nil {
+		return nil, true
+	}
+	return tc.db.WithContext(ctx), true
 }
@@ -0,0 +1,112 @@
+package orm
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+type hookKind int
+
+const (
+	hookAfterCommit hookKind = iota
+	hookAfterRollback
+	hookAlways
+)
+
+type txHook struct {
+	kind hookKind
+	fn   func(ctx context.Context)
+}
+
+// txHooks callbacks registered on a transaction, in registration order.
+type txHooks struct {
+	mu    sync.Mutex
+	hooks []txHook
+}
+
+func (h *txHooks) add(kind hookKind, fn func(ctx context.Context)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.hooks = append(h.hooks, txHook{kind: kind, fn: fn})
+}
+
+// merge moves the hooks of a finished nested transaction to its parent.
+//
+// a nested transaction rolled back to its savepoint drops the AfterCommit hooks,
+// its AfterRollback hooks will run when the outermost transaction finishes whatever the outcome.
+func (h *txHooks) merge(child *txHooks, committed bool) {
+	child.mu.Lock()
+	hooks := child.hooks
+	child.hooks = nil
+	child.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, hook := range hooks {
+		if !committed {
+			if hook.kind == hookAfterCommit {
+				continue
+			}
+			hook.kind = hookAlways
+		}
+		h.hooks = append(h.hooks, hook)
+	}
+}
+
+func (h *txHooks) run(ctx context.Context, committed bool) {
+	h.mu.Lock()
+	hooks := h.hooks
+	h.hooks = nil
+	h.mu.Unlock()
+
+	for _, hook := range hooks {
+		switch {
+		case hook.kind == hookAlways,
+			hook.kind == hookAfterCommit && committed,
+			hook.kind == hookAfterRollback && !committed:
+			runHook(ctx, hook.fn)
+		}
+	}
+}
+
+// runHook runs fn, a panic is recovered and logged so the following hooks still run.
+func runHook(ctx context.Context, fn func(ctx context.Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Context(ctx).Errorf("orm: transaction hook panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	fn(ctx)
+}
+
+// AfterCommit registers fn to be called after the outermost transaction in ctx has been committed.
+//
+// fn is called immediately if there is no transaction in ctx.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if tc := txFromContext(ctx); tc != nil {
+		tc.hooks.add(hookAfterCommit, fn)
+		return
+	}
+
+	runHook(ctx, fn)
+}
+
+// AfterRollback registers fn to be called after the outermost transaction in ctx has been rolled back.
+//
+// fn is not called if there is no transaction in ctx: the statements are committed one by one as they run,
+// there is no rollback to compensate for, and running fn right away would undo the writes that succeeded.
+// without a transaction, handle the error of the failed statement instead.
+func AfterRollback(ctx context.Context, fn func(ctx context.Context)) {
+	if tc := txFromContext(ctx); tc != nil {
+		tc.hooks.add(hookAfterRollback, fn)
+		return
+	}
+
+	log.Context(ctx).Debugf("orm: AfterRollback without a transaction is ignored")
+}
@@ -0,0 +1,134 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+
+	sqliteErrBusy   = 5
+	sqliteErrLocked = 6
+
+	dbTxAttemptsKey = attribute.Key("db.transaction.attempts")
+)
+
+// RetryPolicy re-runs the whole transaction with a fresh tx when it fails with a retryable error.
+//
+// it only applies to the outermost transaction, a joined or nested one is never retried on its own.
+type RetryPolicy struct {
+	// MaxAttempts the maximum number of attempts, including the first one.
+	MaxAttempts int
+	// Backoff returns the delay before the n-th retry (starts at 1), no delay if nil.
+	Backoff func(n int) time.Duration
+	// Retryable reports whether err is retryable, IsRetryable is used if nil.
+	Retryable func(err error) bool
+}
+
+// WithRetry set transaction retry policy.
+func WithRetry(policy RetryPolicy) TxOption {
+	return func(o *txOptions) {
+		o.retry = &policy
+	}
+}
+
+// ExponentialBackoff returns a backoff doubling from base up to max, with up to 50% random jitter.
+func ExponentialBackoff(base, max time.Duration) func(n int) time.Duration {
+	return func(n int) time.Duration {
+		d := base << (n - 1)
+		if d <= 0 || d > max {
+			d = max
+		}
+		return d/2 + rand.N(d/2+1)
+	}
+}
+
+// IsRetryable reports whether err is a transient transaction error worth retrying,
+// such as mysql deadlock (1213) / lock wait timeout (1205) and sqlite BUSY / LOCKED.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+
+	// both glebarez/go-sqlite and modernc.org/sqlite errors carry the result code.
+	var sqliteErr interface {
+		error
+		Code() int
+	}
+	if errors.As(err, &sqliteErr) {
+		// primary result code, drop the extended bits. e.g. SQLITE_BUSY_SNAPSHOT
+		code := sqliteErr.Code() & 0xff
+		return code == sqliteErrBusy || code == sqliteErrLocked
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+func (p *RetryPolicy) wait(ctx context.Context, n int) error {
+	if p.Backoff == nil {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(p.Backoff(n))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retry runs attempt until it succeeds or the policy gives up, recording the attempts on the span in ctx.
+func (p *RetryPolicy) retry(ctx context.Context, attempt func() error) error {
+	var (
+		span = trace.SpanFromContext(ctx)
+		max  = p.attempts()
+		err  error
+	)
+	for n := 1; ; n++ {
+		if err = attempt(); err == nil || n >= max || !p.retryable(err) {
+			if max > 1 {
+				span.SetAttributes(dbTxAttemptsKey.Int(n))
+			}
+			return err
+		}
+
+		span.AddEvent("transaction retry", trace.WithAttributes(
+			dbTxAttemptsKey.Int(n),
+			attribute.String("error", err.Error()),
+		))
+		if werr := p.wait(ctx, n); werr != nil {
+			span.SetAttributes(dbTxAttemptsKey.Int(n))
+			return err
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package protobuf
+
+func CursorWrap(p *CursorPagination) *CursorPagination {
+	if p == nil {
+		p = &CursorPagination{}
+	}
+	// 如果 PageSize 传入的参数为空，那么就使用默认值 20
+	if p.PageSize <= 0 {
+		p.PageSize = 20
+	}
+	return &CursorPagination{
+		PageSize:  p.PageSize,
+		PageToken: p.PageToken,
+	}
+}
+
+// Limit 返回游标分页查询的限制数, 是 PageSize 的别名
+func (p *CursorPagination) Limit() int {
+	return int(p.PageSize)
+}
@@ -23,3 +23,14 @@ func TestPaginationWrap(t *testing.T) {
 	assert.Equal(t, p.Limit(), 100)
 	assert.Equal(t, p.Offset(), 0)
 }
+
+func TestCursorWrap(t *testing.T) {
+	p := protobuf.CursorWrap(nil)
+	assert.Equal(t, p.PageSize, int32(20))
+	assert.Equal(t, p.Limit(), 20)
+
+	p = protobuf.CursorWrap(&protobuf.CursorPagination{PageSize: 100, PageToken: "token", NextPageToken: "next"})
+	assert.Equal(t, p.Limit(), 100)
+	assert.Equal(t, p.PageToken, "token")
+	assert.Empty(t, p.NextPageToken)
+}